@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "hikup.yaml", "include_containers:\n  - \"*\"\nexclude_containers:\n  - database\n")
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(cfg.IncludeContainers) != 1 || len(cfg.ExcludeContainers) != 1 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+	}{
+		{"unknown format", "hikup.toml", ""},
+		{"bad json", "hikup.json", "{"},
+		{"included and excluded", "hikup.json", `{"include_containers": ["web"], "exclude_containers": ["web"]}`},
+		{"wildcard exclude", "hikup.json", `{"exclude_containers": ["*"]}`},
+		{"empty name", "hikup.json", `{"include_containers": [""]}`},
+	}
+	for _, tt := range tests {
+		if _, err := loadConfig(writeConfig(t, tt.file, tt.content)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
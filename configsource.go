@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+// configStdin is where "-c -" reads the configuration from.
+var configStdin io.Reader = os.Stdin
+
+// stdinConfigRead is set once the configuration was read from stdin, which
+// can only be done once.
+var stdinConfigRead bool
+
+// configValidators identify the version of a configuration fetched over
+// HTTP, for conditional requests.
+type configValidators struct {
+	etag, lastModified string
+}
+
+// reloadMu serializes configuration reloads.
+var reloadMu sync.Mutex
+
+// remoteConfig holds the validators of the configuration currently in
+// effect, if it was fetched over HTTP. Guarded by reloadMu.
+var remoteConfig configValidators
+
+// errConfigNotModified is returned for a remote configuration that has not
+// changed since it was last loaded.
+var errConfigNotModified = errors.New("configuration not modified")
+
+const configFetchTimeout = 30 * time.Second
+
+func isConfigURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// readConfigSource returns the raw configuration at source and its format,
+// "json" or "yaml". source is a file path, "-" for stdin or an http(s) URL.
+// For URLs, prev makes the request conditional; errConfigNotModified is
+// returned if the server reports no change.
+func readConfigSource(source string, prev configValidators) ([]byte, string, configValidators, error) {
+	switch {
+	case source == "-":
+		data, err := io.ReadAll(configStdin)
+		stdinConfigRead = true
+		if err != nil {
+			return nil, "", configValidators{}, fmt.Errorf("error reading config from stdin: %w", err)
+		}
+		// JSON is valid YAML
+		return data, "yaml", configValidators{}, nil
+	case isConfigURL(source):
+		return fetchConfig(source, prev)
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, "", configValidators{}, fmt.Errorf("error reading config file: %w", err)
+	}
+	format, err := configFormat(filepath.Ext(source))
+	return data, format, configValidators{}, err
+}
+
+func configFormat(ext string) (string, error) {
+	switch strings.ToLower(ext) {
+	case ".json":
+		return "json", nil
+	case ".yaml", ".yml":
+		return "yaml", nil
+	}
+	return "", fmt.Errorf("unsupported config file format: %s", ext)
+}
+
+// fetchConfig GETs the configuration at url. The format is taken from the
+// URL's extension, then the Content-Type, and defaults to YAML.
+func fetchConfig(url string, prev configValidators) ([]byte, string, configValidators, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", configValidators{}, fmt.Errorf("error fetching config: %w", err)
+	}
+	if prev.etag != "" {
+		req.Header.Set("If-None-Match", prev.etag)
+	}
+	if prev.lastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.lastModified)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", configValidators{}, fmt.Errorf("error fetching config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, "", prev, errConfigNotModified
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", configValidators{}, fmt.Errorf("error fetching config: unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", configValidators{}, fmt.Errorf("error fetching config: %w", err)
+	}
+	validators := configValidators{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+
+	format, err := configFormat(path.Ext(req.URL.Path))
+	if err != nil {
+		format = "yaml"
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); strings.HasSuffix(mediaType, "json") {
+			format = "json"
+		}
+	}
+	return data, format, validators, nil
+}
+
+// loadConfig reads, parses and validates the configuration at path, which
+// is a file, "-" for stdin or an http(s) URL.
+func loadConfig(path string) (updater.Config, error) {
+	data, format, _, err := readConfigSource(path, configValidators{})
+	if err != nil {
+		return updater.Config{}, err
+	}
+	return updater.ParseConfig(data, format)
+}
+
+// reloadConfig loads the configuration from configPath and passes it to
+// apply. On any error apply is not called, so the current configuration
+// stays in effect.
+func reloadConfig(apply func(updater.Config)) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if configPath == "-" && stdinConfigRead {
+		return errors.New("a configuration read from stdin cannot be reloaded")
+	}
+
+	data, format, validators, err := readConfigSource(configPath, remoteConfig)
+	if errors.Is(err, errConfigNotModified) {
+		logger.Println("Configuration unchanged")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	newConfig, err := updater.ParseConfig(data, format)
+	if err != nil {
+		return err
+	}
+
+	apply(newConfig)
+	remoteConfig = validators
+
+	logger.Println("Configuration reloaded successfully")
+	return nil
+}
+
+// envConfig builds a configuration from HIKUP_* environment variables, for
+// deployments without a config file. List variables are comma-separated.
+// ok is false if none of the variables is set.
+func envConfig(lookup func(string) (string, bool)) (cfg updater.Config, ok bool, err error) {
+	list := func(name string, dst *[]string) {
+		if v, set := lookup(name); set {
+			ok = true
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+	str := func(name string, dst *string) {
+		if v, set := lookup(name); set {
+			ok = true
+			*dst = strings.TrimSpace(v)
+		}
+	}
+	var errs []error
+	duration := func(name string, dst *updater.Duration) {
+		if v, set := lookup(name); set {
+			ok = true
+			d, err := time.ParseDuration(strings.TrimSpace(v))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			*dst = updater.Duration(d)
+		}
+	}
+
+	list("HIKUP_INCLUDE", &cfg.IncludeContainers)
+	list("HIKUP_EXCLUDE", &cfg.ExcludeContainers)
+	list("HIKUP_INCLUDE_SERVICES", &cfg.IncludeServices)
+	list("HIKUP_EXCLUDE_SERVICES", &cfg.ExcludeServices)
+	duration("HIKUP_INTERVAL", &cfg.Interval)
+	str("HIKUP_SCHEDULE", &cfg.Schedule)
+	duration("HIKUP_STAGGER", &cfg.Stagger)
+	duration("HIKUP_HEALTH_TIMEOUT", &cfg.HealthTimeout)
+	str("HIKUP_SCOPE", &cfg.Scope)
+	list("HIKUP_NOTIFY_URLS", &cfg.NotifyURLs)
+
+	if err := errors.Join(errs...); err != nil {
+		return cfg, ok, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, ok, fmt.Errorf("invalid config from environment: %w", err)
+	}
+	return cfg, ok, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+func TestLoadConfigFromStdin(t *testing.T) {
+	configStdin = strings.NewReader(`{"include_containers": ["web"]}`)
+	defer func() { configStdin = os.Stdin; stdinConfigRead = false }()
+
+	cfg, err := loadConfig("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.IncludeContainers, []string{"web"}) {
+		t.Errorf("got include_containers %v, want [web]", cfg.IncludeContainers)
+	}
+}
+
+func TestReloadConfigFromURL(t *testing.T) {
+	body, status := "include_containers:\n  - web\n", http.StatusOK
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	configPath = srv.URL + "/hikup"
+	defer func() { configPath = ""; remoteConfig = configValidators{} }()
+
+	var config updater.Config
+	apply := func(c updater.Config) { config = c }
+
+	if err := reloadConfig(apply); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.IncludeContainers, []string{"web"}) {
+		t.Fatalf("got include_containers %v, want [web]", config.IncludeContainers)
+	}
+
+	// Unchanged: the server answers 304 and the config is kept
+	body = "include_containers:\n  - other\n"
+	if err := reloadConfig(apply); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.IncludeContainers, []string{"web"}) {
+		t.Errorf("got include_containers %v after 304, want [web]", config.IncludeContainers)
+	}
+
+	// Unreachable: the last good config stays in effect
+	status = http.StatusInternalServerError
+	if err := reloadConfig(apply); err == nil {
+		t.Error("expected an error for a failing endpoint")
+	}
+	if !reflect.DeepEqual(config.IncludeContainers, []string{"web"}) {
+		t.Errorf("got include_containers %v after a failed fetch, want [web]", config.IncludeContainers)
+	}
+	if requests != 3 {
+		t.Errorf("got %d requests, want 3", requests)
+	}
+}
+
+func TestEnvConfig(t *testing.T) {
+	env := map[string]string{
+		"HIKUP_INCLUDE":  "web, worker,",
+		"HIKUP_EXCLUDE":  "db",
+		"HIKUP_INTERVAL": "15m",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cfg, ok, err := envConfig(lookup)
+	if err != nil || !ok {
+		t.Fatalf("got ok=%v err=%v, want a configuration", ok, err)
+	}
+	if !reflect.DeepEqual(cfg.IncludeContainers, []string{"web", "worker"}) {
+		t.Errorf("got include_containers %v, want [web worker]", cfg.IncludeContainers)
+	}
+	if !reflect.DeepEqual(cfg.ExcludeContainers, []string{"db"}) {
+		t.Errorf("got exclude_containers %v, want [db]", cfg.ExcludeContainers)
+	}
+	if cfg.Interval != updater.Duration(15*time.Minute) {
+		t.Errorf("got interval %s, want 15m", cfg.Interval)
+	}
+
+	env["HIKUP_INTERVAL"] = "soon"
+	if _, _, err := envConfig(lookup); err == nil {
+		t.Error("expected an error for an invalid HIKUP_INTERVAL")
+	}
+
+	if _, ok, _ := envConfig(func(string) (string, bool) { return "", false }); ok {
+		t.Error("got ok without any HIKUP_* variable set")
+	}
+}
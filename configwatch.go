@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+)
+
+// configWatchDebounce is how long the config file must stay unchanged after
+// a write before it is reloaded, so an editor saving in several steps
+// triggers a single reload.
+const configWatchDebounce = 500 * time.Millisecond
+
+// debounce calls fire once events has been quiet for the quiet duration
+// after one or more events. It returns when events is closed.
+func debounce(events <-chan struct{}, quiet time.Duration, fire func()) {
+	timer := time.NewTimer(quiet)
+	timer.Stop()
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(quiet)
+		case <-timer.C:
+			fire()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchConfig calls changed whenever the config file at path is written,
+// replaced or created, debounced by configWatchDebounce. It watches the
+// directory holding the file, so editors and tools replacing the file with
+// a rename are noticed too. stop ends the watch.
+func watchConfig(path string, changed func()) (stop func(), err error) {
+	dir, name := filepath.Split(filepath.Clean(path))
+	if dir == "" {
+		dir = "."
+	}
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("error watching %s: %w", path, err)
+	}
+	const mask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("error watching %s: %w", path, err)
+	}
+	// A non-blocking file is read through the runtime poller, so Close
+	// interrupts a pending Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	events := make(chan struct{})
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+unix.SizeofInotifyEvent <= n; {
+				event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				off += unix.SizeofInotifyEvent
+				eventName := string(bytes.TrimRight(buf[off:off+int(event.Len)], "\x00"))
+				off += int(event.Len)
+				if eventName == name {
+					events <- struct{}{}
+				}
+			}
+		}
+	}()
+	go debounce(events, configWatchDebounce, changed)
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hikup.yaml")
+	if err := os.WriteFile(path, []byte("interval: 1h\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 10)
+	stop, err := watchConfig(path, func() { changed <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// Unrelated files in the same directory are ignored.
+	if err := os.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Replace the file the way editors do: write a temporary file, then
+	// rename it over the config.
+	tmp := filepath.Join(dir, ".hikup.yaml.swp")
+	if err := os.WriteFile(tmp, []byte("interval: 2h\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("interval: 3h\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the config file changed")
+	}
+	time.Sleep(2 * configWatchDebounce)
+	if n := len(changed); n != 0 {
+		t.Errorf("got %d more reloads, want the changes debounced into one", n)
+	}
+}
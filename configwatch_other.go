@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// watchConfig is only supported on Linux; elsewhere, reload the
+// configuration with SIGHUP.
+func watchConfig(path string, changed func()) (stop func(), err error) {
+	return nil, errors.New("watching the config file is only supported on Linux")
+}
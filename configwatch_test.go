@@ -0,0 +1,35 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDebounce(t *testing.T) {
+	events := make(chan struct{})
+	var fired atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		debounce(events, 50*time.Millisecond, func() { fired.Add(1) })
+		close(done)
+	}()
+
+	for i := 0; i < 5; i++ {
+		events <- struct{}{}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if got := fired.Load(); got != 1 {
+		t.Errorf("fired %d times for a burst of events, want 1", got)
+	}
+
+	events <- struct{}{}
+	time.Sleep(150 * time.Millisecond)
+	if got := fired.Load(); got != 2 {
+		t.Errorf("fired %d times after a second event, want 2", got)
+	}
+
+	close(events)
+	<-done
+}
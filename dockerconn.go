@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/lnksz/hikup/updater"
+)
+
+// Backoff between attempts to reconnect to the Docker daemon, doubling up to
+// maxReconnectBackoff. Variables so tests can shorten them.
+var (
+	reconnectBackoff    = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+const pingTimeout = 10 * time.Second
+
+// dockerDialer creates a Docker client and checks that the daemon answers.
+type dockerDialer func(ctx context.Context) (updater.DockerClient, error)
+
+// newDockerDialer returns a dockerDialer creating clients with opts, wrapped
+// for podman if needed.
+func newDockerDialer(opts []client.Opt, podman bool) dockerDialer {
+	return func(ctx context.Context) (updater.DockerClient, error) {
+		cli, err := client.NewClientWithOpts(opts...)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		defer cancel()
+		if _, err := cli.Ping(ctx); err != nil {
+			cli.Close()
+			return nil, fmt.Errorf("error pinging Docker: %w", err)
+		}
+		if podman {
+			return updater.PodmanCompat(cli), nil
+		}
+		return cli, nil
+	}
+}
+
+// reconnectDocker dials until the daemon answers, backing off between
+// attempts. It returns false if ctx is done first.
+func reconnectDocker(ctx context.Context, dial dockerDialer) (updater.DockerClient, bool) {
+	backoff := reconnectBackoff
+	for {
+		cli, err := dial(ctx)
+		if err == nil {
+			logger.Println("Reconnected to Docker")
+			return cli, true
+		}
+		logger.Printf("Error reconnecting to Docker, retrying in %s: %v", backoff, err)
+		retry := time.Now().Add(backoff)
+		if !sleepUntil(ctx, nil, func() time.Time { return retry }) {
+			return nil, false
+		}
+		backoff = min(2*backoff, maxReconnectBackoff)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/lnksz/hikup/updater"
+)
+
+func TestReconnectDocker(t *testing.T) {
+	reconnectBackoff, maxReconnectBackoff = time.Millisecond, 2*time.Millisecond
+	defer func() { reconnectBackoff, maxReconnectBackoff = time.Second, time.Minute }()
+
+	attempts := 0
+	want := &listClient{}
+	cli, ok := reconnectDocker(context.Background(), func(ctx context.Context) (updater.DockerClient, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return want, nil
+	})
+	if !ok || cli != want {
+		t.Fatalf("got %v, %v, want the client of the third attempt", cli, ok)
+	}
+	if attempts != 3 {
+		t.Errorf("got %d attempts, want 3", attempts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := reconnectDocker(ctx, func(ctx context.Context) (updater.DockerClient, error) {
+		return nil, errors.New("connection refused")
+	}); ok {
+		t.Error("reconnected although the context was done")
+	}
+}
+
+func TestDockerDialerPings(t *testing.T) {
+	host := "unix://" + filepath.Join(t.TempDir(), "docker.sock")
+	dial := newDockerDialer([]client.Opt{client.WithHost(host)}, false)
+	if _, err := dial(context.Background()); err == nil {
+		t.Error("no error dialing a daemon that does not answer")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// dockerEndpoint is the Docker endpoint of a docker CLI context.
+type dockerEndpoint struct {
+	Host          string
+	SkipTLSVerify bool
+	// TLSDir holds ca.pem, cert.pem and key.pem, if the context has any.
+	TLSDir string
+}
+
+// dockerConfigDir returns the docker CLI configuration directory,
+// $DOCKER_CONFIG or ~/.docker.
+func dockerConfigDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// loadDockerContext reads the Docker endpoint of the named docker CLI
+// context from the context store in the docker configuration directory.
+func loadDockerContext(name string) (dockerEndpoint, error) {
+	dir, err := dockerConfigDir()
+	if err != nil {
+		return dockerEndpoint{}, err
+	}
+	// The store keys contexts by the SHA-256 of their name
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+
+	data, err := os.ReadFile(filepath.Join(dir, "contexts", "meta", id, "meta.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return dockerEndpoint{}, fmt.Errorf("docker context %q not found", name)
+	}
+	if err != nil {
+		return dockerEndpoint{}, fmt.Errorf("error reading docker context %q: %w", name, err)
+	}
+
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string
+			SkipTLSVerify bool
+		}
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return dockerEndpoint{}, fmt.Errorf("error parsing docker context %q: %w", name, err)
+	}
+	ep, ok := meta.Endpoints["docker"]
+	if !ok || ep.Host == "" {
+		return dockerEndpoint{}, fmt.Errorf("docker context %q has no docker endpoint", name)
+	}
+
+	endpoint := dockerEndpoint{Host: ep.Host, SkipTLSVerify: ep.SkipTLSVerify}
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err == nil {
+		endpoint.TLSDir = tlsDir
+	}
+	return endpoint, nil
+}
+
+// contextClientOpts returns the Docker client options connecting to the
+// endpoint of the named docker CLI context.
+func contextClientOpts(name string) ([]client.Opt, error) {
+	ep, err := loadDockerContext(name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(ep.Host, "ssh://") {
+		return nil, fmt.Errorf("docker context %q: ssh endpoints are not supported", name)
+	}
+
+	var opts []client.Opt
+	if ep.TLSDir != "" || ep.SkipTLSVerify {
+		options := tlsconfig.Options{InsecureSkipVerify: ep.SkipTLSVerify}
+		if ep.TLSDir != "" {
+			for file, field := range map[string]*string{"ca.pem": &options.CAFile, "cert.pem": &options.CertFile, "key.pem": &options.KeyFile} {
+				if path := filepath.Join(ep.TLSDir, file); fileExists(path) {
+					*field = path
+				}
+			}
+		}
+		tlsConfig, err := tlsconfig.Client(options)
+		if err != nil {
+			return nil, fmt.Errorf("docker context %q: %w", name, err)
+		}
+		opts = append(opts, client.WithHTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}))
+	}
+	return append(opts, client.WithHost(ep.Host)), nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDockerContext(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	sum := sha256.Sum256([]byte("remote"))
+	id := hex.EncodeToString(sum[:])
+	meta := filepath.Join(dir, "contexts", "meta", id)
+	tls := filepath.Join(dir, "contexts", "tls", id, "docker")
+	for _, d := range []string{meta, tls} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := `{"Name":"remote","Metadata":{},"Endpoints":{"docker":{"Host":"tcp://10.0.0.2:2376","SkipTLSVerify":false}}}`
+	if err := os.WriteFile(filepath.Join(meta, "meta.json"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := loadDockerContext("remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dockerEndpoint{Host: "tcp://10.0.0.2:2376", TLSDir: tls}
+	if ep != want {
+		t.Errorf("got endpoint %+v, want %+v", ep, want)
+	}
+
+	if _, err := loadDockerContext("missing"); err == nil {
+		t.Error("expected an error for an unknown context")
+	}
+}
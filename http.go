@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+// startHTTPServer serves the metrics endpoint and the HTTP API for u on addr
+// in the background. With webUI, it also serves the status page and lets
+// updates be triggered from it.
+func startHTTPServer(addr string, u *updater.Updater, webUI bool) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		updater.WriteMetrics(w)
+	})
+	mux.HandleFunc("GET /history/{name}", historyHandler(u))
+	mux.HandleFunc("POST /webhook/{type}", webhookHandler(u))
+	mux.HandleFunc("POST /update/{name}", updateHandler(u))
+	if webUI {
+		mux.HandleFunc("GET /{$}", uiHandler(u))
+		mux.HandleFunc("POST /ui/update/{name}", uiUpdateHandler(u))
+	}
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"status": "ok", "version": version, "commit": commit, "build_date": buildDate})
+	})
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Printf("HTTP server on %s failed: %v", addr, err)
+		}
+	}()
+}
+
+func historyHandler(u *updater.Updater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := u.History(r.PathValue("name"))
+		if errors.Is(err, updater.ErrHistoryDisabled) {
+			http.Error(w, "update history is not enabled, see --history-file", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, entries)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Printf("Error writing HTTP response: %v", err)
+	}
+}
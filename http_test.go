@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/lnksz/hikup/updater"
+)
+
+func TestHistoryAPI(t *testing.T) {
+	u := updater.New(nil, updater.Config{}, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /history/{name}", historyHandler(u))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/history/web", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d without --history-file, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	data := `{"container":"web","from":"sha256:1","to":"sha256:2"}` + "\n" +
+		`{"container":"db","from":"sha256:a","to":"sha256:b"}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	u.SetHistoryFile(path)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/history/web", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	var entries []updater.HistoryEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].To != "sha256:2" {
+		t.Errorf("unexpected history %+v", entries)
+	}
+}
+
+// imageClient also resolves every image reference to the image "sha256:new".
+type imageClient struct {
+	listClient
+}
+
+func (c *imageClient) ImageInspectWithRaw(ctx context.Context, ref string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{ID: "sha256:new"}, nil, nil
+}
+
+func TestWebUI(t *testing.T) {
+	cli := &imageClient{listClient{containers: []types.Container{
+		{ID: "1", Names: []string{"/web"}, Image: "nginx:latest", ImageID: "sha256:old", State: "running"},
+	}}}
+	u := updater.New(cli, updater.Config{IncludeContainers: []string{"web"}}, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", uiHandler(u))
+	mux.HandleFunc("POST /ui/update/{name}", uiUpdateHandler(u))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	for _, want := range []string{"web", "nginx:latest", "newer image pulled", `action="/ui/update/web"`} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("status page does not contain %q", want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/ui/update/db", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d updating a missing container, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestUpdateAPI(t *testing.T) {
+	cli := &imageClient{listClient{containers: []types.Container{
+		{ID: "1", Names: []string{"/web"}, Image: "nginx:latest", ImageID: "sha256:old", State: "running"},
+	}}}
+	u := updater.New(cli, updater.Config{APIToken: "s3cret"}, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /update/{name}", updateHandler(u))
+
+	tests := []struct {
+		target, auth, body string
+		want               int
+	}{
+		{"/update/web", "", `{"image": "nginx:1.27"}`, http.StatusUnauthorized},
+		{"/update/web", "Bearer wrong", `{"image": "nginx:1.27"}`, http.StatusUnauthorized},
+		{"/update/web?token=wrong", "", `{"image": "nginx:1.27"}`, http.StatusUnauthorized},
+		{"/update/db", "Bearer s3cret", "", http.StatusNotFound},
+		{"/update/db?token=s3cret", "", "", http.StatusNotFound},
+		{"/update/web", "Bearer s3cret", `{"image": "Not An Image"}`, http.StatusBadRequest},
+		{"/update/web", "Bearer s3cret", `{"tag": "1.27"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s with %q and %s: got status %d, want %d", tt.target, tt.auth, tt.body, rec.Code, tt.want)
+		}
+	}
+
+	u = updater.New(cli, updater.Config{}, nil)
+	mux = http.NewServeMux()
+	mux.HandleFunc("POST /update/{name}", updateHandler(u))
+	req := httptest.NewRequest("POST", "/update/web", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d without an api_token, want %d", rec.Code, http.StatusNotFound)
+	}
+}
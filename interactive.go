@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+// isTerminal reports whether f is a character device such as a TTY.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// promptConfirm returns a confirmUpdate implementation asking on out and
+// reading the answer from in. Anything but "y" or "yes" declines.
+func promptConfirm(in io.Reader, out io.Writer) func(name, from, to string) bool {
+	r := bufio.NewReader(in)
+	return func(name, from, to string) bool {
+		fmt.Fprintf(out, "Update container %s from %s to %s? [y/N] ", name, updater.ShortImageID(from), updater.ShortImageID(to))
+		answer, err := r.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Fprintln(out)
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		}
+		return false
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPromptConfirm(t *testing.T) {
+	var out bytes.Buffer
+	confirm := promptConfirm(strings.NewReader("y\n\nno\nYES\n"), &out)
+
+	from := "sha256:aaaaaaaaaaaaaaaaaaaa"
+	to := "sha256:bbbbbbbbbbbbbbbbbbbb"
+	want := []bool{true, false, false, true, false /* EOF */}
+	for i, w := range want {
+		if got := confirm("web", from, to); got != w {
+			t.Errorf("answer %d: got %v, want %v", i, got, w)
+		}
+	}
+	if !strings.Contains(out.String(), "Update container web from aaaaaaaaaaaa to bbbbbbbbbbbb? [y/N] ") {
+		t.Errorf("unexpected prompt %q", out.String())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogRedialInterval limits how often a lost syslog connection is retried.
+const syslogRedialInterval = 30 * time.Second
+
+// fallbackWriter writes log lines to syslog, falling back to stderr while
+// syslog is unreachable and reconnecting periodically.
+type fallbackWriter struct {
+	dial     func() (io.WriteCloser, error)
+	fallback io.Writer
+
+	mu       sync.Mutex
+	w        io.WriteCloser // nil while disconnected
+	lastDial time.Time
+}
+
+func newSyslogWriter() *fallbackWriter {
+	fw := &fallbackWriter{
+		dial: func() (io.WriteCloser, error) {
+			return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "hikup")
+		},
+		fallback: os.Stderr,
+	}
+	fw.connect(time.Now())
+	return fw
+}
+
+// connect (re)dials syslog. It must be called with mu held, except during
+// construction.
+func (fw *fallbackWriter) connect(now time.Time) {
+	fw.lastDial = now
+	w, err := fw.dial()
+	if err != nil {
+		fmt.Fprintf(fw.fallback, "hikup: syslog unavailable, logging to stderr: %v\n", err)
+		return
+	}
+	fw.w = w
+}
+
+func (fw *fallbackWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	now := time.Now()
+	if fw.w == nil && now.Sub(fw.lastDial) >= syslogRedialInterval {
+		fw.connect(now)
+	}
+
+	if fw.w != nil {
+		_, err := fw.w.Write(p)
+		if err == nil {
+			return len(p), nil
+		}
+		fmt.Fprintf(fw.fallback, "hikup: syslog write failed, logging to stderr: %v\n", err)
+		fw.w.Close()
+		fw.w = nil
+	}
+	return fw.fallback.Write(p)
+}
+
+// dedupWriter suppresses error lines repeating one written within the last
+// window, e.g. the same pull error for a container on every scan while its
+// registry is down. Once the window has passed, how often a line was
+// suppressed is written with the next error line. Each Write must be a single
+// line, as written by log.Logger.
+type dedupWriter struct {
+	w      io.Writer
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	since      time.Time
+	suppressed int
+}
+
+func newDedupWriter(w io.Writer, window time.Duration) *dedupWriter {
+	return &dedupWriter{w: w, window: window, now: time.Now, seen: make(map[string]*dedupEntry)}
+}
+
+// isErrorLine reports whether line reports an error or failure, the lines
+// that repeat during outages.
+func isErrorLine(line string) bool {
+	line = strings.ToLower(line)
+	return strings.Contains(line, "error") || strings.Contains(line, "failed")
+}
+
+func (dw *dedupWriter) Write(p []byte) (int, error) {
+	line := string(p)
+	if !isErrorLine(line) {
+		return dw.w.Write(p)
+	}
+
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+
+	now := dw.now()
+	for l, e := range dw.seen {
+		if now.Sub(e.since) < dw.window {
+			continue
+		}
+		if e.suppressed > 0 {
+			fmt.Fprintf(dw.w, "last message repeated %d times in %s: %s", e.suppressed, dw.window, l)
+		}
+		delete(dw.seen, l)
+	}
+
+	if e, ok := dw.seen[line]; ok {
+		e.suppressed++
+		return len(p), nil
+	}
+	dw.seen[line] = &dedupEntry{since: now}
+	return dw.w.Write(p)
+}
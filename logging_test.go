@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSyslog struct {
+	bytes.Buffer
+	broken bool
+}
+
+func (f *fakeSyslog) Write(p []byte) (int, error) {
+	if f.broken {
+		return 0, errors.New("connection refused")
+	}
+	return f.Buffer.Write(p)
+}
+
+func (f *fakeSyslog) Close() error { return nil }
+
+func TestFallbackWriterReconnects(t *testing.T) {
+	conn := &fakeSyslog{}
+	var stderr bytes.Buffer
+	fw := &fallbackWriter{
+		dial:     func() (io.WriteCloser, error) { return conn, nil },
+		fallback: &stderr,
+	}
+	fw.connect(time.Now())
+
+	fw.Write([]byte("first\n"))
+	if conn.String() != "first\n" {
+		t.Fatalf("syslog got %q", conn.String())
+	}
+
+	// syslogd goes away: the line must still end up somewhere
+	conn.broken = true
+	fw.Write([]byte("second\n"))
+	if !strings.Contains(stderr.String(), "second\n") {
+		t.Fatalf("line lost while syslog is down, stderr: %q", stderr.String())
+	}
+
+	// syslogd is back; the writer redials once the interval has passed
+	conn.broken = false
+	fw.lastDial = time.Now().Add(-syslogRedialInterval)
+	fw.Write([]byte("third\n"))
+	if !strings.HasSuffix(conn.String(), "third\n") {
+		t.Errorf("writer did not reconnect, syslog got %q", conn.String())
+	}
+}
+
+func TestDedupWriter(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Unix(0, 0)
+	dw := newDedupWriter(&out, time.Minute)
+	dw.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		dw.Write([]byte("Error pulling image: registry down\n"))
+		dw.Write([]byte("Pulled latest image for container web\n"))
+	}
+	want := "Error pulling image: registry down\n" + strings.Repeat("Pulled latest image for container web\n", 3)
+	if out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	now = now.Add(time.Minute)
+	dw.Write([]byte("Error pulling image: registry down\n"))
+	want = "last message repeated 2 times in 1m0s: Error pulling image: registry down\n" +
+		"Error pulling image: registry down\n"
+	if out.String() != want {
+		t.Errorf("after the window got %q, want %q", out.String(), want)
+	}
+}
@@ -2,44 +2,118 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"log/syslog"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strings"
-	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/api/types/network"
+	"github.com/distribution/reference"
 	"github.com/docker/docker/client"
-	"gopkg.in/yaml.v3"
+	"github.com/lnksz/hikup/updater"
 )
 
-type Config struct {
-	IncludeContainers []string `json:"include_containers" yaml:"include_containers"`
-	ExcludeContainers []string `json:"exclude_containers" yaml:"exclude_containers"`
-}
-
 var (
-	config     Config
 	configPath string
-	configLock sync.RWMutex
 	logger     *log.Logger
 )
 
 func main() {
 	recreateAll := flag.Bool("a", false, "Recreate all running containers")
-	flag.StringVar(&configPath, "c", "", "Path to configuration file")
+	flag.StringVar(&configPath, "c", "", "Path or http(s) URL of the configuration file, or - to read it from stdin")
+	once := flag.Bool("once", false, "Run a single update scan and exit")
+	configCheck := flag.Bool("config-check", false, "Validate the configuration file given with -c and exit")
+	scope := flag.String("scope", "", "Only manage containers labeled hikup.scope=<scope> (overrides the scope config)")
+	dryRun := flag.Bool("dry-run", false, "Pull images and log which containers would be recreated and how their configuration would change, without recreating them")
+	includeSwarm := flag.Bool("include-swarm", false, "Also update containers managed by a swarm service")
+	since := flag.Duration("since", 0, "Only update containers created within this duration, e.g. 1h")
+	smokeTest := flag.Bool("smoke-test", false, "Start every new image in a throwaway container before updating, and skip the update if it does not start")
+	debug := flag.Bool("debug", false, "Log details such as why containers are skipped")
+	logDedup := flag.Duration("log-dedup", 0, "Suppress error log lines repeating within this duration, e.g. 1h")
+	noPull := flag.Bool("no-pull", false, "Do not pull images; recreate containers whose local image changed")
+	dumpEffective := flag.Bool("dump-config", false, "Print the effective configuration, including defaults, as YAML and exit")
+	printSchema := flag.Bool("print-schema", false, "Print a JSON Schema of the configuration file format and exit")
+	interactive := flag.Bool("interactive", false, "Ask for confirmation on the terminal before recreating each container")
+	candidates := flag.Bool("list-candidates", false, "Print which containers would be selected for updates and why, then exit")
+	stateFile := flag.String("state-file", "", "Path to persist per-container state in, e.g. /var/lib/hikup/state.json")
+	resultsFile := flag.String("results-file", "", "Path to write the results of every scan to as JSON")
+	resultsAppend := flag.Bool("results-append", false, "Append the results of every scan to --results-file as a JSON line instead of replacing it")
+	rollbackLast := flag.Bool("rollback-last", false, "Roll back the containers updated by the last scan recorded in --history-file to their previous images and exit")
+	historyFile := flag.String("history-file", "", "Path of a JSONL log recording every update, e.g. /var/lib/hikup/history.jsonl")
+	dockerContext := flag.String("context", "", "Name of the docker CLI context to connect with (overrides the docker_context config)")
+	runtimeName := flag.String("runtime", "docker", "Container engine behind the Docker API: docker or podman")
+	listenAddr := flag.String("listen", "", "Address to serve Prometheus metrics and the HTTP API on, e.g. :9090")
+	watchConfigFile := flag.Bool("watch-config", false, "Reload the configuration file given with -c whenever it changes, in addition to on SIGHUP (Linux only)")
+	watchImage := flag.String("watch-image", "", "Only update the containers running this image, e.g. nginx:latest, ignoring the include and exclude lists")
+	webUI := flag.Bool("web-ui", false, "Serve a status page on the --listen address that can also trigger updates")
+	printVersion := flag.Bool("version", false, "Print the version and build information and exit")
 	flag.Parse()
 
+	if *printVersion {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
+
+	if *printSchema {
+		if err := updater.WriteSchema(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing schema: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *configCheck {
+		if configPath == "" {
+			fmt.Fprintln(os.Stderr, "Error: --config-check requires -c")
+			os.Exit(1)
+		}
+		if _, err := loadConfig(configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Configuration %s is invalid: %v\n", configPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Configuration %s is valid\n", configPath)
+		os.Exit(0)
+	}
+
+	if *dumpEffective {
+		var cfg updater.Config
+		if configPath != "" {
+			var err error
+			if cfg, err = loadConfig(configPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			var err error
+			if cfg, _, err = envConfig(os.LookupEnv); err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := updater.DumpConfig(os.Stdout, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *interactive && !isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "Error: --interactive requires stdin to be a terminal")
+		os.Exit(1)
+	}
+
+	if *runtimeName != "docker" && *runtimeName != "podman" {
+		fmt.Printf("Error: unknown --runtime %q\n", *runtimeName)
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	// Check for mutually exclusive options
 	if *recreateAll && configPath != "" {
 		fmt.Println("Error: -a and -c options are mutually exclusive")
@@ -47,221 +121,349 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Set up syslog logging
-	syslogWriter, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "hikup")
-	if err != nil {
-		log.Fatalf("Error setting up syslog: %v", err)
+	if *watchImage != "" {
+		if *recreateAll {
+			fmt.Println("Error: -a and --watch-image options are mutually exclusive")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if _, err := reference.ParseNormalizedNamed(*watchImage); err != nil {
+			fmt.Printf("Error: invalid --watch-image %q: %v\n", *watchImage, err)
+			os.Exit(1)
+		}
 	}
-	logger = log.New(syslogWriter, "", 0)
 
-	// Initial config load if -c is provided
+	// Set up syslog logging, falling back to stderr whenever syslog is
+	// unavailable
+	var logOutput io.Writer = newSyslogWriter()
+	if *logDedup > 0 {
+		logOutput = newDedupWriter(logOutput, *logDedup)
+	}
+	logger = log.New(logOutput, "", 0)
+	logger.Printf("Starting %s", versionString())
+
+	// Initial config load if -c is provided, otherwise from HIKUP_*
+	// environment variables
+	var cfg updater.Config
 	if configPath != "" {
-		if err := reloadConfig(); err != nil {
+		if err := reloadConfig(func(c updater.Config) { cfg = c }); err != nil {
+			if *once {
+				fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+				os.Exit(exitInfrastructure)
+			}
 			logger.Printf("Error loading initial config: %v", err)
 			// Continue with default (empty) config
 		}
+	} else if envCfg, ok, err := envConfig(os.LookupEnv); err != nil {
+		if *once {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(exitInfrastructure)
+		}
+		logger.Printf("Error loading config from the environment: %v", err)
+	} else if ok {
+		logger.Println("Loaded configuration from the environment")
+		cfg = envCfg
+	}
+
+	flushTraces := func() {}
+	if cfg.OTLPEndpoint != "" {
+		flush, err := setupTracing(cfg.OTLPEndpoint)
+		if err != nil {
+			logger.Printf("Error setting up tracing, not exporting traces: %v", err)
+		} else {
+			flushTraces = flush
+		}
+	}
+
+	if *dockerContext == "" {
+		*dockerContext = cfg.DockerContext
+	}
+	clientOpts := []client.Opt{client.FromEnv}
+	if *dockerContext != "" && *dockerContext != "default" {
+		opts, err := contextClientOpts(*dockerContext)
+		if err != nil {
+			if *once {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitInfrastructure)
+			}
+			logger.Fatal(err)
+		}
+		clientOpts = append(clientOpts, opts...)
+	} else if *runtimeName == "podman" && os.Getenv("DOCKER_HOST") == "" {
+		clientOpts = append(clientOpts, client.WithHost(podmanHost(os.Getenv)))
+	}
+	if *runtimeName == "podman" {
+		// Podman implements an older version of the Docker API
+		clientOpts = append(clientOpts, client.WithAPIVersionNegotiation())
+	}
+
+	cli, err := client.NewClientWithOpts(clientOpts...)
+	if err != nil {
+		if *once {
+			fmt.Fprintf(os.Stderr, "Error creating Docker client: %v\n", err)
+			os.Exit(exitInfrastructure)
+		}
+		logger.Fatalf("Error creating Docker client: %v", err)
+	}
+
+	var dockerClient updater.DockerClient = cli
+	if *runtimeName == "podman" {
+		dockerClient = updater.PodmanCompat(cli)
+	}
+	dialDocker := newDockerDialer(clientOpts, *runtimeName == "podman")
+	u := updater.New(dockerClient, cfg, logger)
+	u.RecreateAll = *recreateAll
+	u.NoPull = *noPull
+	u.DryRun = *dryRun
+	u.Scope = *scope
+	u.IncludeSwarm = *includeSwarm
+	u.Since = *since
+	u.WatchImage = *watchImage
+	u.SmokeTest = *smokeTest
+	u.Debug = *debug
+	u.Version = version
+	if *interactive {
+		u.Confirm = promptConfirm(os.Stdin, os.Stdout)
+	}
+
+	if *stateFile != "" {
+		if err := u.LoadState(*stateFile); err != nil {
+			logger.Printf("Error loading state, starting fresh: %v", err)
+		}
 	}
 
+	u.SetHistoryFile(*historyFile)
+
 	// Set up signal handling
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGHUP)
 
-	// Start a goroutine to handle SIGHUP
+	// Reload on SIGHUP and, with --watch-config, whenever the config file
+	// changes. reloaded wakes up the main loop to reschedule the next scan.
+	reloaded := make(chan struct{}, 1)
+	reload := func() {
+		if err := reloadConfig(u.SetConfig); err != nil {
+			logger.Printf("Error reloading config: %v", err)
+			return
+		}
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	}
 	go func() {
 		for {
 			<-sigs
 			logger.Println("Received SIGHUP, reloading configuration")
-			if err := reloadConfig(); err != nil {
-				logger.Printf("Error reloading config: %v", err)
-			}
+			reload()
 		}
 	}()
+	if *watchConfigFile && !*once {
+		if configPath == "" || configPath == "-" || isConfigURL(configPath) {
+			logger.Println("Warning: --watch-config requires -c with a file path, not watching")
+		} else if _, err := watchConfig(configPath, func() {
+			logger.Println("Configuration file changed, reloading configuration")
+			reload()
+		}); err != nil {
+			logger.Printf("Error watching config file, reload it with SIGHUP instead: %v", err)
+		}
+	}
 
-	cli, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		logger.Fatalf("Error creating Docker client: %v", err)
+	// SIGTERM and SIGINT cancel ctx: the main loop stops waiting and a
+	// running scan finishes the update in progress, then hikup exits. A
+	// second signal exits right away.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !*once && !*rollbackLast {
+		terms := make(chan os.Signal, 2)
+		signal.Notify(terms, syscall.SIGTERM, syscall.SIGINT)
+		go func() {
+			sig := <-terms
+			logger.Printf("Received %s, shutting down", sig)
+			cancel()
+			sig = <-terms
+			logger.Printf("Received %s again, exiting without finishing the update in progress", sig)
+			os.Exit(1)
+		}()
 	}
 
-	for {
-		containers, err := cli.ContainerList(context.Background(), container.ListOptions{All: true})
-		if err != nil {
-			logger.Printf("Error listing containers: %v", err)
-			time.Sleep(time.Minute) // Wait before retrying
-			continue
-		}
+	if *listenAddr != "" {
+		startHTTPServer(*listenAddr, u, *webUI)
+	}
 
-		for _, cont := range containers {
-			if shouldUpdateContainer(cont, *recreateAll) {
-				updateContainer(cli, cont)
-			}
+	if *candidates {
+		if err := listCandidates(os.Stdout, u); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-
-		time.Sleep(time.Hour) // Wait for an hour before checking again
+		os.Exit(0)
 	}
-}
 
-func reloadConfig() error {
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return fmt.Errorf("error reading config file: %v", err)
+	if *rollbackLast {
+		if *historyFile == "" {
+			fmt.Fprintln(os.Stderr, "Error: --rollback-last requires --history-file")
+			os.Exit(exitInfrastructure)
+		}
+		results, err := u.RollbackLast(context.Background())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		failed := updater.Failures(results)
+		writeSummary(os.Stderr, failed, nil)
+		flushTraces()
+		os.Exit(onceExitCode(failed, err))
 	}
 
-	var newConfig Config
-	ext := strings.ToLower(filepath.Ext(configPath))
-	switch ext {
-	case ".json":
-		err = json.Unmarshal(data, &newConfig)
-	case ".yaml", ".yml":
-		err = yaml.Unmarshal(data, &newConfig)
-	default:
-		return fmt.Errorf("unsupported config file format: %s", ext)
+	if !*once {
+		u.NotifyLifecycle("started")
+		go u.RunDigest(ctx)
 	}
 
-	if err != nil {
-		return fmt.Errorf("error parsing config file: %v", err)
+	if delay := time.Duration(u.Config().StartupDelay); delay > 0 && !*once {
+		logger.Printf("Waiting %s before the first scan (startup_delay)", delay)
+		first := time.Now().Add(delay)
+		if !sleepUntil(ctx, nil, func() time.Time { return first }) {
+			u.SendDigest()
+			u.NotifyLifecycle("stopped")
+			flushTraces()
+			return
+		}
 	}
 
-	configLock.Lock()
-	config = newConfig
-	configLock.Unlock()
-
-	logger.Println("Configuration reloaded successfully")
-	return nil
-}
-
-func shouldUpdateContainer(cont types.Container, recreateAll bool) bool {
-	if recreateAll {
-		return true
-	}
+	idleScans := 0
+	for {
+		results, err := u.ScanOnce(ctx)
+		if *resultsFile != "" {
+			if err := writeResults(*resultsFile, *resultsAppend, newScanReport(time.Now(), results, err)); err != nil {
+				logger.Printf("Error writing results: %v", err)
+			}
+		}
+		if *once {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			failed := updater.Failures(results)
+			writeSummary(os.Stderr, failed, updater.Vanished(results))
+			u.SendDigest()
+			flushTraces()
+			os.Exit(onceExitCode(failed, err))
+		}
 
-	configLock.RLock()
-	defer configLock.RUnlock()
+		if ctx.Err() != nil {
+			break
+		}
 
-	// Check if '*' is in the include list
-	for _, name := range config.IncludeContainers {
-		if name == "*" {
-			// Update everything except excluded containers
-			return !containsName(config.ExcludeContainers, cont.Names[0][1:])
+		if err != nil {
+			logger.Println(err)
+			if client.IsErrConnectionFailed(err) {
+				// The daemon may have restarted: start over with a fresh
+				// client once it answers again
+				logger.Println("Lost the connection to Docker, reconnecting")
+				cli, ok := reconnectDocker(ctx, dialDocker)
+				if !ok {
+					break
+				}
+				u.SetClient(cli)
+				continue
+			}
+			// Wait before retrying
+			retry := time.Now().Add(time.Minute)
+			if !sleepUntil(ctx, nil, func() time.Time { return retry }) {
+				break
+			}
+			continue
 		}
-	}
 
-	// Check if the container is in the include list
-	if containsName(config.IncludeContainers, cont.Names[0][1:]) {
-		return true
-	}
+		idleScans++
+		for _, r := range results {
+			if r.Updated {
+				idleScans = 0
+				break
+			}
+		}
 
-	// Check if the container is in the exclude list
-	if containsName(config.ExcludeContainers, cont.Names[0][1:]) {
-		return false
+		scanned := time.Now()
+		nextScan := func() time.Time {
+			next := u.Config().NextScan(scanned, idleScans)
+			logger.Printf("Next scan scheduled at %s", next.Format(time.RFC3339))
+			return next
+		}
+		if !sleepUntil(ctx, reloaded, nextScan) {
+			break
+		}
 	}
 
-	// If not in include or exclude list, don't update by default
-	return false
+	u.SendDigest()
+	u.NotifyLifecycle("stopped")
+	flushTraces()
 }
 
-func containsName(names []string, target string) bool {
-	for _, name := range names {
-		if name == target {
+// sleepUntil waits until the time returned by next, which is asked again
+// whenever reloaded fires, e.g. because the interval changed. It returns
+// false if ctx is done first.
+func sleepUntil(ctx context.Context, reloaded <-chan struct{}, next func() time.Time) bool {
+	timer := time.NewTimer(time.Until(next()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
 			return true
+		case <-reloaded:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(time.Until(next()))
 		}
 	}
-	return false
 }
 
-func updateContainer(cli *client.Client, cont types.Container) {
-	ctx := context.Background()
-
-	// Inspect the container to get its full configuration
-	inspectData, err := cli.ContainerInspect(ctx, cont.ID)
-	if err != nil {
-		log.Printf("Error inspecting container %s: %v", cont.ID[:12], err)
-		return
-	}
+const (
+	// maxFailureExitCode caps the per-container failure count reported as
+	// the exit code of a --once run.
+	maxFailureExitCode = 125
+	// exitInfrastructure is used when a --once run could not scan at all,
+	// e.g. because Docker is unreachable or the config failed to load.
+	exitInfrastructure = 126
+)
 
-	// Pull the latest image
-	_, err = cli.ImagePull(ctx, cont.Image, image.PullOptions{})
+// onceExitCode maps the outcome of a --once scan to the process exit code:
+// the number of failed containers (capped), or exitInfrastructure if the
+// scan could not run.
+func onceExitCode(failed []error, err error) int {
 	if err != nil {
-		log.Printf("Error pulling image for container %s: %v", cont.ID[:12], err)
-		return
+		return exitInfrastructure
 	}
+	return min(len(failed), maxFailureExitCode)
+}
 
-	log.Printf("Pulled latest image for container %s", cont.ID[:12])
-
-	// Stop the container
-	timeout := 10 // int seconds
-	so := container.StopOptions{Timeout: &timeout}
-	err = cli.ContainerStop(ctx, cont.ID, so)
-	if err != nil {
-		log.Printf("Error stopping container %s: %v", cont.ID[:12], err)
-		return
+// writeSummary prints the aggregated per-container failures of a scan and
+// the containers that vanished during it.
+func writeSummary(w io.Writer, failed []error, vanished []string) {
+	if len(vanished) > 0 {
+		fmt.Fprintf(w, "%d container(s) vanished before they could be updated: %s\n", len(vanished), strings.Join(vanished, ", "))
 	}
-
-	// Remove the container
-	err = cli.ContainerRemove(ctx, cont.ID, container.RemoveOptions{RemoveVolumes: false, RemoveLinks: false, Force: true})
-	if err != nil {
-		log.Printf("Error removing container %s: %v", cont.ID[:12], err)
+	if len(failed) == 0 {
 		return
 	}
-
-	// Prepare the container configuration
-	config := &container.Config{
-		Image:        cont.Image,
-		Cmd:          inspectData.Config.Cmd,
-		Env:          inspectData.Config.Env,
-		ExposedPorts: inspectData.Config.ExposedPorts,
-		Labels:       inspectData.Config.Labels,
-		Volumes:      inspectData.Config.Volumes,
-		WorkingDir:   inspectData.Config.WorkingDir,
-		Entrypoint:   inspectData.Config.Entrypoint,
-	}
-
-	// Prepare the host configuration
-	hostConfig := &container.HostConfig{
-		Binds:           inspectData.HostConfig.Binds,
-		PortBindings:    inspectData.HostConfig.PortBindings,
-		RestartPolicy:   inspectData.HostConfig.RestartPolicy,
-		NetworkMode:     inspectData.HostConfig.NetworkMode,
-		Privileged:      inspectData.HostConfig.Privileged,
-		PublishAllPorts: inspectData.HostConfig.PublishAllPorts,
-		VolumesFrom:     inspectData.HostConfig.VolumesFrom,
-	}
-
-	// Prepare the network configuration
-	endpointsConfig := make(map[string]*network.EndpointSettings)
-	for netName, netConfig := range inspectData.NetworkSettings.Networks {
-		endpointsConfig[netName] = &network.EndpointSettings{
-			IPAMConfig:          netConfig.IPAMConfig,
-			Links:               netConfig.Links,
-			Aliases:             netConfig.Aliases,
-			NetworkID:           netConfig.NetworkID,
-			EndpointID:          netConfig.EndpointID,
-			Gateway:             netConfig.Gateway,
-			IPAddress:           netConfig.IPAddress,
-			IPPrefixLen:         netConfig.IPPrefixLen,
-			IPv6Gateway:         netConfig.IPv6Gateway,
-			GlobalIPv6Address:   netConfig.GlobalIPv6Address,
-			GlobalIPv6PrefixLen: netConfig.GlobalIPv6PrefixLen,
-			MacAddress:          netConfig.MacAddress,
-		}
-	}
-
-	networkingConfig := &network.NetworkingConfig{
-		EndpointsConfig: endpointsConfig,
+	fmt.Fprintf(w, "%d container(s) failed to update:\n", len(failed))
+	for _, err := range strings.Split(errors.Join(failed...).Error(), "\n") {
+		fmt.Fprintf(w, "  %s\n", err)
 	}
+}
 
-	// Create a new container with the same configuration
-	resp, err := cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, inspectData.Name[1:]) // Remove leading slash from name
+// listCandidates prints the selection decision for every container.
+func listCandidates(w io.Writer, u *updater.Updater) error {
+	candidates, err := u.Candidates(context.Background())
 	if err != nil {
-		log.Printf("Error creating new container: %v", err)
-		return
+		return err
 	}
 
-	// Start the new container
-	err = cli.ContainerStart(ctx, resp.ID, container.StartOptions{})
-	if err != nil {
-		log.Printf("Error starting new container: %v", err)
-		return
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER\tSELECTED\tREASON")
+	for _, c := range candidates {
+		fmt.Fprintf(tw, "%s\t%v\t%s\n", c.Name, c.Selected, c.Reason)
 	}
-
-	log.Printf("Successfully updated container %s to %s", cont.ID[:12], resp.ID[:12])
+	return tw.Flush()
 }
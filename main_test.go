@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/lnksz/hikup/updater"
+)
+
+func init() {
+	logger = log.New(io.Discard, "", 0)
+}
+
+// listClient is a Docker client that only lists containers. Other methods
+// panic through the nil embedded interface.
+type listClient struct {
+	updater.DockerClient
+	containers []types.Container
+}
+
+func (c *listClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	return c.containers, nil
+}
+
+func TestOnceExitCode(t *testing.T) {
+	failures := func(n int) []error {
+		errs := make([]error, n)
+		for i := range errs {
+			errs[i] = fmt.Errorf("failure %d", i)
+		}
+		return errs
+	}
+
+	tests := []struct {
+		name   string
+		failed []error
+		err    error
+		want   int
+	}{
+		{"success", nil, nil, 0},
+		{"one failure", failures(1), nil, 1},
+		{"several failures", failures(3), nil, 3},
+		{"capped", failures(300), nil, maxFailureExitCode},
+		{"scan error", nil, errors.New("no docker"), exitInfrastructure},
+	}
+	for _, tt := range tests {
+		if got := onceExitCode(tt.failed, tt.err); got != tt.want {
+			t.Errorf("%s: got exit code %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	var buf bytes.Buffer
+	writeSummary(&buf, []error{errors.New("error pulling image for container a"), errors.New("error stopping container b")}, nil)
+
+	want := "2 container(s) failed to update:\n" +
+		"  error pulling image for container a\n" +
+		"  error stopping container b\n"
+	if buf.String() != want {
+		t.Errorf("got summary %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeSummary(&buf, nil, []string{"c"})
+	if want := "1 container(s) vanished before they could be updated: c\n"; buf.String() != want {
+		t.Errorf("got summary %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeSummary(&buf, nil, nil)
+	if buf.Len() != 0 {
+		t.Errorf("got summary %q for no failures, want empty", buf.String())
+	}
+}
+
+func TestListCandidates(t *testing.T) {
+	cli := &listClient{containers: []types.Container{
+		{Names: []string{"/web"}},
+		{Names: []string{"/db"}},
+		{Names: []string{"/cache"}},
+	}}
+	u := updater.New(cli, updater.Config{IncludeContainers: []string{"web"}, ExcludeContainers: []string{"db"}}, nil)
+	var buf bytes.Buffer
+	if err := listCandidates(&buf, u); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"web        true      included by include_containers",
+		"db         false     excluded by exclude_containers",
+		"cache      false     not in any include list",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestBuildInfoMetric(t *testing.T) {
+	var buf bytes.Buffer
+	updater.WriteMetrics(&buf)
+	if want := `hikup_build_info{version="dev",commit="unknown",build_date="unknown"} 1`; !strings.Contains(buf.String(), want) {
+		t.Errorf("metrics missing %q:\n%s", want, buf.String())
+	}
+}
+
+func TestSleepUntil(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A reload reschedules to the new time
+	reloaded := make(chan struct{}, 1)
+	times := make(chan time.Time, 2)
+	times <- time.Now().Add(time.Hour)
+	times <- time.Now()
+	done := make(chan bool)
+	go func() { done <- sleepUntil(ctx, reloaded, func() time.Time { return <-times }) }()
+	reloaded <- struct{}{}
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Error("sleepUntil returned false without cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sleepUntil did not reschedule after a reload")
+	}
+
+	// Cancellation interrupts the sleep
+	go func() { done <- sleepUntil(ctx, nil, func() time.Time { return time.Now().Add(time.Hour) }) }()
+	cancel()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("sleepUntil returned true after cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sleepUntil was not interrupted by cancellation")
+	}
+}
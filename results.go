@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+// resultsSchemaVersion is increased whenever fields of the results file are
+// changed or removed. Added fields do not change it.
+const resultsSchemaVersion = 1
+
+// scanReport is the document written to --results-file after every scan.
+type scanReport struct {
+	SchemaVersion int               `json:"schema_version"`
+	Time          time.Time         `json:"time"`
+	Error         string            `json:"error,omitempty"`
+	Results       []containerReport `json:"results"`
+}
+
+// containerReport is the outcome of the update of one container.
+type containerReport struct {
+	Container  string `json:"container"`
+	ID         string `json:"id"`
+	Updated    bool   `json:"updated"`
+	Vanished   bool   `json:"vanished,omitempty"`
+	OldImage   string `json:"old_image"`
+	NewImage   string `json:"new_image,omitempty"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version,omitempty"`
+	Stage      string `json:"stage,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func newScanReport(now time.Time, results []updater.Result, scanErr error) scanReport {
+	report := scanReport{SchemaVersion: resultsSchemaVersion, Time: now.UTC(), Results: []containerReport{}}
+	if scanErr != nil {
+		report.Error = scanErr.Error()
+	}
+	for _, r := range results {
+		report.Results = append(report.Results, newContainerReport(r))
+	}
+	return report
+}
+
+func newContainerReport(r updater.Result) containerReport {
+	c := containerReport{
+		Container:  r.Container,
+		ID:         r.ID,
+		Updated:    r.Updated,
+		Vanished:   r.Vanished,
+		OldImage:   r.OldImage,
+		NewImage:   r.NewImage,
+		OldVersion: r.OldVersion,
+		NewVersion: r.NewVersion,
+		Stage:      string(r.Stage),
+	}
+	if r.Err != nil {
+		c.Error = r.Err.Error()
+	}
+	return c
+}
+
+// writeResults writes the report of a scan to path. With appendLine, the
+// report is appended as one JSON line; otherwise the file is replaced
+// atomically, so readers never see a partial report.
+func writeResults(path string, appendLine bool, report scanReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if appendLine {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+func TestWriteResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	results := []updater.Result{
+		{Container: "web", ID: "abc", Updated: true, OldImage: "sha256:1", NewImage: "sha256:2"},
+		{Container: "db", ID: "def", OldImage: "sha256:a", Stage: updater.StagePull, Err: errors.New("registry down")},
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := writeResults(path, false, newScanReport(now, results, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report scanReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("replaced file is not one JSON document: %v", err)
+	}
+	if report.SchemaVersion != resultsSchemaVersion || len(report.Results) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if got := report.Results[1]; got.Stage != "pull" || got.Error != "registry down" {
+		t.Errorf("unexpected failure %+v", got)
+	}
+
+	appended := filepath.Join(t.TempDir(), "results.jsonl")
+	for i := 0; i < 2; i++ {
+		if err := writeResults(appended, true, newScanReport(now, nil, errors.New("no docker"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err = os.ReadFile(appended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if want := `{"schema_version":1,"time":"2024-05-01T12:00:00Z","error":"no docker","results":[]}`; lines[0] != want {
+		t.Errorf("got line %s, want %s", lines[0], want)
+	}
+}
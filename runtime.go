@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// rootfulPodmanSocket is where Podman's API socket is for root.
+const rootfulPodmanSocket = "/run/podman/podman.sock"
+
+// podmanHost returns the address of the Podman API socket: the rootless one
+// of the current user if it exists, otherwise the rootful one.
+func podmanHost(getenv func(string) string) string {
+	if dir := getenv("XDG_RUNTIME_DIR"); dir != "" {
+		socket := filepath.Join(dir, "podman", "podman.sock")
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket
+		}
+	}
+	return "unix://" + rootfulPodmanSocket
+}
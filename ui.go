@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+//go:embed ui.html
+var uiHTML string
+
+var uiTemplate = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"shortID": updater.ShortImageID,
+}).Parse(uiHTML))
+
+// uiHandler serves the status page listing all containers.
+func uiHandler(u *updater.Updater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Version    string
+			Containers []updater.ContainerStatus
+			Error      string
+		}{Version: version}
+		statuses, err := u.Status(r.Context())
+		if err != nil {
+			data.Error = err.Error()
+		}
+		data.Containers = statuses
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := uiTemplate.Execute(w, data); err != nil {
+			logger.Printf("Error rendering web UI: %v", err)
+		}
+	}
+}
+
+// updateRequest is the optional JSON body of POST /update/{name}.
+type updateRequest struct {
+	// Image to recreate the container from instead of the latest version
+	// of its own image
+	Image string `json:"image"`
+}
+
+// uiUpdateHandler updates a container right away for the web UI's form and
+// sends it back to the status page.
+func uiUpdateHandler(u *updater.Updater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		logger.Printf("Update of container %s requested from the web UI", name)
+		_, err := u.UpdateContainer(context.WithoutCancel(r.Context()), name)
+		if errors.Is(err, updater.ErrUnmanaged) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// updateHandler updates a container right away and responds with the
+// result. It requires the api_token.
+func updateHandler(u *updater.Updater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := u.Config().APIToken
+		if token == "" {
+			http.Error(w, "the update API is not enabled, see api_token", http.StatusNotFound)
+			return
+		}
+		if !tokenAuthorized(r, token) {
+			http.Error(w, "invalid API token", http.StatusUnauthorized)
+			return
+		}
+		name := r.PathValue("name")
+		var req updateRequest
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Image != "" {
+			logger.Printf("Update of container %s to %s requested over HTTP", name, req.Image)
+		} else {
+			logger.Printf("Update of container %s requested over HTTP", name)
+		}
+		// An update that started runs to completion even if the client
+		// goes away, rather than leaving the container removed
+		result, err := u.UpdateContainerImage(context.WithoutCancel(r.Context()), name, req.Image)
+		if errors.Is(err, updater.ErrInvalidImage) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, updater.ErrUnmanaged) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, newContainerReport(result))
+	}
+}
@@ -0,0 +1,234 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// labelRegistryAuth names the entry of registry_auths a container's image
+// is pulled with, e.g. hikup.registry-auth=acme.
+const labelRegistryAuth = "hikup.registry-auth"
+
+// RegistryCredentials are credentials for pulling from one registry,
+// selected by containers labeled hikup.registry-auth=<name>.
+type RegistryCredentials struct {
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	// PasswordFile holds the password instead, e.g. a Docker secret. It is
+	// read before every pull, so a rotated password is picked up.
+	PasswordFile string `json:"password_file" yaml:"password_file"`
+	// IdentityToken is an OAuth refresh token, used instead of a password.
+	IdentityToken string `json:"identity_token" yaml:"identity_token"`
+}
+
+// redacted returns creds with the password and identity token masked.
+func (creds RegistryCredentials) redacted() RegistryCredentials {
+	creds.Password = redact(creds.Password)
+	creds.IdentityToken = redact(creds.IdentityToken)
+	return creds
+}
+
+// String formats creds without their secrets, so they cannot end up in logs.
+func (creds RegistryCredentials) String() string {
+	creds = creds.redacted()
+	return fmt.Sprintf("{username:%s password:%s password_file:%s identity_token:%s}",
+		creds.Username, creds.Password, creds.PasswordFile, creds.IdentityToken)
+}
+
+func validateRegistryAuths(auths map[string]RegistryCredentials) []error {
+	var errs []error
+	for name, creds := range auths {
+		switch {
+		case name == "":
+			errs = append(errs, errors.New("registry_auths contains an empty name"))
+		case creds.Password != "" && creds.PasswordFile != "":
+			errs = append(errs, fmt.Errorf("registry_auths entry %q has both password and password_file", name))
+		case creds.Password == "" && creds.PasswordFile == "" && creds.IdentityToken == "":
+			errs = append(errs, fmt.Errorf("registry_auths entry %q has no password, password_file or identity_token", name))
+		case creds.Username == "" && creds.IdentityToken == "":
+			errs = append(errs, fmt.Errorf("registry_auths entry %q has no username", name))
+		}
+	}
+	return errs
+}
+
+// encode returns the credentials encoded for pulling ref.
+func (creds RegistryCredentials) encode(ref string) (string, error) {
+	server, err := authServer(ref)
+	if err != nil {
+		return "", err
+	}
+	auth := registry.AuthConfig{
+		Username:      creds.Username,
+		Password:      creds.Password,
+		IdentityToken: creds.IdentityToken,
+		ServerAddress: server,
+	}
+	if creds.PasswordFile != "" {
+		data, err := os.ReadFile(creds.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		auth.Password = strings.TrimRight(string(data), "\r\n")
+	}
+	return registry.EncodeAuthConfig(auth)
+}
+
+// pullAuth returns the encoded credentials for pulling ref for a container
+// with labels: those of its hikup.registry-auth entry, or else those in the
+// docker config file.
+func (u *Updater) pullAuth(ctx context.Context, labels map[string]string, ref string) (string, error) {
+	cfg := u.Config()
+	if name := labels[labelRegistryAuth]; name != "" {
+		creds, ok := cfg.RegistryAuths[name]
+		if !ok {
+			return "", fmt.Errorf("%s=%s is not in registry_auths", labelRegistryAuth, name)
+		}
+		return creds.encode(ref)
+	}
+	return registryAuth(ctx, cfg.dockerConfigPath(), ref)
+}
+
+// authServer returns the key the credentials for ref's registry are stored
+// under.
+func authServer(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	server := reference.Domain(named)
+	if server == "docker.io" {
+		server = dockerHubServer
+	}
+	return server, nil
+}
+
+// dockerHubServer is the key Docker Hub credentials are stored under.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+const credentialHelperTimeout = 30 * time.Second
+
+// dockerConfigFile is the part of the docker CLI's config.json holding
+// registry credentials.
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerConfigPath returns the path of the docker CLI's config.json:
+// configured, in $DOCKER_CONFIG or in ~/.docker.
+func (c Config) dockerConfigPath() string {
+	if c.DockerConfig != "" {
+		return c.DockerConfig
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// registryAuth returns the encoded credentials for pulling ref, read from
+// the docker config file at path like `docker pull` does. Credential
+// helpers are run on every call, so short-lived tokens like those of ECR
+// are always fresh. Without credentials, it returns "".
+func registryAuth(ctx context.Context, path, ref string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var file dockerConfigFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return "", fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	server, err := authServer(ref)
+	if err != nil {
+		return "", err
+	}
+
+	var auth registry.AuthConfig
+	helper := file.CredHelpers[server]
+	if helper == "" {
+		helper = file.CredsStore
+	}
+	if helper != "" {
+		if auth, err = helperCredentials(ctx, helper, server); err != nil {
+			return "", err
+		}
+	}
+	if auth == (registry.AuthConfig{}) {
+		entry, ok := file.Auths[server]
+		if !ok {
+			return "", nil
+		}
+		auth = registry.AuthConfig{Auth: entry.Auth, IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return "", fmt.Errorf("invalid auth for %s in %s: %w", server, path, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+	}
+	auth.ServerAddress = server
+	return registry.EncodeAuthConfig(auth)
+}
+
+// helperCredentials asks the docker credential helper
+// docker-credential-<helper> for the credentials of server. A helper without
+// credentials for server yields an empty AuthConfig.
+func helperCredentials(ctx context.Context, helper, server string) (registry.AuthConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, credentialHelperTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(string(out) + stderr.String())
+		if strings.Contains(msg, "credentials not found") {
+			return registry.AuthConfig{}, nil
+		}
+		return registry.AuthConfig{}, fmt.Errorf("credential helper %s failed: %w: %s", helper, err, msg)
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return registry.AuthConfig{}, fmt.Errorf("invalid output of credential helper %s: %w", helper, err)
+	}
+	if creds.Username == "<token>" {
+		return registry.AuthConfig{IdentityToken: creds.Secret}, nil
+	}
+	return registry.AuthConfig{Username: creds.Username, Password: creds.Secret}, nil
+}
@@ -0,0 +1,115 @@
+package updater
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+func TestRegistryAuth(t *testing.T) {
+	dir := t.TempDir()
+	helper := "#!/bin/sh\nread server\n[ \"$server\" = registry.example.com ] || { echo 'credentials not found in native keychain'; exit 1; }\n" +
+		"echo '{\"ServerURL\":\"registry.example.com\",\"Username\":\"AWS\",\"Secret\":\"fresh-token\"}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(helper), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config := filepath.Join(dir, "config.json")
+	data := `{
+		"auths": {"https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNz"}},
+		"credHelpers": {"registry.example.com": "test"}
+	}`
+	if err := os.WriteFile(config, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref  string
+		want registry.AuthConfig
+	}{
+		{"registry.example.com/app:1", registry.AuthConfig{Username: "AWS", Password: "fresh-token", ServerAddress: "registry.example.com"}},
+		{"nginx:latest", registry.AuthConfig{Username: "user", Password: "pass", Auth: "dXNlcjpwYXNz", ServerAddress: dockerHubServer}},
+		{"ghcr.io/owner/app:1", registry.AuthConfig{}},
+	}
+	for _, tt := range tests {
+		encoded, err := registryAuth(context.Background(), config, tt.ref)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.ref, err)
+		}
+		var got registry.AuthConfig
+		if encoded != "" {
+			decoded, err := registry.DecodeAuthConfig(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = *decoded
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestPullAuth(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "acme-password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(config, []byte(`{"auths": {"registry.acme.com": {"auth": "Z2xvYmFsOnBhc3M="}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	u := New(nil, Config{
+		DockerConfig: config,
+		RegistryAuths: map[string]RegistryCredentials{
+			"acme": {Username: "deploy", PasswordFile: secret},
+		},
+	}, nil)
+
+	tests := []struct {
+		label string
+		want  registry.AuthConfig
+	}{
+		{"acme", registry.AuthConfig{Username: "deploy", Password: "s3cret", ServerAddress: "registry.acme.com"}},
+		{"", registry.AuthConfig{Username: "global", Password: "pass", Auth: "Z2xvYmFsOnBhc3M=", ServerAddress: "registry.acme.com"}},
+	}
+	for _, tt := range tests {
+		labels := map[string]string{}
+		if tt.label != "" {
+			labels[labelRegistryAuth] = tt.label
+		}
+		encoded, err := u.pullAuth(context.Background(), labels, "registry.acme.com/app:1")
+		if err != nil {
+			t.Fatalf("%q: %v", tt.label, err)
+		}
+		got, err := registry.DecodeAuthConfig(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.label, *got, tt.want)
+		}
+	}
+
+	if _, err := u.pullAuth(context.Background(), map[string]string{labelRegistryAuth: "unknown"}, "registry.acme.com/app:1"); err == nil {
+		t.Error("no error for a registry-auth not in registry_auths")
+	}
+}
+
+func TestValidateRegistryAuths(t *testing.T) {
+	for _, creds := range []RegistryCredentials{
+		{Username: "deploy"},
+		{Password: "s3cret"},
+		{Username: "deploy", Password: "s3cret", PasswordFile: "/run/secrets/acme"},
+	} {
+		c := Config{RegistryAuths: map[string]RegistryCredentials{"acme": creds}}
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", creds)
+		}
+	}
+}
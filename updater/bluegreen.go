@@ -0,0 +1,117 @@
+package updater
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// labelTraefikEnable marks a container as routed by Traefik.
+const labelTraefikEnable = "traefik.enable"
+
+// blueGreenSuffix is appended to the name of the new container while it runs
+// next to the old one.
+const blueGreenSuffix = "-hikup-next"
+
+// blueGreenBlocker returns why the container cannot run twice side by side,
+// or "" if it can.
+func blueGreenBlocker(inspectData types.ContainerJSON) string {
+	if len(inspectData.HostConfig.PortBindings) > 0 {
+		return "it publishes host ports"
+	}
+	if owner, ok := networkOwner(string(inspectData.HostConfig.NetworkMode)); ok {
+		return "it shares the network namespace of " + owner
+	}
+	for netName, endpoint := range inspectData.NetworkSettings.Networks {
+		if endpoint.IPAMConfig != nil && (endpoint.IPAMConfig.IPv4Address != "" || endpoint.IPAMConfig.IPv6Address != "") {
+			return "it has a static IP address on network " + netName
+		}
+	}
+	return ""
+}
+
+// blueGreenUpdate replaces a Traefik-routed container without downtime. The
+// new container is started under a temporary name with the same labels, so
+// Traefik adds it to the same service and balances between both. Once it is
+// healthy, the old container is removed, shifting all traffic to the new
+// one, which then takes over the original name (or the one given by
+// name_template). If the new container does not become healthy, it is
+// removed and the old one keeps serving.
+func (u *Updater) blueGreenUpdate(ctx context.Context, cycle *scanCycle, cont types.Container, inspectData types.ContainerJSON, platform *ocispec.Platform, r Result) Result {
+	cli := u.client()
+	cfg := u.Config()
+	timeout, startPeriod := cfg.blueGreenHealthTimeout(), time.Duration(cfg.HealthStartPeriod)
+	if t := u.labelDuration(u.containerLabels(r.Container, inspectData.Config.Labels), labelHealthTimeout); t > 0 {
+		timeout = t
+	}
+
+	name, nameLabels, err := cfg.recreateName(inspectData)
+	if err != nil {
+		return r.fail(failAt(StageCreate, "container %s: %w", cont.ID[:12], err))
+	}
+	// A different name does not clash with the old container
+	tempName := name
+	if name == normalizeName(inspectData.Name) {
+		tempName = name + blueGreenSuffix
+	}
+
+	config, hostConfig, networkingConfig := recreateConfig(inspectData, cont.Image)
+	for k, v := range nameLabels {
+		config.Labels[k] = v
+	}
+	u.Config().setProvenance(config.Labels, cycle.updateTrigger())
+	cycle.resolveReferences(hostConfig)
+	for _, endpoint := range networkingConfig.EndpointsConfig {
+		// Both containers are attached at the same time
+		endpoint.MacAddress = ""
+	}
+
+	resp, err := createContainer(ctx, cli, config, hostConfig, networkingConfig, platform, tempName)
+	if err != nil {
+		return r.fail(failAt(StageCreate, "error creating new container %s (replacing %s): %w", tempName, cont.ID[:12], err))
+	}
+
+	// discard removes the new container, leaving the old one in place
+	discard := func() {
+		if err := cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true}); err != nil {
+			u.logger.Printf("Error removing new container %s: %v", tempName, err)
+		}
+	}
+
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		discard()
+		return r.fail(failAt(StageStart, "error starting new container %s (replacing %s): %w", tempName, cont.ID[:12], err))
+	}
+	if err := waitHealthy(ctx, cli, resp.ID, timeout, startPeriod); err != nil {
+		discard()
+		return r.fail(failAt(StageHealth, "new container %s did not become healthy, keeping %s: %w", tempName, name, err))
+	}
+	u.logger.Printf("New container %s is healthy, removing %s", tempName, cont.ID[:12])
+
+	if err := u.stopContainer(ctx, cont.ID, inspectData); err != nil {
+		return r.fail(failAt(StageStop, "error stopping container %s: %w", cont.ID[:12], err))
+	}
+	err = cli.ContainerRemove(ctx, cont.ID, container.RemoveOptions{Force: true})
+	if err != nil && !(inspectData.HostConfig.AutoRemove && errdefs.IsNotFound(err)) {
+		return r.fail(failAt(StageRemove, "error removing container %s: %w", cont.ID[:12], err))
+	}
+	if tempName != name {
+		if err := cli.ContainerRename(ctx, resp.ID, name); err != nil {
+			return r.fail(failAt(StageCreate, "error renaming new container %s to %s: %w", tempName, name, err))
+		}
+	}
+	cycle.rename(cont.ID, name)
+
+	// The new container is healthy already
+	if cfg.cleanupTiming() != cleanupNever {
+		u.removeOldImage(ctx, r)
+	}
+
+	u.logger.Printf("Successfully updated container %s to %s blue/green (%s)", cont.ID[:12], resp.ID[:12], r.Change())
+	r.Updated = true
+	return r
+}
@@ -0,0 +1,79 @@
+package updater
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+func blueGreenFixture(health string) (*fakeClient, types.Container) {
+	cont := testContainer("web")
+	cont.Image = "whoami:latest"
+	inspect := namedInspect("web", &container.HostConfig{})
+	inspect.Config = &container.Config{Labels: map[string]string{labelTraefikEnable: "true"}}
+
+	newID := "new-web" + blueGreenSuffix + strings.Repeat("0", 12)
+	state := &types.ContainerState{Running: true, Health: &types.Health{Status: health}}
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{
+		cont.ID: inspect,
+		newID:   {ContainerJSONBase: &types.ContainerJSONBase{State: state}},
+	}}
+	return cli, cont
+}
+
+func TestBlueGreenUpdate(t *testing.T) {
+	cli, cont := blueGreenFixture(types.Healthy)
+	r := testUpdate(cli, Config{TraefikBlueGreen: true}, cont)
+	if r.Err != nil || !r.Updated {
+		t.Fatalf("got updated=%v err=%v, want a successful update", r.Updated, r.Err)
+	}
+
+	newID := "new-web" + blueGreenSuffix + strings.Repeat("0", 12)
+	want := []string{
+		"pull whoami:latest",
+		"create web" + blueGreenSuffix,
+		"start " + newID,
+		"stop " + cont.ID,
+		"remove " + cont.ID,
+		"rename " + newID + " web",
+	}
+	if !reflect.DeepEqual(cli.calls, want) {
+		t.Errorf("got calls %v, want %v", cli.calls, want)
+	}
+}
+
+func TestBlueGreenKeepsOldContainerWhenUnhealthy(t *testing.T) {
+	cli, cont := blueGreenFixture(types.Unhealthy)
+	r := testUpdate(cli, Config{TraefikBlueGreen: true}, cont)
+	if r.Err == nil || r.Stage != StageHealth {
+		t.Fatalf("got stage %q err=%v, want a health failure", r.Stage, r.Err)
+	}
+	for _, call := range cli.calls {
+		if strings.Contains(call, cont.ID) {
+			t.Errorf("old container was touched: %s", call)
+		}
+	}
+	if last := cli.calls[len(cli.calls)-1]; !strings.HasPrefix(last, "remove new-web") {
+		t.Errorf("got last call %q, want the new container removed", last)
+	}
+}
+
+func TestBlueGreenBlocker(t *testing.T) {
+	published := inspectFixture(&container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: "8080"}}}})
+	static := inspectFixture(&container.HostConfig{})
+	static.NetworkSettings.Networks["lan"] = &network.EndpointSettings{IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "10.0.0.5"}}
+
+	for name, inspect := range map[string]types.ContainerJSON{"published ports": published, "static IP": static} {
+		if blueGreenBlocker(inspect) == "" {
+			t.Errorf("%s: expected blue/green to be blocked", name)
+		}
+	}
+	if reason := blueGreenBlocker(inspectFixture(&container.HostConfig{})); reason != "" {
+		t.Errorf("got blocker %q for a plain container, want none", reason)
+	}
+}
@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// labelCanary marks the container updated first among the containers of
+// its image. The others are only updated once it has proven itself.
+const labelCanary = "hikup.canary"
+
+// defaultCanaryPeriod is how long a canary is observed if canary_period is
+// not set.
+const defaultCanaryPeriod = 5 * time.Minute
+
+func (c Config) canaryPeriod() time.Duration {
+	if c.CanaryPeriod > 0 {
+		return time.Duration(c.CanaryPeriod)
+	}
+	return defaultCanaryPeriod
+}
+
+// canaryFleet returns the canary among the selected containers running the
+// image of cont and the others running it, in the order given. ok is false
+// if the image has no canary.
+func (u *Updater) canaryFleet(cont types.Container, containers []types.Container) (canary types.Container, peers []types.Container, ok bool) {
+	key := imageKey(cont)
+	for _, other := range containers {
+		if imageKey(other) != key || rollingKey(other) != "" {
+			continue
+		}
+		if _, inGroup := u.Config().groupOf(baseName(containerName(other), other.Labels)); inGroup {
+			continue
+		}
+		if selected, _ := u.Select(other); !selected {
+			continue
+		}
+		if !ok && other.Labels[labelCanary] == "true" {
+			canary, ok = other, true
+			continue
+		}
+		peers = append(peers, other)
+	}
+	return canary, peers, ok
+}
+
+// canaryUpdate updates canary and observes it for canary_period. Only if it
+// stays up and healthy, or is already up to date, are the peers updated;
+// otherwise they keep their image until a later scan.
+func (u *Updater) canaryUpdate(ctx context.Context, cycle *scanCycle, canary types.Container, peers []types.Container) []Result {
+	r, upToDate := u.checkedUpdate(ctx, cycle, canary)
+	switch {
+	case r.Err != nil:
+		u.logger.Printf("Not updating %d other container(s) of image %s: canary %s failed to update", len(peers), imageKey(canary), r.Container)
+		return []Result{r}
+	case r.Updated:
+		period := u.Config().canaryPeriod()
+		u.logger.Printf("Observing canary %s for %s", r.Container, period)
+		name, _ := cycle.resolve(canary.ID)
+		if err := observeCanary(ctx, u.client(), name, period); err != nil {
+			r.Updated = false
+			r = r.fail(failAt(StageHealth, "canary %s failed within %s: %w", r.Container, period, err))
+			u.logger.Printf("Not updating %d other container(s) of image %s: canary %s failed", len(peers), imageKey(canary), r.Container)
+			return []Result{r}
+		}
+		u.logger.Printf("Canary %s passed, updating the other containers of image %s", r.Container, imageKey(canary))
+	case !upToDate:
+		u.logger.Printf("Deferring %d other container(s) of image %s: canary %s was not updated", len(peers), imageKey(canary), r.Container)
+		return []Result{r}
+	}
+
+	results := []Result{r}
+	for _, cont := range peers {
+		results = append(results, u.updateContainer(ctx, cycle, cont))
+	}
+	return results
+}
+
+// observeCanary watches the container id for period. It fails as soon as
+// the container exits, restarts or turns unhealthy, and if it is not healthy
+// at the end, in case it has a healthcheck.
+func observeCanary(ctx context.Context, cli DockerClient, id string, period time.Duration) error {
+	started := time.Now()
+	for {
+		inspect, err := cli.ContainerInspect(ctx, id)
+		if err != nil {
+			return err
+		}
+		if inspect.ContainerJSONBase == nil || inspect.State == nil {
+			return errors.New("container state unknown")
+		}
+		st := inspect.State
+		switch {
+		case !st.Running:
+			return fmt.Errorf("container exited with code %d", st.ExitCode)
+		case inspect.RestartCount > 0:
+			return fmt.Errorf("container restarted %d times", inspect.RestartCount)
+		case st.Health != nil && st.Health.Status == types.Unhealthy:
+			return errors.New("container is unhealthy")
+		}
+		if time.Since(started) >= period {
+			if st.Health != nil && st.Health.Status != types.Healthy {
+				return fmt.Errorf("container is %s", st.Health.Status)
+			}
+			return nil
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
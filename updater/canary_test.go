@@ -0,0 +1,123 @@
+package updater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// canaryFleetClient returns a client with the containers web-1, canary and
+// web-2 of image app:latest. The recreated canary is in canaryState.
+func canaryFleetClient(canaryState *types.ContainerState) *fakeClient {
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{}}
+	for _, name := range []string{"web-1", "canary", "web-2"} {
+		cont := testContainer(name)
+		cont.Image = "app:latest"
+		if name == "canary" {
+			cont.Labels = map[string]string{labelCanary: "true"}
+		}
+		cli.containers = append(cli.containers, cont)
+		cli.inspect[cont.ID] = namedInspect(name, &container.HostConfig{})
+	}
+	// The recreated canary, inspected by name
+	cli.inspect["canary"] = types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: canaryState}}
+	return cli
+}
+
+func TestCanaryGatesUpdates(t *testing.T) {
+	healthPollInterval = time.Millisecond
+	defer func() { healthPollInterval = time.Second }()
+
+	fleet := canaryFleetClient
+	cfg := Config{CanaryPeriod: Duration(5 * time.Millisecond)}
+
+	cli := fleet(&types.ContainerState{Running: true})
+	results, err := scanAll(cli, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, r := range results {
+		order = append(order, r.Container)
+		if !r.Updated {
+			t.Errorf("container %s not updated: %v", r.Container, r.Err)
+		}
+	}
+	if len(order) != 3 || order[0] != "canary" || order[1] != "web-1" || order[2] != "web-2" {
+		t.Errorf("got updates of %v, want the canary first, then web-1 and web-2", order)
+	}
+
+	cli = fleet(&types.ContainerState{Running: false, ExitCode: 1})
+	results, err = scanAll(cli, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Container != "canary" || results[0].Stage != StageHealth {
+		t.Fatalf("got results %+v, want only the failed canary", results)
+	}
+	for _, name := range []string{"web-1", "web-2"} {
+		if containsName(cli.calls, "stop "+testContainer(name).ID) {
+			t.Errorf("container %s updated after its canary failed", name)
+		}
+	}
+}
+
+func TestSkippedCanaryDefersPeers(t *testing.T) {
+	canaryID := testContainer("canary").ID
+	tests := []struct {
+		name  string
+		setup func(cli *fakeClient, u *Updater)
+	}{
+		{"updating label", func(cli *fakeClient, u *Updater) {
+			inspect := cli.inspect[canaryID]
+			inspect.Config.Labels = map[string]string{labelUpdating: "true"}
+			cli.inspect[canaryID] = inspect
+		}},
+		{"min_uptime", func(cli *fakeClient, u *Updater) {
+			inspect := cli.inspect[canaryID]
+			inspect.State = &types.ContainerState{Running: true, StartedAt: time.Now().Format(time.RFC3339Nano)}
+			cli.inspect[canaryID] = inspect
+			u.SetConfig(Config{MinUptime: Duration(time.Hour)})
+		}},
+		{"declined", func(cli *fakeClient, u *Updater) {
+			u.Confirm = func(name, from, to string) bool { return name != "canary" }
+		}},
+	}
+	for _, tt := range tests {
+		cli := canaryFleetClient(&types.ContainerState{Running: true})
+		u := New(cli, Config{}, nil)
+		u.RecreateAll = true
+		tt.setup(cli, u)
+		results, err := u.ScanOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Container != "canary" || results[0].Updated {
+			t.Errorf("%s: got results %+v, want only the skipped canary", tt.name, results)
+		}
+		for _, name := range []string{"web-1", "web-2"} {
+			if containsName(cli.calls, "stop "+testContainer(name).ID) {
+				t.Errorf("%s: container %s updated although its canary was skipped", tt.name, name)
+			}
+		}
+	}
+}
+
+func TestUpToDateCanaryLetsPeersUpdate(t *testing.T) {
+	cli := canaryFleetClient(&types.ContainerState{Running: true})
+	// Only the canary already runs the local image of app:latest
+	cli.containers[1].ImageID = "sha256:app:latest"
+	u := New(cli, Config{}, nil)
+	u.RecreateAll = true
+	u.NoPull = true
+	results, err := u.ScanOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Updated || !results[1].Updated || !results[2].Updated {
+		t.Errorf("got results %+v, want the canary unchanged and web-1 and web-2 updated", results)
+	}
+}
@@ -0,0 +1,46 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+// cleanupTiming is when the image a container ran before its update is
+// removed.
+type cleanupTiming string
+
+const (
+	// cleanupAfterStart removes the old image as soon as the new container
+	// started, freeing disk space early.
+	cleanupAfterStart cleanupTiming = "after-start"
+	// cleanupAfterHealthy keeps the old image until the new container is
+	// healthy, so a failed update can still be rolled back.
+	cleanupAfterHealthy cleanupTiming = "after-healthy"
+	// cleanupNever keeps old images.
+	cleanupNever cleanupTiming = "never"
+)
+
+func parseCleanupTiming(s string) (cleanupTiming, error) {
+	switch t := cleanupTiming(s); t {
+	case cleanupAfterStart, cleanupAfterHealthy, cleanupNever:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown cleanup_timing %q", s)
+	}
+}
+
+// removeOldImage removes the image r was updated from. This is only
+// housekeeping, so a failure, e.g. because another container still uses the
+// image, is logged rather than failing the update.
+func (u *Updater) removeOldImage(ctx context.Context, r Result) {
+	if r.OldImage == "" || r.OldImage == r.NewImage {
+		return
+	}
+	if _, err := u.client().ImageRemove(ctx, r.OldImage, image.RemoveOptions{PruneChildren: true}); err != nil {
+		u.logger.Printf("Could not remove old image %s of container %s: %v", ShortImageID(r.OldImage), r.Container, err)
+		return
+	}
+	u.logger.Printf("Removed old image %s of container %s", ShortImageID(r.OldImage), r.Container)
+}
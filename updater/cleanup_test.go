@@ -0,0 +1,55 @@
+package updater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestCleanupTiming(t *testing.T) {
+	healthPollInterval = time.Millisecond
+	defer func() { healthPollInterval = time.Second }()
+
+	tests := []struct {
+		timing      string
+		health      string
+		wantRemoved bool
+	}{
+		{"after-start", types.Unhealthy, true},
+		{"after-healthy", types.Unhealthy, false},
+		{"after-healthy", types.Healthy, true},
+		{"", types.Healthy, true},
+		{"never", types.Healthy, false},
+	}
+	for _, tt := range tests {
+		cont := testContainer("web")
+		cont.Image, cont.ImageID = "nginx:latest", "sha256:old"
+		newID := "new-web000000000000"
+		cli := &fakeClient{inspect: map[string]types.ContainerJSON{
+			cont.ID: namedInspect("web", &container.HostConfig{}),
+			newID: {ContainerJSONBase: &types.ContainerJSONBase{
+				State: &types.ContainerState{Running: true, Health: &types.Health{Status: tt.health}},
+			}},
+		}}
+
+		testUpdate(cli, Config{CleanupTiming: tt.timing, HealthTimeout: Duration(5 * time.Millisecond)}, cont)
+		removed := containsName(cli.calls, "rmi sha256:old")
+		if removed != tt.wantRemoved {
+			t.Errorf("%q with a %s container: got old image removed %v, want %v", tt.timing, tt.health, removed, tt.wantRemoved)
+		}
+	}
+}
+
+func TestCleanupTimingDefault(t *testing.T) {
+	if got := (Config{}).cleanupTiming(); got != cleanupNever {
+		t.Errorf("got %q without health_timeout, want %q", got, cleanupNever)
+	}
+	if got := (Config{HealthTimeout: Duration(time.Minute)}).cleanupTiming(); got != cleanupAfterHealthy {
+		t.Errorf("got %q with health_timeout, want %q", got, cleanupAfterHealthy)
+	}
+	if err := (Config{CleanupTiming: "sometimes"}).Validate(); err == nil {
+		t.Error("expected an unknown cleanup_timing to be invalid")
+	}
+}
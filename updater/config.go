@@ -0,0 +1,567 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of an Updater, usually read from a YAML or
+// JSON file.
+type Config struct {
+	IncludeContainers []string `json:"include_containers" yaml:"include_containers"`
+	ExcludeContainers []string `json:"exclude_containers" yaml:"exclude_containers"`
+	// IncludeServices and ExcludeServices match the Docker Compose service
+	// name of a container, in any project.
+	IncludeServices []string `json:"include_services" yaml:"include_services"`
+	ExcludeServices []string `json:"exclude_services" yaml:"exclude_services"`
+	// Interval between scans; defaults to one hour. Ignored if Schedule is
+	// set.
+	Interval Duration `json:"interval" yaml:"interval"`
+	// MinInterval and MaxInterval enable an adaptive interval instead: it
+	// starts at MinInterval, doubles after every scan that updated nothing,
+	// up to MaxInterval, and resets once a container is updated.
+	MinInterval Duration `json:"min_interval" yaml:"min_interval"`
+	MaxInterval Duration `json:"max_interval" yaml:"max_interval"`
+	// Schedule is a cron expression triggering scans, e.g. "0 3 * * 1-5".
+	Schedule string `json:"schedule" yaml:"schedule"`
+	// Stagger is a delay inserted between successive container updates
+	// within a scan to spread out the load.
+	Stagger Duration `json:"stagger" yaml:"stagger"`
+	// AutoUpdateTags, e.g. ["stable", "latest"], only lets containers
+	// running an image with one of these tags be updated. Empty allows all.
+	AutoUpdateTags []string `json:"auto_update_tags" yaml:"auto_update_tags"`
+	// LatestPolicy is how containers on the mutable "latest" tag are
+	// treated: "update" (the default), "warn" to log a warning when one is
+	// updated, or "skip" to leave them alone.
+	LatestPolicy string `json:"latest_policy" yaml:"latest_policy"`
+	// MissingImage is what happens to a container whose image was removed
+	// locally, e.g. by pruning, so it cannot be compared: "update" (the
+	// default) treats it as out of date, "skip" leaves it alone.
+	MissingImage string `json:"missing_image" yaml:"missing_image"`
+	// MinUptime skips containers started less than this long ago, e.g. by
+	// someone working on them by hand.
+	MinUptime Duration `json:"min_uptime" yaml:"min_uptime"`
+	// RequireHealthyBeforeUpdate skips containers whose healthcheck does
+	// not currently pass, so a failing container is not replaced while
+	// its failure could be blamed on the new image.
+	RequireHealthyBeforeUpdate bool `json:"require_healthy_before_update" yaml:"require_healthy_before_update"`
+	// StopTimeout is how long a container gets to exit when stopped before
+	// it is killed, unless it was created with --stop-timeout. Defaults to
+	// ten seconds.
+	StopTimeout Duration `json:"stop_timeout" yaml:"stop_timeout"`
+	// TraefikBlueGreen updates containers routed by Traefik by starting the
+	// new container next to the old one and removing the old one once the
+	// new one is healthy.
+	TraefikBlueGreen bool `json:"traefik_blue_green" yaml:"traefik_blue_green"`
+	// HealthTimeout makes hikup wait for a recreated container to become
+	// healthy and count the update as failed if it does not within this
+	// time. Blue/green updates always wait, by default for two minutes.
+	HealthTimeout Duration `json:"health_timeout" yaml:"health_timeout"`
+	// HealthStartPeriod gives slow starting containers extra time to become
+	// healthy. Defaults to the start period of the container's healthcheck.
+	HealthStartPeriod Duration `json:"health_start_period" yaml:"health_start_period"`
+	// RestartWatch is how long a new container is watched for restarts
+	// after its update; 0 disables the watch.
+	RestartWatch Duration `json:"restart_watch" yaml:"restart_watch"`
+	// MaxRestarts is how often a watched container may restart before the
+	// update counts as failed; defaults to 0.
+	MaxRestarts int `json:"max_restarts" yaml:"max_restarts"`
+	// RollbackOnRestartLoop recreates a container that failed the restart
+	// watch from the image it ran before.
+	RollbackOnRestartLoop bool `json:"rollback_on_restart_loop" yaml:"rollback_on_restart_loop"`
+	// RecreateVolumesFromDependents recreates the containers mounting the
+	// volumes of an updated container with --volumes-from, so they use the
+	// volumes of its replacement.
+	RecreateVolumesFromDependents bool `json:"recreate_volumes_from_dependents" yaml:"recreate_volumes_from_dependents"`
+	// PullPolicy is when images are pulled: "always", "if-not-present" or
+	// "never". Defaults to "always"; the hikup.pull-policy label overrides
+	// it per container.
+	PullPolicy string `json:"pull_policy" yaml:"pull_policy"`
+	// FollowImageCommand lets a recreated container that ran the default
+	// ENTRYPOINT and CMD of its old image run those of the new image,
+	// instead of keeping the old defaults as explicit overrides.
+	FollowImageCommand bool `json:"follow_image_command" yaml:"follow_image_command"`
+	// CanaryPeriod is how long the container labeled hikup.canary=true is
+	// observed after its update before the other containers of its image
+	// are updated; defaults to five minutes.
+	CanaryPeriod Duration `json:"canary_period" yaml:"canary_period"`
+	// DesiredStateFile maps container names to the image each should run,
+	// pinned by digest. Listed containers are recreated whenever they run
+	// another image, and left alone otherwise.
+	DesiredStateFile string `json:"desired_state_file" yaml:"desired_state_file"`
+	// ImageLoadDir is a directory of image tarballs (`docker save`) loaded
+	// at the start of every scan, for hosts without registry access.
+	// Images are never pulled then, and containers are recreated once
+	// their image tag points at a newly loaded image.
+	ImageLoadDir string `json:"image_load_dir" yaml:"image_load_dir"`
+	// ContainerConfigDir holds per-container settings files named after
+	// the containers; defaults to /etc/hikup/containers.
+	ContainerConfigDir string `json:"container_config_dir" yaml:"container_config_dir"`
+	// MaxIdleDeferrals is how many scans in a row the update of a container
+	// failing its hikup.idle-check is deferred before it is updated anyway;
+	// 0 defers it until the container is idle.
+	MaxIdleDeferrals int `json:"max_idle_deferrals" yaml:"max_idle_deferrals"`
+	// RegistryHeadCheck asks the registry for the digest of a container's
+	// image tag before pulling it, and leaves the container alone if it
+	// already runs that image.
+	RegistryHeadCheck bool `json:"registry_head_check" yaml:"registry_head_check"`
+	// VerifySignatures requires new images to be signed with cosign before
+	// a container is recreated from them, globally or per registry.
+	VerifySignatures []SignaturePolicy `json:"verify_signatures" yaml:"verify_signatures"`
+	// VulnerabilitySeverity only updates containers whose current image has
+	// vulnerabilities of this severity or higher according to trivy: "LOW",
+	// "MEDIUM", "HIGH" or "CRITICAL". Empty updates regardless.
+	VulnerabilitySeverity string `json:"vulnerability_severity" yaml:"vulnerability_severity"`
+	// TrivyServer is the URL of a trivy server to scan with instead of a
+	// local vulnerability database.
+	TrivyServer string `json:"trivy_server" yaml:"trivy_server"`
+	// NameTemplate is a Go template for the name of a recreated container,
+	// e.g. "{{.Name}}-{{.Generation}}". Defaults to the same name.
+	NameTemplate string `json:"name_template" yaml:"name_template"`
+	// PortMismatch is what happens if a new image no longer exposes a port
+	// the container publishes: "warn" (the default), "fail" or "ignore".
+	PortMismatch string `json:"port_mismatch" yaml:"port_mismatch"`
+	// CleanupTiming is when the old image of an updated container is
+	// removed: "after-start", "after-healthy" or "never". Defaults to
+	// "after-healthy" if HealthTimeout is set, "never" otherwise.
+	CleanupTiming string `json:"cleanup_timing" yaml:"cleanup_timing"`
+	// Groups are updated as a unit: if one member is updated, all are.
+	Groups []Group `json:"groups" yaml:"groups"`
+	// MaxUpdatesPerCycle caps how many containers are recreated in one
+	// scan; 0 means no limit.
+	MaxUpdatesPerCycle int `json:"max_updates_per_cycle" yaml:"max_updates_per_cycle"`
+	// StartupDelay postpones the first scan after hikup starts, so a
+	// rebooted host settles before containers are updated.
+	StartupDelay Duration `json:"startup_delay" yaml:"startup_delay"`
+	// StartupMaxUpdates caps how many containers the first scan after
+	// startup recreates, in place of MaxUpdatesPerCycle if lower.
+	StartupMaxUpdates int `json:"startup_max_updates" yaml:"startup_max_updates"`
+	// PreCycleCommand and PostCycleCommand are shell commands run before
+	// and after every scan. A failing PreCycleCommand aborts the scan.
+	PreCycleCommand  string `json:"pre_cycle_command" yaml:"pre_cycle_command"`
+	PostCycleCommand string `json:"post_cycle_command" yaml:"post_cycle_command"`
+	// CycleCommandTimeout limits each of them; defaults to five minutes.
+	CycleCommandTimeout Duration `json:"cycle_command_timeout" yaml:"cycle_command_timeout"`
+	// HookOutputLimit truncates the output of each cycle command logged to
+	// this many bytes; 0 logs all of it.
+	HookOutputLimit int `json:"hook_output_limit" yaml:"hook_output_limit"`
+	// MinFreeDisk, e.g. "10GB", fails updates instead of pulling while less
+	// space is free on DockerDataRoot.
+	MinFreeDisk string `json:"min_free_disk" yaml:"min_free_disk"`
+	// DockerDataRoot is where the Docker daemon stores images, as seen by
+	// hikup; defaults to /var/lib/docker.
+	DockerDataRoot string `json:"docker_data_root" yaml:"docker_data_root"`
+	// DockerConfig is the docker CLI config.json whose credentials and
+	// credential helpers are used for pulls; defaults to
+	// $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+	DockerConfig string `json:"docker_config" yaml:"docker_config"`
+	// RegistryAuths are named credentials for containers labeled
+	// hikup.registry-auth=<name>, which pull with them instead of those in
+	// DockerConfig.
+	RegistryAuths map[string]RegistryCredentials `json:"registry_auths" yaml:"registry_auths"`
+	// RegistryRewrite pulls and recreates containers from another registry
+	// than the one their image reference names; the first matching rule
+	// applies.
+	RegistryRewrite []RegistryRewrite `json:"registry_rewrite" yaml:"registry_rewrite"`
+	// OTLPEndpoint, e.g. "http://otel-collector:4318", enables exporting
+	// OpenTelemetry traces of scans and updates over OTLP/HTTP. Only read
+	// at startup.
+	OTLPEndpoint string `json:"otlp_endpoint" yaml:"otlp_endpoint"`
+	// DockerContext names the docker CLI context to connect with, as listed
+	// by `docker context ls`. Only read at startup.
+	DockerContext string `json:"docker_context" yaml:"docker_context"`
+	// RequiredLabelPrefix, e.g. "mycompany.", makes hikup leave alone every
+	// container without a label starting with it, even with -a or when
+	// included by name.
+	RequiredLabelPrefix string `json:"required_label_prefix" yaml:"required_label_prefix"`
+	// Scope restricts this instance to containers labeled
+	// hikup.scope=<Scope>. Without a scope, only unlabeled containers are
+	// managed.
+	Scope string `json:"scope" yaml:"scope"`
+	// WebhookSecret enables the registry webhook endpoint of the HTTP API
+	// and must be sent with every webhook.
+	WebhookSecret string `json:"webhook_secret" yaml:"webhook_secret"`
+	// APIToken enables the update endpoint of the HTTP API and must be sent
+	// with every request to it.
+	APIToken string `json:"api_token" yaml:"api_token"`
+	// ProvenanceLabels labels recreated containers with the instance that
+	// updated them (hikup.updated-by) and why (hikup.trigger).
+	ProvenanceLabels bool `json:"provenance_labels" yaml:"provenance_labels"`
+	// InstanceName identifies this instance in the hikup.updated-by label;
+	// defaults to the host name.
+	InstanceName string `json:"instance_name" yaml:"instance_name"`
+	// NotifyURLs receive a JSON POST for every alert and update.
+	NotifyURLs []string `json:"notify_urls" yaml:"notify_urls"`
+	// NotifyLifecycle also sends a notification when hikup starts and when
+	// it shuts down gracefully.
+	NotifyLifecycle bool `json:"notify_lifecycle" yaml:"notify_lifecycle"`
+	// NotificationDigest is a cron expression, e.g. "0 8 * * *": instead of
+	// one notification per update and alert, all of them are sent together
+	// at these times.
+	NotificationDigest string `json:"notification_digest" yaml:"notification_digest"`
+	// NotifyFailuresImmediately still sends alerts right away with a
+	// NotificationDigest.
+	NotifyFailuresImmediately bool `json:"notify_failures_immediately" yaml:"notify_failures_immediately"`
+	// FailureThreshold is the number of consecutive failed scans after
+	// which a container's failure is alerted; defaults to 1.
+	FailureThreshold int `json:"failure_threshold" yaml:"failure_threshold"`
+
+	schedule *cronSchedule
+}
+
+const defaultInterval = time.Hour
+
+// Duration is a time.Duration that is written as a string like "30s" or
+// "1h30m" in config files.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	return d.set(s)
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// ParseConfig parses and validates a configuration in format "json" or
+// "yaml".
+func ParseConfig(data []byte, format string) (Config, error) {
+	var cfg Config
+	var err error
+	switch format {
+	case "json":
+		err = json.Unmarshal(data, &cfg)
+	default:
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error parsing config file: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config: %w", err)
+	}
+	if cfg.Schedule != "" {
+		// Already validated above
+		cfg.schedule, _ = parseCron(cfg.Schedule)
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration for internal consistency. All problems
+// found are reported together.
+func (c Config) Validate() error {
+	var errs []error
+
+	for _, name := range c.IncludeContainers {
+		if name == "" {
+			errs = append(errs, errors.New("include_containers contains an empty name"))
+		}
+	}
+	for _, name := range c.ExcludeContainers {
+		switch {
+		case name == "":
+			errs = append(errs, errors.New("exclude_containers contains an empty name"))
+		case name == "*":
+			errs = append(errs, errors.New(`"*" is only supported in include_containers`))
+		case containsName(c.IncludeContainers, name):
+			errs = append(errs, fmt.Errorf("container %q is both included and excluded", name))
+		}
+	}
+
+	for _, service := range c.ExcludeServices {
+		if containsName(c.IncludeServices, service) {
+			errs = append(errs, fmt.Errorf("service %q is both included and excluded", service))
+		}
+	}
+
+	errs = append(errs, validateGroups(c.Groups)...)
+	errs = append(errs, validateSignaturePolicies(c.VerifySignatures)...)
+	errs = append(errs, validateRegistryAuths(c.RegistryAuths)...)
+	errs = append(errs, validateRegistryRewrites(c.RegistryRewrite)...)
+
+	if c.Interval < 0 {
+		errs = append(errs, errors.New("interval must not be negative"))
+	}
+	switch {
+	case c.MinInterval < 0 || c.MaxInterval < 0:
+		errs = append(errs, errors.New("min_interval and max_interval must not be negative"))
+	case (c.MinInterval == 0) != (c.MaxInterval == 0):
+		errs = append(errs, errors.New("min_interval and max_interval must be set together"))
+	case c.MinInterval > c.MaxInterval:
+		errs = append(errs, errors.New("min_interval must not exceed max_interval"))
+	}
+	if c.HealthTimeout < 0 || c.HealthStartPeriod < 0 {
+		errs = append(errs, errors.New("health_timeout and health_start_period must not be negative"))
+	}
+	if c.RestartWatch < 0 || c.MaxRestarts < 0 {
+		errs = append(errs, errors.New("restart_watch and max_restarts must not be negative"))
+	}
+	if c.NameTemplate != "" {
+		if _, err := parseNameTemplate(c.NameTemplate); err != nil {
+			errs = append(errs, fmt.Errorf("invalid name_template: %w", err))
+		}
+	}
+	if c.PullPolicy != "" {
+		if _, err := parsePullPolicy(c.PullPolicy); err != nil {
+			errs = append(errs, fmt.Errorf("invalid pull_policy: %w", err))
+		}
+	}
+	if c.MinFreeDisk != "" {
+		if _, err := parseDiskSize(c.MinFreeDisk); err != nil {
+			errs = append(errs, fmt.Errorf("invalid min_free_disk: %w", err))
+		}
+	}
+	if c.MissingImage != "" {
+		if _, err := parseMissingImage(c.MissingImage); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.LatestPolicy != "" {
+		if _, err := parseLatestPolicy(c.LatestPolicy); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.PortMismatch != "" {
+		if _, err := parsePortMismatch(c.PortMismatch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.VulnerabilitySeverity != "" {
+		if _, err := parseSeverity(c.VulnerabilitySeverity); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.CleanupTiming != "" {
+		if _, err := parseCleanupTiming(c.CleanupTiming); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.CycleCommandTimeout < 0 {
+		errs = append(errs, errors.New("cycle_command_timeout must not be negative"))
+	}
+	if c.HookOutputLimit < 0 {
+		errs = append(errs, errors.New("hook_output_limit must not be negative"))
+	}
+	if c.StopTimeout < 0 {
+		errs = append(errs, errors.New("stop_timeout must not be negative"))
+	}
+	if c.CanaryPeriod < 0 {
+		errs = append(errs, errors.New("canary_period must not be negative"))
+	}
+	if c.MaxIdleDeferrals < 0 {
+		errs = append(errs, errors.New("max_idle_deferrals must not be negative"))
+	}
+	if c.MinUptime < 0 {
+		errs = append(errs, errors.New("min_uptime must not be negative"))
+	}
+	if c.Stagger < 0 {
+		errs = append(errs, errors.New("stagger must not be negative"))
+	}
+	if c.MaxUpdatesPerCycle < 0 {
+		errs = append(errs, errors.New("max_updates_per_cycle must not be negative"))
+	}
+	if c.StartupDelay < 0 || c.StartupMaxUpdates < 0 {
+		errs = append(errs, errors.New("startup_delay and startup_max_updates must not be negative"))
+	}
+	if c.FailureThreshold < 0 {
+		errs = append(errs, errors.New("failure_threshold must not be negative"))
+	}
+	if c.Schedule != "" {
+		if _, err := parseCron(c.Schedule); err != nil {
+			errs = append(errs, fmt.Errorf("invalid schedule: %w", err))
+		}
+	}
+	if c.NotificationDigest != "" {
+		if _, err := parseCron(c.NotificationDigest); err != nil {
+			errs = append(errs, fmt.Errorf("invalid notification_digest: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// WithDefaults returns c with every unset option that has a default filled
+// in, i.e. the configuration hikup effectively runs with.
+func (c Config) WithDefaults() Config {
+	if c.Interval == 0 {
+		c.Interval = Duration(defaultInterval)
+	}
+	if c.CycleCommandTimeout == 0 {
+		c.CycleCommandTimeout = Duration(defaultCycleCommandTimeout)
+	}
+	if c.StopTimeout == 0 {
+		c.StopTimeout = Duration(defaultStopTimeout)
+	}
+	c.CanaryPeriod = Duration(c.canaryPeriod())
+	c.FailureThreshold = c.failureThreshold()
+	c.CleanupTiming = string(c.cleanupTiming())
+	return c
+}
+
+// redactedSecret replaces secrets in output.
+const redactedSecret = "***"
+
+// redact returns redactedSecret in place of a set secret.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedSecret
+}
+
+// redacted returns a copy of c with its secrets masked.
+func (c Config) redacted() Config {
+	c.WebhookSecret = redact(c.WebhookSecret)
+	c.APIToken = redact(c.APIToken)
+	if c.RegistryAuths != nil {
+		auths := make(map[string]RegistryCredentials, len(c.RegistryAuths))
+		for name, creds := range c.RegistryAuths {
+			auths[name] = creds.redacted()
+		}
+		c.RegistryAuths = auths
+	}
+	return c
+}
+
+// DumpConfig writes the effective configuration as YAML, with secrets
+// masked.
+func DumpConfig(w io.Writer, c Config) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.WithDefaults().redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// defaultStopTimeout is how long a container gets to exit when stopped if
+// stop_timeout is not set.
+const defaultStopTimeout = 10 * time.Second
+
+// stopTimeout returns how many seconds the inspected container gets to exit
+// when stopped: its own --stop-timeout, or else stop_timeout.
+func (c Config) stopTimeout(inspectData types.ContainerJSON) int {
+	if inspectData.Config != nil && inspectData.Config.StopTimeout != nil {
+		return *inspectData.Config.StopTimeout
+	}
+	timeout := time.Duration(c.WithDefaults().StopTimeout)
+	// Round up, Docker only takes whole seconds
+	return int((timeout + time.Second - 1) / time.Second)
+}
+
+// defaultBlueGreenHealthTimeout is how long the new container of a
+// blue/green update has to become healthy if health_timeout is not set.
+const defaultBlueGreenHealthTimeout = 2 * time.Minute
+
+func (c Config) blueGreenHealthTimeout() time.Duration {
+	if c.HealthTimeout > 0 {
+		return time.Duration(c.HealthTimeout)
+	}
+	return defaultBlueGreenHealthTimeout
+}
+
+func (c Config) cleanupTiming() cleanupTiming {
+	if t, err := parseCleanupTiming(c.CleanupTiming); err == nil {
+		return t
+	}
+	if c.HealthTimeout > 0 {
+		return cleanupAfterHealthy
+	}
+	return cleanupNever
+}
+
+func (c Config) failureThreshold() int {
+	if c.FailureThreshold > 0 {
+		return c.FailureThreshold
+	}
+	return 1
+}
+
+// Summary describes c in one line, e.g. for the startup notification.
+func (c Config) Summary() string {
+	parts := []string{fmt.Sprintf("include_containers=%v", c.IncludeContainers)}
+	if len(c.ExcludeContainers) > 0 {
+		parts = append(parts, fmt.Sprintf("exclude_containers=%v", c.ExcludeContainers))
+	}
+	if len(c.IncludeServices) > 0 {
+		parts = append(parts, fmt.Sprintf("include_services=%v", c.IncludeServices))
+	}
+	if len(c.ExcludeServices) > 0 {
+		parts = append(parts, fmt.Sprintf("exclude_services=%v", c.ExcludeServices))
+	}
+	if c.Schedule != "" {
+		parts = append(parts, fmt.Sprintf("schedule=%q", c.Schedule))
+	} else {
+		parts = append(parts, fmt.Sprintf("interval=%s", c.WithDefaults().Interval))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// NextScan returns when the scan following one finished at now should run.
+// idleScans is the number of scans in a row that updated nothing.
+func (c Config) NextScan(now time.Time, idleScans int) time.Time {
+	schedule := c.schedule
+	if schedule == nil && c.Schedule != "" {
+		// Built in code rather than by ParseConfig
+		schedule, _ = parseCron(c.Schedule)
+	}
+	if schedule != nil {
+		if next := schedule.Next(now); !next.IsZero() {
+			return next
+		}
+	}
+	if c.MinInterval > 0 {
+		return now.Add(c.adaptiveInterval(idleScans))
+	}
+	if c.Interval > 0 {
+		return now.Add(time.Duration(c.Interval))
+	}
+	return now.Add(defaultInterval)
+}
+
+// adaptiveInterval doubles min_interval for every idle scan, capped at
+// max_interval.
+func (c Config) adaptiveInterval(idleScans int) time.Duration {
+	interval, max := time.Duration(c.MinInterval), time.Duration(c.MaxInterval)
+	for i := 0; i < idleScans && interval < max; i++ {
+		interval *= 2
+	}
+	return min(interval, max)
+}
@@ -0,0 +1,81 @@
+package updater
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpConfigAppliesDefaults(t *testing.T) {
+	var buf bytes.Buffer
+	if err := DumpConfig(&buf, Config{IncludeContainers: []string{"web"}, Stagger: Duration(30 * time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"include_containers:\n  - web\n", "interval: 1h0m0s\n", "stagger: 30s\n", "failure_threshold: 1\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("dump is missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	// The dump must be loadable again
+	if _, err := ParseConfig(buf.Bytes(), "yaml"); err != nil {
+		t.Errorf("dumped config does not load: %v", err)
+	}
+}
+
+func TestDumpConfigRedactsSecrets(t *testing.T) {
+	c := Config{
+		RegistryAuths: map[string]RegistryCredentials{
+			"acme":  {Username: "robot", Password: "hunter2"},
+			"cloud": {IdentityToken: "refresh-token-42"},
+		},
+		WebhookSecret: "webhook-s3cret",
+		APIToken:      "api-s3cret",
+	}
+	var buf bytes.Buffer
+	if err := DumpConfig(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "refresh-token-42", "webhook-s3cret", "api-s3cret"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("dump contains the secret %q:\n%s", secret, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), "username: robot\n") {
+		t.Errorf("dump is missing the username:\n%s", buf.String())
+	}
+	if c.RegistryAuths["acme"].Password != "hunter2" {
+		t.Error("DumpConfig changed the config it was passed")
+	}
+	if s := fmt.Sprint(c.RegistryAuths); strings.Contains(s, "hunter2") || strings.Contains(s, "refresh-token-42") {
+		t.Errorf("formatted credentials contain a secret: %s", s)
+	}
+}
+
+func TestNextScanAdaptive(t *testing.T) {
+	c := Config{Interval: Duration(time.Hour), MinInterval: Duration(5 * time.Minute), MaxInterval: Duration(30 * time.Minute)}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for idle, want := range []time.Duration{5 * time.Minute, 10 * time.Minute, 20 * time.Minute, 30 * time.Minute, 30 * time.Minute} {
+		if got := c.NextScan(now, idle).Sub(now); got != want {
+			t.Errorf("after %d idle scans: got interval %s, want %s", idle, got, want)
+		}
+	}
+	if got := c.NextScan(now, 1000).Sub(now); got != 30*time.Minute {
+		t.Errorf("got interval %s after many idle scans, want the maximum", got)
+	}
+}
+
+func TestValidateAdaptiveInterval(t *testing.T) {
+	for _, c := range []Config{
+		{MinInterval: Duration(time.Minute)},
+		{MaxInterval: Duration(time.Minute)},
+		{MinInterval: Duration(time.Hour), MaxInterval: Duration(time.Minute)},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package updater
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// scanCycle is the view of all containers taken at the start of a scan. It
+// lets references between containers be resolved even after the referenced
+// container has been recreated under a new ID.
+type scanCycle struct {
+	started time.Time
+	names   map[string]string // container ID -> name
+	// trigger is why the containers are updated, see triggerScheduled
+	trigger string
+	// pulls records the outcome of every image pulled in the cycle
+	pulls map[string]error
+	// images are explicitly requested images by container name, which the
+	// containers are recreated from instead of their own
+	images map[string]string
+	// links are the containers each container links to with --link, by
+	// name
+	links map[string][]string
+}
+
+func newScanCycle(containers []types.Container) *scanCycle {
+	c := &scanCycle{started: time.Now(), names: make(map[string]string, len(containers)), trigger: triggerScheduled, links: make(map[string][]string)}
+	for _, cont := range containers {
+		c.names[cont.ID] = containerName(cont)
+	}
+	// Docker lists a linked-to container under an extra name
+	// "/<linking container>/<alias>"
+	for _, cont := range containers {
+		for _, name := range cont.Names {
+			if parent, _, ok := strings.Cut(strings.TrimPrefix(name, "/"), "/"); ok {
+				c.links[parent] = append(c.links[parent], containerName(cont))
+			}
+		}
+	}
+	return c
+}
+
+// pulled returns the outcome of the pull of key earlier in the cycle, if
+// any.
+func (c *scanCycle) pulled(key string) (err error, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	err, ok = c.pulls[key]
+	return err, ok
+}
+
+func (c *scanCycle) setPulled(key string, err error) {
+	if c == nil {
+		return
+	}
+	if c.pulls == nil {
+		c.pulls = make(map[string]error)
+	}
+	c.pulls[key] = err
+}
+
+// requestedImage returns the image explicitly requested for the container
+// name, if any.
+func (c *scanCycle) requestedImage(name string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	image, ok := c.images[name]
+	return image, ok
+}
+
+// updateTrigger returns why the containers of the cycle are updated.
+func (c *scanCycle) updateTrigger() string {
+	if c == nil {
+		return triggerScheduled
+	}
+	return c.trigger
+}
+
+// resolve maps a container reference (full ID, unique ID prefix or name) to
+// the container's name.
+func (c *scanCycle) resolve(ref string) (string, bool) {
+	if c == nil || ref == "" {
+		return "", false
+	}
+	if name, ok := c.names[ref]; ok {
+		return name, true
+	}
+
+	var match string
+	for id, name := range c.names {
+		if name == ref {
+			return name, true
+		}
+		if strings.HasPrefix(id, ref) {
+			if match != "" {
+				return "", false // ambiguous prefix
+			}
+			match = name
+		}
+	}
+	return match, match != ""
+}
+
+// rename records that the container with the given ID was replaced by one
+// named name.
+func (c *scanCycle) rename(id, name string) {
+	if c != nil {
+		c.names[id] = name
+	}
+}
+
+// networkOwner returns the container whose network namespace a
+// "container:<ref>" network mode joins.
+func networkOwner(mode string) (string, bool) {
+	return strings.CutPrefix(mode, "container:")
+}
+
+// resolveNetworkMode rewrites a "container:<id>" network mode to reference
+// the owner by name, which stays valid when the owner is recreated.
+func (c *scanCycle) resolveNetworkMode(mode string) string {
+	ref, ok := networkOwner(mode)
+	if !ok {
+		return mode
+	}
+	if name, ok := c.resolve(ref); ok {
+		return "container:" + name
+	}
+	return mode
+}
+
+// resolveVolumesFrom rewrites the containers referenced by ID in VolumesFrom
+// entries ("<ref>[:ro|:rw]") to their names, like resolveNetworkMode.
+func (c *scanCycle) resolveVolumesFrom(entries []string) []string {
+	if len(entries) == 0 {
+		return entries
+	}
+	resolved := make([]string, len(entries))
+	for i, entry := range entries {
+		ref, mode, hasMode := strings.Cut(entry, ":")
+		if name, ok := c.resolve(ref); ok {
+			ref = name
+		}
+		if hasMode {
+			ref += ":" + mode
+		}
+		resolved[i] = ref
+	}
+	return resolved
+}
+
+// resolveReferences rewrites the references to other containers in
+// hostConfig to names, which stay valid when those are recreated.
+func (c *scanCycle) resolveReferences(hostConfig *container.HostConfig) {
+	hostConfig.NetworkMode = container.NetworkMode(c.resolveNetworkMode(string(hostConfig.NetworkMode)))
+	hostConfig.VolumesFrom = c.resolveVolumesFrom(hostConfig.VolumesFrom)
+}
+
+// dependencies returns the names of the containers cont needs to exist
+// before it can be created.
+func (c *scanCycle) dependencies(cont types.Container) []string {
+	var deps []string
+	if ref, ok := networkOwner(cont.HostConfig.NetworkMode); ok {
+		if name, ok := c.resolve(ref); ok {
+			deps = append(deps, name)
+		}
+	}
+	return append(deps, c.links[containerName(cont)]...)
+}
+
+// parseLink splits a --link into the name of the linked-to container and its
+// alias. Inspect reports links as "/<name>:/<container>/<alias>", while they
+// are given as "<name>[:<alias>]".
+func parseLink(link string) (name, alias string) {
+	name, alias, ok := strings.Cut(link, ":")
+	if !ok {
+		alias = name
+	}
+	if strings.HasPrefix(name, "/") {
+		name = strings.TrimPrefix(name, "/")
+		alias = path.Base(alias)
+	}
+	return name, alias
+}
+
+// recreateLinks returns the inspected --link entries in the form they are
+// given at creation.
+func recreateLinks(links []string) []string {
+	if len(links) == 0 {
+		return nil
+	}
+	recreated := make([]string, len(links))
+	for i, link := range links {
+		name, alias := parseLink(link)
+		recreated[i] = name + ":" + alias
+	}
+	return recreated
+}
+
+// orderByLinks sorts the pending updates of a group so that every container
+// is recreated after the containers it links to with --link, keeping the
+// order of the group otherwise. Link cycles are broken arbitrarily.
+func orderByLinks(pending []pendingUpdate) []pendingUpdate {
+	byName := make(map[string]int, len(pending))
+	for i, p := range pending {
+		byName[containerName(p.cont)] = i
+	}
+
+	ordered := make([]pendingUpdate, 0, len(pending))
+	visited := make([]bool, len(pending))
+	var visit func(i int)
+	visit = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		if inspect := pending[i].inspect; inspect.ContainerJSONBase != nil && inspect.HostConfig != nil {
+			for _, link := range inspect.HostConfig.Links {
+				name, _ := parseLink(link)
+				if j, ok := byName[name]; ok {
+					visit(j)
+				}
+			}
+		}
+		ordered = append(ordered, pending[i])
+	}
+	for i := range pending {
+		visit(i)
+	}
+	return ordered
+}
+
+// orderContainers sorts containers so that every container comes after the
+// containers it depends on, e.g. a sidecar after the container whose network
+// namespace it shares. Otherwise the original order is kept. Dependency
+// cycles are broken arbitrarily.
+func (c *scanCycle) orderContainers(containers []types.Container) []types.Container {
+	byName := make(map[string]types.Container, len(containers))
+	for _, cont := range containers {
+		byName[containerName(cont)] = cont
+	}
+
+	ordered := make([]types.Container, 0, len(containers))
+	visited := make(map[string]bool, len(containers))
+	var visit func(cont types.Container)
+	visit = func(cont types.Container) {
+		name := containerName(cont)
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, dep := range c.dependencies(cont) {
+			if depCont, ok := byName[dep]; ok {
+				visit(depCont)
+			}
+		}
+		ordered = append(ordered, cont)
+	}
+
+	for _, cont := range containers {
+		visit(cont)
+	}
+	return ordered
+}
@@ -0,0 +1,66 @@
+package updater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestSidecarSharingNetworkNamespace(t *testing.T) {
+	vpn := testContainer("vpn")
+	vpn.Image = "vpn:latest"
+	sidecar := testContainer("app")
+	sidecar.Image = "app:latest"
+	sidecar.HostConfig.NetworkMode = "container:" + vpn.ID
+
+	cli := &fakeClient{
+		// The sidecar is listed first
+		containers: []types.Container{sidecar, vpn},
+		inspect: map[string]types.ContainerJSON{
+			vpn.ID:     namedInspect("vpn", &container.HostConfig{NetworkMode: "bridge"}),
+			sidecar.ID: namedInspect("app", &container.HostConfig{NetworkMode: container.NetworkMode("container:" + vpn.ID)}),
+		},
+	}
+
+	if _, err := scanAll(cli, Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cli.created) != 2 {
+		t.Fatalf("got %d containers created, want 2", len(cli.created))
+	}
+	if cli.created[0].name != "vpn" || cli.created[1].name != "app" {
+		t.Errorf("namespace owner must be recreated first, got order %s, %s", cli.created[0].name, cli.created[1].name)
+	}
+	// The stale ID of the old vpn container must not be used
+	if got := cli.created[1].hostConfig.NetworkMode; got != "container:vpn" {
+		t.Errorf("got network mode %q, want %q", got, "container:vpn")
+	}
+}
+
+func TestScanCycleResolve(t *testing.T) {
+	a := testContainer("a")
+	cycle := newScanCycle([]types.Container{a, testContainer("b")})
+
+	for ref, want := range map[string]string{a.ID: "a", a.ID[:12]: "a", "a": "a"} {
+		if got, ok := cycle.resolve(ref); !ok || got != want {
+			t.Errorf("resolve(%q) = %q, %v; want %q", ref, got, ok, want)
+		}
+	}
+	if _, ok := cycle.resolve("missing"); ok {
+		t.Error("resolve of an unknown reference should fail")
+	}
+}
+
+func TestOrderContainersByLinks(t *testing.T) {
+	web, db := testContainer("web"), testContainer("db")
+	db.Names = append(db.Names, "/web/db")
+	cycle := newScanCycle([]types.Container{web, db})
+
+	ordered := cycle.orderContainers([]types.Container{web, db})
+	if got := []string{containerName(ordered[0]), containerName(ordered[1])}; !reflect.DeepEqual(got, []string{"db", "web"}) {
+		t.Errorf("got order %v, want db before web, which links to it", got)
+	}
+}
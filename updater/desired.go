@@ -0,0 +1,95 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"gopkg.in/yaml.v3"
+)
+
+// loadDesiredState reads the desired state file at path, which maps
+// container names to the image each should run, pinned by digest.
+func loadDesiredState(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var desired map[string]string
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(data, &desired)
+	} else {
+		err = yaml.Unmarshal(data, &desired)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	for name, image := range desired {
+		if _, err := digest.Parse(image); err == nil {
+			continue
+		}
+		if _, _, err := desiredRef("", image); err != nil {
+			return nil, fmt.Errorf("%s: container %s: %w", path, name, err)
+		}
+	}
+	return desired, nil
+}
+
+// desiredRef resolves the desired image of a container currently created
+// from current: either a reference pinned by digest, e.g.
+// "nginx:1.27@sha256:…", or just the digest, which then refers to the
+// repository of current.
+func desiredRef(current, desired string) (ref, dgst string, err error) {
+	if d, err := digest.Parse(desired); err == nil {
+		named, err := reference.ParseNormalizedNamed(current)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid image reference %q: %w", current, err)
+		}
+		canonical, err := reference.WithDigest(reference.TrimNamed(named), d)
+		if err != nil {
+			return "", "", err
+		}
+		return reference.FamiliarString(canonical), d.String(), nil
+	}
+	named, err := reference.ParseNormalizedNamed(desired)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid image reference %q: %w", desired, err)
+	}
+	canonical, ok := named.(reference.Canonical)
+	if !ok {
+		return "", "", fmt.Errorf("image %q is not pinned by digest", desired)
+	}
+	return reference.FamiliarString(canonical), canonical.Digest().String(), nil
+}
+
+// reloadDesiredState reads desired_state_file again, if set. If it cannot
+// be read, the last state read stays in effect.
+func (u *Updater) reloadDesiredState() {
+	path := u.Config().DesiredStateFile
+	if path == "" {
+		u.mu.Lock()
+		u.desired = nil
+		u.mu.Unlock()
+		return
+	}
+	desired, err := loadDesiredState(path)
+	if err != nil {
+		u.logger.Printf("Error loading desired state, keeping the last one: %v", err)
+		return
+	}
+	u.mu.Lock()
+	u.desired = desired
+	u.mu.Unlock()
+}
+
+// desiredImage returns the image the desired state file wants the
+// container name to run, if it lists the container.
+func (u *Updater) desiredImage(name string) (string, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	image, ok := u.desired[name]
+	return image, ok
+}
@@ -0,0 +1,72 @@
+package updater
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestDesiredState(t *testing.T) {
+	digestA, digestB := "sha256:"+strings.Repeat("a", 64), "sha256:"+strings.Repeat("b", 64)
+	path := filepath.Join(t.TempDir(), "desired.yaml")
+	state := "web: nginx:1.27@" + digestB + "\ndb: " + digestA + "\n"
+	if err := os.WriteFile(path, []byte(state), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	web, db, other := testContainer("web"), testContainer("db"), testContainer("other")
+	web.Image, db.Image = "nginx:1.27", "postgres:16"
+	inspect := func(name, image string) types.ContainerJSON {
+		inspect := namedInspect(name, &container.HostConfig{})
+		inspect.Image = image
+		return inspect
+	}
+	cli := &fakeClient{
+		containers: []types.Container{web, db, other},
+		inspect: map[string]types.ContainerJSON{
+			web.ID:   inspect("web", "sha256:web"),
+			db.ID:    inspect("db", "sha256:db"),
+			other.ID: inspect("other", "sha256:other"),
+		},
+		images: map[string]types.ImageInspect{
+			"sha256:web": {ID: "sha256:web", RepoDigests: []string{"nginx@" + digestA}},
+			"sha256:db":  {ID: "sha256:db", RepoDigests: []string{"postgres@" + digestA}},
+		},
+	}
+
+	u := New(cli, Config{DesiredStateFile: path}, nil)
+	results, err := u.ScanOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got results for %d containers, want web and db", len(results))
+	}
+	if !results[0].Updated || results[1].Updated {
+		t.Errorf("got updated=%v for web and %v for db, want only web reconciled", results[0].Updated, results[1].Updated)
+	}
+	if !containsName(cli.calls, "pull nginx:1.27@"+digestB) {
+		t.Errorf("desired image of web not pulled, got calls %v", cli.calls)
+	}
+	if got := cli.created[0].config.Image; got != "nginx:1.27@"+digestB {
+		t.Errorf("web recreated from %s, want its desired image", got)
+	}
+	if containsName(cli.calls, "pull postgres:16") || containsName(cli.calls, "pull postgres@"+digestA) {
+		t.Error("db pulled although it is in its desired state")
+	}
+}
+
+func TestLoadDesiredStateRequiresDigests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "desired.json")
+	if err := os.WriteFile(path, []byte(`{"web": "nginx:1.27"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadDesiredState(path); err == nil {
+		t.Error("no error for an image not pinned by digest")
+	}
+}
@@ -0,0 +1,72 @@
+package updater
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// specDiff lists the fields that differ between old and new, two values of
+// the same struct type, as "Prefix.Field: old -> new". Fields set in old but
+// not in new are reported as dropped. Nested structs are compared field by
+// field; embedded structs are flattened into their parent.
+func specDiff(prefix string, old, new interface{}) []string {
+	return diffValues(prefix, reflect.ValueOf(old), reflect.ValueOf(new))
+}
+
+func diffValues(prefix string, old, new reflect.Value) []string {
+	old, new = indirect(old), indirect(new)
+	if !old.IsValid() && !new.IsValid() {
+		return nil
+	}
+	if old.IsValid() && new.IsValid() && old.Kind() == reflect.Struct {
+		var diffs []string
+		t := old.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := prefix + "." + f.Name
+			if f.Anonymous {
+				name = prefix
+			}
+			diffs = append(diffs, diffValues(name, old.Field(i), new.Field(i))...)
+		}
+		return diffs
+	}
+
+	oldSet, newSet := isSet(old), isSet(new)
+	switch {
+	case !oldSet && !newSet:
+		return nil
+	case !newSet:
+		return []string{fmt.Sprintf("%s: %v -> (dropped)", prefix, old.Interface())}
+	case !oldSet:
+		return []string{fmt.Sprintf("%s: (unset) -> %v", prefix, new.Interface())}
+	case !reflect.DeepEqual(old.Interface(), new.Interface()):
+		return []string{fmt.Sprintf("%s: %v -> %v", prefix, old.Interface(), new.Interface())}
+	}
+	return nil
+}
+
+// indirect follows pointers, returning the invalid Value for nil.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func isSet(v reflect.Value) bool {
+	if !v.IsValid() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() > 0
+	}
+	return !v.IsZero()
+}
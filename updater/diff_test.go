@@ -0,0 +1,37 @@
+package updater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestSpecDiff(t *testing.T) {
+	pids := int64(100)
+	old := &container.HostConfig{
+		CapAdd:      []string{"NET_ADMIN"},
+		NetworkMode: "bridge",
+		Resources:   container.Resources{Memory: 512, PidsLimit: &pids},
+	}
+	new := &container.HostConfig{
+		NetworkMode: "host",
+		Resources:   container.Resources{Memory: 512},
+		Privileged:  true,
+	}
+
+	got := specDiff("HostConfig", old, new)
+	want := []string{
+		"HostConfig.NetworkMode: bridge -> host",
+		"HostConfig.CapAdd: [NET_ADMIN] -> (dropped)",
+		"HostConfig.Privileged: (unset) -> true",
+		"HostConfig.PidsLimit: 100 -> (dropped)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got diff %q, want %q", got, want)
+	}
+
+	if diff := specDiff("HostConfig", old, old); diff != nil {
+		t.Errorf("got diff %q for identical configs, want none", diff)
+	}
+}
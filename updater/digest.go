@@ -0,0 +1,101 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestBuffer collects the notifications held back for the next digest.
+type digestBuffer struct {
+	mu       sync.Mutex
+	since    time.Time
+	lines    []string
+	updates  int
+	failures int
+}
+
+// digestLine describes n in one line of a digest.
+func digestLine(n notification) string {
+	if n.Stage != "" {
+		return fmt.Sprintf("%s failed in stage %s: %s", n.Container, n.Stage, n.Message)
+	}
+	return n.Message
+}
+
+// holdForDigest buffers n for the next digest instead of sending it, if
+// notification_digest is set. failure marks an alert, which is sent right
+// away with notify_failures_immediately.
+func (u *Updater) holdForDigest(n notification, failure bool) bool {
+	cfg := u.Config()
+	if cfg.NotificationDigest == "" || len(cfg.NotifyURLs) == 0 || (failure && cfg.NotifyFailuresImmediately) {
+		return false
+	}
+	d := &u.digest
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.lines) == 0 {
+		d.since = time.Now()
+	}
+	d.lines = append(d.lines, digestLine(n))
+	if failure {
+		d.failures++
+	} else {
+		d.updates++
+	}
+	return true
+}
+
+// SendDigest sends the notifications buffered since the last digest as one,
+// if there are any. It is called on the notification_digest schedule by
+// RunDigest, and should be called before exiting so nothing is lost.
+func (u *Updater) SendDigest() {
+	d := &u.digest
+	d.mu.Lock()
+	lines, since, updates, failures := d.lines, d.since, d.updates, d.failures
+	d.lines, d.updates, d.failures = nil, 0, 0
+	d.mu.Unlock()
+
+	if len(lines) == 0 {
+		return
+	}
+	u.notify(notification{
+		Title:   fmt.Sprintf("hikup: %d updated, %d failed since %s", updates, failures, since.Format("2006-01-02 15:04")),
+		Message: strings.Join(lines, "\n"),
+	})
+}
+
+// RunDigest sends a digest at every time matching notification_digest until
+// ctx is done. Changes of the schedule by a configuration reload are picked
+// up within a minute.
+func (u *Updater) RunDigest(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	var expr string
+	var schedule *cronSchedule
+	var next time.Time
+	for {
+		if e := u.Config().NotificationDigest; e != expr {
+			expr, schedule, next = e, nil, time.Time{}
+			if e != "" {
+				// Validated with the configuration
+				schedule, _ = parseCron(e)
+			}
+			if schedule != nil {
+				next = schedule.Next(time.Now())
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !next.IsZero() && !now.Before(next) {
+				u.SendDigest()
+				next = schedule.Next(now)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package updater
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/docker/go-units"
+)
+
+// defaultDockerDataRoot is where the Docker daemon stores images unless
+// configured otherwise.
+const defaultDockerDataRoot = "/var/lib/docker"
+
+func (c Config) dockerDataRoot() string {
+	if c.DockerDataRoot != "" {
+		return c.DockerDataRoot
+	}
+	return defaultDockerDataRoot
+}
+
+// parseDiskSize parses a size such as "10GB" or "512m", in powers of 1024.
+func parseDiskSize(size string) (uint64, error) {
+	n, err := units.RAMInBytes(size)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return uint64(n), nil
+}
+
+// freeDisk returns how many bytes are available to unprivileged users on
+// the file system holding path.
+func freeDisk(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// checkFreeDisk fails if less than min_free_disk is available on the
+// Docker data root. It only logs a data root that cannot be checked.
+func (u *Updater) checkFreeDisk() error {
+	cfg := u.Config()
+	if cfg.MinFreeDisk == "" {
+		return nil
+	}
+	min, err := parseDiskSize(cfg.MinFreeDisk)
+	if err != nil {
+		return err
+	}
+	free, err := freeDisk(cfg.dockerDataRoot())
+	if err != nil {
+		u.logger.Printf("Warning: cannot check the free disk space for min_free_disk: %v", err)
+		return nil
+	}
+	if free < min {
+		return fmt.Errorf("only %s free on %s, min_free_disk is %s", units.BytesSize(float64(free)), cfg.dockerDataRoot(), cfg.MinFreeDisk)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestMinFreeDisk(t *testing.T) {
+	cont := testContainer("web")
+	newCli := func() *fakeClient {
+		return &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})}}
+	}
+	root := t.TempDir()
+
+	cli := newCli()
+	r := testUpdate(cli, Config{MinFreeDisk: "1000PB", DockerDataRoot: root}, cont)
+	if r.Stage != StagePull {
+		t.Fatalf("got stage %q with too little free disk, want %q", r.Stage, StagePull)
+	}
+	if containsName(cli.calls, "pull web:latest") {
+		t.Error("image pulled with too little free disk")
+	}
+
+	if r := testUpdate(newCli(), Config{MinFreeDisk: "1KB", DockerDataRoot: root}, cont); !r.Updated {
+		t.Errorf("container not updated with enough free disk: %v", r.Err)
+	}
+}
+
+func TestValidateMinFreeDisk(t *testing.T) {
+	if err := (Config{MinFreeDisk: "lots"}).Validate(); err == nil {
+		t.Error("expected an invalid min_free_disk to be rejected")
+	}
+}
@@ -0,0 +1,49 @@
+package updater
+
+import (
+	"slices"
+
+	"github.com/docker/docker/api/types"
+)
+
+// followImageCommand makes a container that runs the default ENTRYPOINT
+// (and CMD) of its old image pick up the defaults of the new image, by
+// clearing them in inspectData so they are not recreated as overrides. It
+// returns which of the two changed, if any. Explicit overrides are kept;
+// without the old image, the defaults are unknown and nothing changes.
+func followImageCommand(inspectData *types.ContainerJSON, oldImage, newImage types.ImageInspect) (changed []string) {
+	if oldImage.Config == nil || newImage.Config == nil || inspectData.Config == nil {
+		return nil
+	}
+	config := *inspectData.Config
+	// An explicit entrypoint also replaces the image's CMD, so only a
+	// container running the default entrypoint can follow either
+	if !slices.Equal(config.Entrypoint, oldImage.Config.Entrypoint) {
+		return nil
+	}
+	if !slices.Equal(config.Entrypoint, newImage.Config.Entrypoint) {
+		changed = append(changed, "entrypoint")
+	}
+	config.Entrypoint = nil
+	if slices.Equal(config.Cmd, oldImage.Config.Cmd) {
+		if !slices.Equal(config.Cmd, newImage.Config.Cmd) {
+			changed = append(changed, "cmd")
+		}
+		config.Cmd = nil
+	}
+	inspectData.Config = &config
+	return changed
+}
+
+// followImageUser clears the user in inspectData if it is the USER of the
+// old image, so the container runs as the new image's default user rather
+// than being pinned to the old one. A user set with --user is kept. Without
+// the old image, the user is kept as well.
+func followImageUser(inspectData *types.ContainerJSON, oldImage types.ImageInspect) {
+	if oldImage.Config == nil || inspectData.Config == nil || inspectData.Config.User != oldImage.Config.User {
+		return
+	}
+	config := *inspectData.Config
+	config.User = ""
+	inspectData.Config = &config
+}
@@ -0,0 +1,61 @@
+package updater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestFollowImageCommand(t *testing.T) {
+	image := func(id string, entrypoint, cmd []string) types.ImageInspect {
+		return types.ImageInspect{ID: id, Config: &container.Config{Entrypoint: entrypoint, Cmd: cmd}}
+	}
+	oldImage := image("sha256:old", []string{"/old-entry"}, []string{"serve"})
+	newImage := image("sha256:new", []string{"/new-entry"}, []string{"run"})
+
+	tests := []struct {
+		name           string
+		entrypoint     []string
+		cmd            []string
+		wantEntrypoint []string
+		wantCmd        []string
+	}{
+		{"defaults", []string{"/old-entry"}, []string{"serve"}, nil, nil},
+		{"cmd override", []string{"/old-entry"}, []string{"debug"}, nil, []string{"debug"}},
+		{"entrypoint override", []string{"/bin/sh"}, []string{"serve"}, []string{"/bin/sh"}, []string{"serve"}},
+	}
+	for _, tt := range tests {
+		cont := testContainer("web")
+		inspect := namedInspect("web", &container.HostConfig{})
+		inspect.Image = oldImage.ID
+		inspect.Config.Entrypoint, inspect.Config.Cmd = tt.entrypoint, tt.cmd
+		cli := &fakeClient{
+			inspect: map[string]types.ContainerJSON{cont.ID: inspect},
+			images:  map[string]types.ImageInspect{oldImage.ID: oldImage, "web:latest": newImage},
+		}
+
+		if r := testUpdate(cli, Config{FollowImageCommand: true}, cont); r.Err != nil {
+			t.Fatalf("%s: %v", tt.name, r.Err)
+		}
+		config := cli.created[0].config
+		if !reflect.DeepEqual([]string(config.Entrypoint), tt.wantEntrypoint) || !reflect.DeepEqual([]string(config.Cmd), tt.wantCmd) {
+			t.Errorf("%s: recreated with entrypoint %v cmd %v, want %v %v", tt.name, config.Entrypoint, config.Cmd, tt.wantEntrypoint, tt.wantCmd)
+		}
+	}
+
+	// Without the option, the old defaults are kept
+	cont := testContainer("web")
+	inspect := namedInspect("web", &container.HostConfig{})
+	inspect.Image = oldImage.ID
+	inspect.Config.Entrypoint, inspect.Config.Cmd = []string{"/old-entry"}, []string{"serve"}
+	cli := &fakeClient{
+		inspect: map[string]types.ContainerJSON{cont.ID: inspect},
+		images:  map[string]types.ImageInspect{oldImage.ID: oldImage, "web:latest": newImage},
+	}
+	testUpdate(cli, Config{}, cont)
+	if got := cli.created[0].config.Entrypoint; len(got) != 1 || got[0] != "/old-entry" {
+		t.Errorf("got entrypoint %v without follow_image_command, want the old one", got)
+	}
+}
@@ -0,0 +1,177 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// Group is a set of containers that are always updated together.
+type Group struct {
+	Name string `json:"name" yaml:"name"`
+	// Containers lists the members by name, in the order they are started.
+	Containers []string `json:"containers" yaml:"containers"`
+}
+
+// groupOf returns the group the named container belongs to.
+func (c Config) groupOf(name string) (Group, bool) {
+	for _, g := range c.Groups {
+		if containsName(g.Containers, name) {
+			return g, true
+		}
+	}
+	return Group{}, false
+}
+
+// groupMembers returns the selected members of g in the order of the group.
+// Members that do not exist are ignored; members that are not selected are
+// left alone with a warning.
+func (u *Updater) groupMembers(g Group, containers []types.Container) []types.Container {
+	byName := make(map[string]types.Container, len(containers))
+	for _, cont := range containers {
+		byName[baseName(containerName(cont), cont.Labels)] = cont
+	}
+
+	var members []types.Container
+	for _, name := range g.Containers {
+		cont, ok := byName[name]
+		if !ok {
+			continue
+		}
+		if selected, reason := u.Select(cont); !selected {
+			u.logger.Printf("Warning: not updating container %s with group %s: %s", name, g.Name, reason)
+			continue
+		}
+		members = append(members, cont)
+	}
+	return members
+}
+
+// updateGroup updates the members of g as one unit. All images are pulled
+// first, and only if every member can be updated are the members stopped in
+// reverse order, then recreated and started in the order of the group.
+func (u *Updater) updateGroup(ctx context.Context, cycle *scanCycle, g Group, members []types.Container) []Result {
+	updateGroups := make([]string, len(members))
+	for i, cont := range members {
+		updateGroups[i] = cont.Labels[labelUpdateGroup]
+	}
+	unlock, err := u.updateGroups.lock(ctx, updateGroups...)
+	if err != nil {
+		results := make([]Result, len(members))
+		for i, cont := range members {
+			r := Result{Container: baseName(containerName(cont), cont.Labels), ID: cont.ID, OldImage: cont.ImageID}
+			results[i] = r.fail(failAt(StageInspect, "container %s: waiting for its update group: %w", cont.ID[:12], err))
+		}
+		return results
+	}
+	defer unlock()
+
+	pending := make([]pendingUpdate, 0, len(members))
+	changed, blocked := false, false
+	for _, cont := range members {
+		r := Result{Container: baseName(containerName(cont), cont.Labels), ID: cont.ID, OldImage: cont.ImageID}
+		if !u.updating.begin(r.Container) {
+			u.logger.Printf("Skipping container %s: an update of it is already in progress", cont.ID[:12])
+			blocked = true
+			continue
+		}
+		defer u.updating.end(r.Container)
+
+		p, ok := u.prepareUpdate(ctx, cycle, cont, r)
+		switch {
+		case ok:
+			changed = true
+		case !p.unchanged:
+			blocked = true
+		}
+		pending = append(pending, p)
+	}
+	// Containers linked to come first, whatever the order of the group
+	pending = orderByLinks(pending)
+
+	results := func() []Result {
+		rs := make([]Result, len(pending))
+		for i, p := range pending {
+			rs[i] = p.r
+		}
+		return rs
+	}
+	if blocked {
+		u.logger.Printf("Not updating group %s: not all of its members can be updated", g.Name)
+		return results()
+	}
+	if !changed {
+		return results()
+	}
+	for _, p := range pending {
+		if !u.approve(p) {
+			return results()
+		}
+	}
+	if u.SmokeTest {
+		for i, p := range pending {
+			if p.unchanged {
+				continue
+			}
+			if err := u.smokeTest(ctx, p); err != nil {
+				pending[i].r = p.r.fail(failAt(StageVerify, "not updating group %s: image %s of container %s: %w", g.Name, p.cont.Image, p.cont.ID[:12], err))
+				return results()
+			}
+		}
+	}
+
+	for _, p := range pending {
+		if !p.unchanged && !u.awaitIdle(ctx, p) {
+			u.logger.Printf("Deferring group %s: container %s is busy", g.Name, p.r.Container)
+			return results()
+		}
+	}
+
+	u.logger.Printf("Updating group %s", g.Name)
+	for i := len(pending) - 1; i >= 0; i-- {
+		p := pending[i]
+		if err := u.stopContainer(ctx, p.cont.ID, p.inspect); err != nil {
+			pending[i].r = p.r.fail(failAt(StageStop, "error stopping container %s of group %s: %w", p.cont.ID[:12], g.Name, err))
+		}
+	}
+	for i, p := range pending {
+		if p.r.Err != nil {
+			continue
+		}
+		switch p.strategy {
+		case strategyRestartOnly:
+			if err := u.client().ContainerStart(ctx, p.cont.ID, container.StartOptions{}); err != nil {
+				pending[i].r = p.r.fail(failAt(StageStart, "error restarting container %s: %w", p.cont.ID[:12], err))
+			}
+			continue
+		case strategyBlueGreen:
+			u.logger.Printf("Recreating container %s of group %s instead of updating it blue/green", p.cont.ID[:12], g.Name)
+		}
+		pending[i].r = u.recreateStopped(ctx, cycle, p)
+	}
+	return results()
+}
+
+// validateGroups checks that every group is named and that no container is
+// in more than one group.
+func validateGroups(groups []Group) []error {
+	var errs []error
+	seen := make(map[string]string)
+	for i, g := range groups {
+		if g.Name == "" {
+			errs = append(errs, fmt.Errorf("group %d has no name", i+1))
+		}
+		if len(g.Containers) == 0 {
+			errs = append(errs, fmt.Errorf("group %q has no containers", g.Name))
+		}
+		for _, name := range g.Containers {
+			if other, ok := seen[name]; ok {
+				errs = append(errs, fmt.Errorf("container %q is in groups %q and %q", name, other, g.Name))
+			}
+			seen[name] = g.Name
+		}
+	}
+	return errs
+}
@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func groupFixture() (*fakeClient, Config) {
+	app, db, cache := testContainer("app"), testContainer("db"), testContainer("cache")
+	cli := &fakeClient{
+		containers: []types.Container{app, cache, db},
+		inspect: map[string]types.ContainerJSON{
+			app.ID:   namedInspect("app", &container.HostConfig{}),
+			db.ID:    namedInspect("db", &container.HostConfig{}),
+			cache.ID: namedInspect("cache", &container.HostConfig{}),
+		},
+	}
+	cfg := Config{Groups: []Group{{Name: "backend", Containers: []string{"db", "app"}}}}
+	return cli, cfg
+}
+
+func TestScanUpdatesGroupTogether(t *testing.T) {
+	cli, cfg := groupFixture()
+	app, db, cache := cli.containers[0], cli.containers[2], cli.containers[1]
+
+	results, err := scanAll(cli, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+
+	want := []string{
+		// The group, when its first member comes up
+		"pull db:latest", "pull app:latest",
+		"stop " + app.ID, "stop " + db.ID,
+		"remove " + db.ID, "create db", "start new-db000000000000",
+		"remove " + app.ID, "create app", "start new-app000000000000",
+		// Then the ungrouped container
+		"pull cache:latest", "stop " + cache.ID, "remove " + cache.ID, "create cache", "start new-cache000000000000",
+	}
+	if !reflect.DeepEqual(cli.calls, want) {
+		t.Errorf("got calls\n%v\nwant\n%v", cli.calls, want)
+	}
+}
+
+func TestGroupNotUpdatedIfAMemberFails(t *testing.T) {
+	cli, cfg := groupFixture()
+	db := cli.containers[2]
+	cli.inspectErr = map[string]error{db.ID: errors.New("inspect failed")}
+	cli.containers = cli.containers[:1]
+	cli.containers = append(cli.containers, db)
+
+	results, err := scanAll(cli, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Updated {
+			t.Errorf("container %s was updated although group member db failed", r.Container)
+		}
+	}
+	if want := []string{"pull app:latest"}; !reflect.DeepEqual(cli.calls, want) {
+		t.Errorf("got calls %v, want %v", cli.calls, want)
+	}
+}
+
+func TestValidateGroups(t *testing.T) {
+	for _, groups := range [][]Group{
+		{{Containers: []string{"web"}}},
+		{{Name: "empty"}},
+		{{Name: "a", Containers: []string{"web"}}, {Name: "b", Containers: []string{"web"}}},
+	} {
+		if err := (Config{Groups: groups}).Validate(); err == nil {
+			t.Errorf("expected groups %+v to be invalid", groups)
+		}
+	}
+}
+
+func TestGroupRecreatedInLinkOrder(t *testing.T) {
+	web, db := testContainer("web"), testContainer("db")
+	db.Names = append(db.Names, "/web/database")
+	cli := &fakeClient{
+		containers: []types.Container{web, db},
+		inspect: map[string]types.ContainerJSON{
+			web.ID: namedInspect("web", &container.HostConfig{Links: []string{"/db:/web/database"}}),
+			db.ID:  namedInspect("db", &container.HostConfig{}),
+		},
+	}
+	// The group lists web first, but it links to db
+	cfg := Config{Groups: []Group{{Name: "site", Containers: []string{"web", "db"}}}}
+
+	if _, err := scanAll(cli, cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"pull web:latest", "pull db:latest",
+		"stop " + web.ID, "stop " + db.ID,
+		"remove " + db.ID, "create db", "start new-db000000000000",
+		"remove " + web.ID, "create web", "start new-web000000000000",
+	}
+	if !reflect.DeepEqual(cli.calls, want) {
+		t.Errorf("got calls\n%v\nwant\n%v", cli.calls, want)
+	}
+	if got, want := cli.created[1].hostConfig.Links, []string{"db:database"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("web recreated with links %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// healthPollInterval is how often the state of a starting container is
+// checked.
+var healthPollInterval = time.Second
+
+// healthStatus returns the health status of the inspected container, or ""
+// if it has no healthcheck.
+func healthStatus(inspectData types.ContainerJSON) string {
+	if inspectData.ContainerJSONBase == nil || inspectData.State == nil || inspectData.State.Health == nil {
+		return ""
+	}
+	return inspectData.State.Health.Status
+}
+
+// waitHealthy waits until the container reports healthy or, if it has no
+// healthcheck, is running. It fails as soon as the container has exited or
+// is unhealthy, or once timeout has passed. During the start period, a
+// container that is still starting or not yet healthy is given more time,
+// even beyond timeout. A zero startPeriod means the StartPeriod of the
+// container's own healthcheck.
+func waitHealthy(ctx context.Context, cli DockerClient, id string, timeout, startPeriod time.Duration) error {
+	started := time.Now()
+	for {
+		inspect, err := cli.ContainerInspect(ctx, id)
+		if err != nil {
+			return err
+		}
+		if inspect.ContainerJSONBase == nil || inspect.State == nil {
+			return errors.New("container state unknown")
+		}
+		if startPeriod == 0 && inspect.Config != nil && inspect.Config.Healthcheck != nil {
+			startPeriod = inspect.Config.Healthcheck.StartPeriod
+		}
+		inStartPeriod := time.Since(started) < startPeriod
+
+		st := inspect.State
+		switch {
+		case !st.Running:
+			return fmt.Errorf("container exited with code %d", st.ExitCode)
+		case st.Health == nil, st.Health.Status == types.Healthy:
+			return nil
+		case st.Health.Status == types.Unhealthy && !inStartPeriod:
+			return errors.New("container is unhealthy")
+		}
+
+		if time.Since(started) >= timeout && !inStartPeriod {
+			return fmt.Errorf("container not healthy after %s", time.Since(started).Round(time.Second))
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
+
+// watchRestarts watches the container for window and fails once it was
+// restarted more than maxRestarts times, which catches images that start
+// fine but then crash-loop under their restart policy.
+func watchRestarts(ctx context.Context, cli DockerClient, id string, window time.Duration, maxRestarts int) error {
+	started := time.Now()
+	for {
+		inspect, err := cli.ContainerInspect(ctx, id)
+		if err != nil {
+			return err
+		}
+		if inspect.ContainerJSONBase == nil || inspect.State == nil {
+			return errors.New("container state unknown")
+		}
+		if inspect.RestartCount > maxRestarts {
+			return fmt.Errorf("container restarted %d times within %s", inspect.RestartCount, time.Since(started).Round(time.Second))
+		}
+		if time.Since(started) >= window {
+			return nil
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
@@ -0,0 +1,204 @@
+package updater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestWaitHealthy(t *testing.T) {
+	healthPollInterval = time.Millisecond
+	defer func() { healthPollInterval = time.Second }()
+
+	tests := []struct {
+		name    string
+		state   types.ContainerState
+		wantErr bool
+	}{
+		{"healthy", types.ContainerState{Running: true, Health: &types.Health{Status: types.Healthy}}, false},
+		{"no healthcheck", types.ContainerState{Running: true}, false},
+		{"unhealthy", types.ContainerState{Running: true, Health: &types.Health{Status: types.Unhealthy}}, true},
+		{"exited", types.ContainerState{ExitCode: 1}, true},
+		{"starting", types.ContainerState{Running: true, Health: &types.Health{Status: types.Starting}}, true},
+	}
+	for _, tt := range tests {
+		cli := &fakeClient{inspect: map[string]types.ContainerJSON{
+			"c": {ContainerJSONBase: &types.ContainerJSONBase{State: &tt.state}},
+		}}
+		err := waitHealthy(context.Background(), cli, "c", 5*time.Millisecond, 0)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWaitHealthyStartPeriod(t *testing.T) {
+	healthPollInterval = time.Millisecond
+	defer func() { healthPollInterval = time.Second }()
+
+	// Reports unhealthy for the first polls, then healthy
+	polls := 0
+	cli := &flappingClient{fakeClient: &fakeClient{}, unhealthyPolls: 5, polls: &polls}
+
+	if err := waitHealthy(context.Background(), cli, "c", time.Millisecond, time.Second); err != nil {
+		t.Errorf("got %v, want the start period to cover the slow start", err)
+	}
+
+	polls = 0
+	if err := waitHealthy(context.Background(), cli, "c", time.Millisecond, 0); err == nil {
+		t.Error("expected an unhealthy container to fail without a start period")
+	}
+
+	// The container's own healthcheck start period applies by default
+	polls = 0
+	cli.startPeriod = time.Second
+	if err := waitHealthy(context.Background(), cli, "c", time.Millisecond, 0); err != nil {
+		t.Errorf("got %v, want the healthcheck's start period to apply", err)
+	}
+}
+
+// flappingClient reports a container as unhealthy for a number of inspects
+// before it becomes healthy.
+type flappingClient struct {
+	*fakeClient
+	unhealthyPolls int
+	polls          *int
+	startPeriod    time.Duration
+}
+
+func (c *flappingClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	*c.polls++
+	status := types.Healthy
+	if *c.polls <= c.unhealthyPolls {
+		status = types.Unhealthy
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{Running: true, Health: &types.Health{Status: status}}},
+		Config:            &container.Config{Healthcheck: &container.HealthConfig{StartPeriod: c.startPeriod}},
+	}, nil
+}
+
+func TestUpdateWaitsForHealth(t *testing.T) {
+	healthPollInterval = time.Millisecond
+	defer func() { healthPollInterval = time.Second }()
+
+	cont := testContainer("web")
+	newID := "new-web000000000000"
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{
+		cont.ID: namedInspect("web", &container.HostConfig{}),
+		newID: {ContainerJSONBase: &types.ContainerJSONBase{
+			State: &types.ContainerState{Running: true, Health: &types.Health{Status: types.Unhealthy}},
+		}},
+	}}
+
+	r := testUpdate(cli, Config{HealthTimeout: Duration(5 * time.Millisecond)}, cont)
+	if r.Stage != StageHealth {
+		t.Errorf("got stage %q err=%v, want %q", r.Stage, r.Err, StageHealth)
+	}
+}
+
+func TestRestartLoopRollsBack(t *testing.T) {
+	healthPollInterval = time.Millisecond
+	defer func() { healthPollInterval = time.Second }()
+
+	cont := testContainer("web")
+	cont.ImageID = "sha256:old"
+	newID := "new-web000000000000"
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{
+		cont.ID: namedInspect("web", &container.HostConfig{}),
+		newID: {ContainerJSONBase: &types.ContainerJSONBase{
+			State:        &types.ContainerState{Running: true},
+			RestartCount: 3,
+		}},
+	}}
+
+	cfg := Config{RestartWatch: Duration(time.Second), MaxRestarts: 2}
+	if r := testUpdate(cli, cfg, cont); r.Stage != StageRestartLoop {
+		t.Fatalf("got stage %q err=%v, want %q", r.Stage, r.Err, StageRestartLoop)
+	}
+	if len(cli.created) != 1 {
+		t.Errorf("got %d containers created without rollback, want 1", len(cli.created))
+	}
+
+	cli.calls, cli.created = nil, nil
+	cfg.RollbackOnRestartLoop = true
+	testUpdate(cli, cfg, cont)
+	if len(cli.created) != 2 {
+		t.Fatalf("got %d containers created, want the update and the rollback", len(cli.created))
+	}
+	rolledBack := cli.created[1].config
+	if rolledBack.Image != "sha256:old" || rolledBack.Labels[labelImage] != "web:latest" {
+		t.Errorf("rolled back to image %q labeled %q, want sha256:old labeled web:latest", rolledBack.Image, rolledBack.Labels[labelImage])
+	}
+	if !containsName(cli.calls, "remove "+newID) {
+		t.Errorf("crash-looping container was not removed, calls %v", cli.calls)
+	}
+}
+
+func TestSmokeTest(t *testing.T) {
+	healthPollInterval = time.Millisecond
+	smokeTestPeriod = 5 * time.Millisecond
+	defer func() { healthPollInterval, smokeTestPeriod = time.Second, 10*time.Second }()
+
+	smokeID := "new-web-hikup-smoke000000000000"
+	tests := []struct {
+		state *types.ContainerState
+		want  bool
+	}{
+		{&types.ContainerState{Running: true}, true},
+		{&types.ContainerState{ExitCode: 0}, true},
+		{&types.ContainerState{ExitCode: 1}, false},
+	}
+	for _, tt := range tests {
+		cont := testContainer("web")
+		cli := &fakeClient{inspect: map[string]types.ContainerJSON{
+			cont.ID: namedInspect("web", &container.HostConfig{Binds: []string{"/srv/web:/data"}}),
+			smokeID: {ContainerJSONBase: &types.ContainerJSONBase{State: tt.state}},
+		}}
+		u := New(cli, Config{}, nil)
+		u.SmokeTest = true
+
+		r := u.updateContainer(context.Background(), nil, cont)
+		if r.Updated != tt.want {
+			t.Errorf("smoke test container %+v: got updated %v (%v), want %v", tt.state, r.Updated, r.Err, tt.want)
+		}
+		smoke := cli.created[0]
+		if smoke.name != "web-hikup-smoke" || len(smoke.hostConfig.Binds) != 0 || smoke.hostConfig.NetworkMode != "none" {
+			t.Errorf("got smoke test container %s with %+v, want it isolated", smoke.name, smoke.hostConfig)
+		}
+		if !containsName(cli.calls, "remove "+smokeID) {
+			t.Errorf("smoke test container not removed: %v", cli.calls)
+		}
+	}
+}
+
+func TestRequireHealthyBeforeUpdate(t *testing.T) {
+	for _, tt := range []struct {
+		status string
+		want   bool
+	}{
+		{types.Healthy, true},
+		{types.Unhealthy, false},
+		{types.Starting, false},
+		{"", true},
+	} {
+		cont := testContainer("web")
+		inspect := namedInspect("web", &container.HostConfig{})
+		inspect.State = &types.ContainerState{Running: true}
+		if tt.status != "" {
+			inspect.State.Health = &types.Health{Status: tt.status}
+		}
+		cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+
+		r := testUpdate(cli, Config{RequireHealthyBeforeUpdate: true}, cont)
+		if r.Err != nil {
+			t.Fatalf("%q: %v", tt.status, r.Err)
+		}
+		if r.Updated != tt.want {
+			t.Errorf("%q: got updated=%v, want %v", tt.status, r.Updated, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package updater
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// HistoryEntry is one line of the update history log.
+type HistoryEntry struct {
+	Time      time.Time `json:"time"`
+	Cycle     time.Time `json:"cycle"`
+	Container string    `json:"container"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	// FromVersion and ToVersion are the images' version labels, if any.
+	FromVersion string `json:"from_version,omitempty"`
+	ToVersion   string `json:"to_version,omitempty"`
+}
+
+// historyLog is an append-only JSONL log of updates. Nothing is recorded if
+// path is empty.
+type historyLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// ErrHistoryDisabled is returned when reading the history of an Updater
+// without a history file.
+var ErrHistoryDisabled = errors.New("update history is not enabled")
+
+func (h *historyLog) setPath(path string) {
+	h.mu.Lock()
+	h.path = path
+	h.mu.Unlock()
+}
+
+// History returns the recorded updates of the named container, oldest
+// first, or of all containers if name is empty.
+func (u *Updater) History(name string) ([]HistoryEntry, error) {
+	return u.history.entries(name)
+}
+
+// record appends the update described by r, made during cycle.
+func (h *historyLog) record(cycle *scanCycle, r Result) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.path == "" {
+		return nil
+	}
+	entry := HistoryEntry{
+		Time:        time.Now().UTC(),
+		Container:   r.Container,
+		From:        r.OldImage,
+		To:          r.NewImage,
+		FromVersion: r.OldVersion,
+		ToVersion:   r.NewVersion,
+	}
+	if cycle != nil {
+		entry.Cycle = cycle.started.UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// entries returns the recorded updates, oldest first. If container is not
+// empty only its updates are returned.
+func (h *historyLog) entries(container string) ([]HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.path == "" {
+		return nil, ErrHistoryDisabled
+	}
+	entries := []HistoryEntry{}
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e HistoryEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// Skip lines torn by a crash mid-write
+			continue
+		}
+		if container == "" || e.Container == container {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sc.Err()
+}
@@ -0,0 +1,66 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestHistory(t *testing.T) {
+	u := New(nil, Config{}, nil)
+	if _, err := u.History("web"); !errors.Is(err, ErrHistoryDisabled) {
+		t.Fatalf("got error %v without a history file, want ErrHistoryDisabled", err)
+	}
+
+	u.SetHistoryFile(filepath.Join(t.TempDir(), "history.jsonl"))
+	cycle := &scanCycle{started: time.Now()}
+	u.history.record(cycle, Result{Container: "web", OldImage: "sha256:1", NewImage: "sha256:2"})
+	u.history.record(cycle, Result{Container: "db", OldImage: "sha256:a", NewImage: "sha256:b"})
+	u.history.record(cycle, Result{Container: "web", OldImage: "sha256:2", NewImage: "sha256:3"})
+
+	entries, err := u.History("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].To != "sha256:2" || entries[1].From != "sha256:2" {
+		t.Errorf("unexpected history %+v", entries)
+	}
+}
+
+func TestRollbackLast(t *testing.T) {
+	web, db, api := testContainer("web"), testContainer("db"), testContainer("api")
+	web.ImageID, db.ImageID, api.ImageID = "sha256:web2", "sha256:db3", "sha256:api2"
+	cli := &fakeClient{
+		containers: []types.Container{web, db, api},
+		inspect: map[string]types.ContainerJSON{
+			web.ID: namedInspect("web", &container.HostConfig{}),
+			db.ID:  namedInspect("db", &container.HostConfig{}),
+			api.ID: namedInspect("api", &container.HostConfig{}),
+		},
+	}
+	u := New(cli, Config{}, nil)
+	u.SetHistoryFile(filepath.Join(t.TempDir(), "history.jsonl"))
+
+	earlier, last := &scanCycle{started: time.Now().Add(-time.Hour)}, &scanCycle{started: time.Now()}
+	u.history.record(earlier, Result{Container: "api", OldImage: "sha256:api1", NewImage: "sha256:api2"})
+	u.history.record(last, Result{Container: "web", OldImage: "sha256:web1", NewImage: "sha256:web2"})
+	// Updated again by hand since
+	u.history.record(last, Result{Container: "db", OldImage: "sha256:db1", NewImage: "sha256:db2"})
+
+	results, err := u.RollbackLast(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Container != "web" || !results[0].Updated {
+		t.Fatalf("got results %+v, want only web rolled back", results)
+	}
+	created := cli.created[0].config
+	if created.Image != "sha256:web1" || created.Labels[labelImage] != "web:latest" {
+		t.Errorf("got web recreated from %s labeled %v, want sha256:web1 labeled with its reference", created.Image, created.Labels)
+	}
+}
@@ -0,0 +1,63 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const defaultCycleCommandTimeout = 5 * time.Minute
+
+// limitedBuffer keeps the first limit bytes written to it, or all with a
+// limit of 0, and discards the rest.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && b.buf.Len()+len(p) > b.limit {
+		b.buf.Write(p[:max(b.limit-b.buf.Len(), 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// runCycleCommand runs command with sh -c, logging its output line by line
+// prefixed with name, e.g. "pre_cycle_command". env is added to hikup's own
+// environment.
+func (u *Updater) runCycleCommand(name, command string, timeout time.Duration, env ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	out := &limitedBuffer{limit: u.Config().HookOutputLimit}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = out, out
+	// Don't wait forever for children of the killed shell holding the output
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+
+	if out.truncated {
+		out.buf.WriteString("...(truncated)")
+	}
+	sc := bufio.NewScanner(&out.buf)
+	for sc.Scan() {
+		u.logger.Printf("%s: %s", name, sc.Text())
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s", name, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package updater
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestRunCycleCommand(t *testing.T) {
+	var logs bytes.Buffer
+	u := New(nil, Config{}, log.New(&logs, "", 0))
+
+	if err := u.runCycleCommand("pre_cycle_command", `echo "snapshot $SNAP"`, time.Second, "SNAP=tank/docker"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "pre_cycle_command: snapshot tank/docker\n"; logs.String() != want {
+		t.Errorf("got log %q, want %q", logs.String(), want)
+	}
+
+	if err := u.runCycleCommand("pre_cycle_command", "exit 3", time.Second); err == nil {
+		t.Error("expected an error for a non-zero exit status")
+	}
+	if err := u.runCycleCommand("pre_cycle_command", "sleep 5", 10*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got error %v, want a timeout", err)
+	}
+}
+
+func TestScanAbortsOnFailingPreCycleCommand(t *testing.T) {
+	cont := testContainer("web")
+	cli := &fakeClient{containers: []types.Container{cont}}
+	results, err := scanAll(cli, Config{PreCycleCommand: "exit 1"})
+	if err == nil {
+		t.Fatal("expected the scan to fail")
+	}
+	if len(results) != 0 || len(cli.calls) != 0 {
+		t.Errorf("got results %v and calls %v, want none", results, cli.calls)
+	}
+}
+
+func TestHookOutputLimit(t *testing.T) {
+	var logs bytes.Buffer
+	u := New(nil, Config{HookOutputLimit: 10}, log.New(&logs, "", 0))
+
+	if err := u.runCycleCommand("post_cycle_command", "echo first; echo second line; echo third", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if want := "post_cycle_command: first\npost_cycle_command: seco...(truncated)\n"; logs.String() != want {
+		t.Errorf("got log %q, want %q", logs.String(), want)
+	}
+}
@@ -0,0 +1,78 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// labelIdleCheck is a shell command run inside a container before it is
+// stopped for an update. The update is deferred unless it exits with 0,
+// e.g. while a worker is in the middle of a job.
+const labelIdleCheck = "hikup.idle-check"
+
+// idleCheckTimeout limits how long an idle check may run.
+const idleCheckTimeout = time.Minute
+
+// idleCheck runs cmd with sh -c in the container id and fails unless it
+// exits with 0.
+func idleCheck(ctx context.Context, cli DockerClient, id, cmd string) error {
+	ctx, cancel := context.WithTimeout(ctx, idleCheckTimeout)
+	defer cancel()
+
+	exec, err := cli.ContainerExecCreate(ctx, id, container.ExecOptions{Cmd: []string{"sh", "-c", cmd}})
+	if err != nil {
+		return fmt.Errorf("error creating idle check: %w", err)
+	}
+	if err := cli.ContainerExecStart(ctx, exec.ID, container.ExecStartOptions{Detach: true}); err != nil {
+		return fmt.Errorf("error starting idle check: %w", err)
+	}
+	for {
+		inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("idle check timed out after %s", idleCheckTimeout)
+		}
+		if err != nil {
+			return fmt.Errorf("error inspecting idle check: %w", err)
+		}
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return fmt.Errorf("idle check exited with code %d", inspect.ExitCode)
+			}
+			return nil
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
+
+// awaitIdle runs the idle check of the container of p, if it has one and is
+// running. It returns false if the update is to be deferred to a later scan
+// because the container is busy. After max_idle_deferrals deferrals in a
+// row, the container is updated anyway.
+func (u *Updater) awaitIdle(ctx context.Context, p pendingUpdate) bool {
+	cmd := u.containerLabels(p.r.Container, p.inspect.Config.Labels)[labelIdleCheck]
+	if cmd == "" || p.inspect.ContainerJSONBase == nil || p.inspect.State == nil || !p.inspect.State.Running {
+		return true
+	}
+
+	busy := idleCheck(ctx, u.client(), p.cont.ID, cmd)
+	deferrals, err := u.state.recordDeferral(p.r.Container, busy != nil)
+	if err != nil {
+		u.logger.Printf("Error saving state: %v", err)
+	}
+	if busy == nil {
+		return true
+	}
+	if max := u.Config().MaxIdleDeferrals; max > 0 && deferrals > max {
+		u.logger.Printf("Updating busy container %s anyway: its update was deferred %d times (%v)", p.r.Container, max, busy)
+		if _, err := u.state.recordDeferral(p.r.Container, false); err != nil {
+			u.logger.Printf("Error saving state: %v", err)
+		}
+		return true
+	}
+	u.logger.Printf("Deferring update of container %s to the next scan, it is busy: %v", p.r.Container, busy)
+	return false
+}
@@ -0,0 +1,45 @@
+package updater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestIdleCheckDefersUpdate(t *testing.T) {
+	cont := testContainer("worker")
+	inspect := namedInspect("worker", &container.HostConfig{})
+	inspect.State = &types.ContainerState{Running: true}
+	inspect.Config.Labels = map[string]string{labelIdleCheck: "test ! -e /tmp/busy"}
+	cli := &fakeClient{
+		inspect:       map[string]types.ContainerJSON{cont.ID: inspect},
+		execExitCodes: map[string]int{cont.ID: 1},
+	}
+	u := New(cli, Config{MaxIdleDeferrals: 2}, nil)
+
+	// Busy: deferred twice, then updated anyway
+	for i := 1; i <= 3; i++ {
+		r := u.updateContainer(context.Background(), nil, cont)
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if want := i == 3; r.Updated != want {
+			t.Fatalf("scan %d: got updated=%v, want %v", i, r.Updated, want)
+		}
+	}
+	if got := u.state.Containers["worker"].IdleDeferrals; got != 0 {
+		t.Errorf("got %d deferrals after the forced update, want them reset", got)
+	}
+
+	// Idle: updated right away
+	cli.execExitCodes[cont.ID] = 0
+	cli.calls = nil
+	if r := u.updateContainer(context.Background(), nil, cont); !r.Updated {
+		t.Errorf("idle container not updated: %v", r.Err)
+	}
+	if !containsName(cli.calls, "exec "+cont.ID) {
+		t.Error("idle check not run")
+	}
+}
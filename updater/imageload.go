@@ -0,0 +1,131 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imageTarballSuffixes are the files of image_load_dir that are loaded, as
+// written by `docker save`, optionally compressed.
+var imageTarballSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.xz"}
+
+// loadedTarballs remembers the modification time of every tarball of
+// image_load_dir loaded already, so each is loaded once per version.
+type loadedTarballs struct {
+	mu    sync.Mutex
+	files map[string]time.Time
+}
+
+func (l *loadedTarballs) isLoaded(path string, modTime time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	loaded, ok := l.files[path]
+	return ok && loaded.Equal(modTime)
+}
+
+func (l *loadedTarballs) markLoaded(path string, modTime time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.files == nil {
+		l.files = make(map[string]time.Time)
+	}
+	l.files[path] = modTime
+}
+
+// isImageTarball reports whether the file name is one of
+// imageTarballSuffixes.
+func isImageTarball(name string) bool {
+	for _, suffix := range imageTarballSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadImages loads the image tarballs in dir that are new or changed since
+// they were last loaded. Containers then pick up the loaded images like
+// with NoPull. A tarball that fails to load, e.g. because it is still being
+// copied, is tried again by the next scan.
+func (u *Updater) loadImages(ctx context.Context, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		u.logger.Printf("Error reading image_load_dir: %v", err)
+		return
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isImageTarball(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil || u.loadedTarballs.isLoaded(path, info.ModTime()) {
+			continue
+		}
+		images, err := u.loadImage(ctx, path)
+		if err != nil {
+			u.logger.Printf("Error loading image tarball %s: %v", path, err)
+			continue
+		}
+		u.loadedTarballs.markLoaded(path, info.ModTime())
+		u.logger.Printf("Loaded image tarball %s: %s", path, strings.Join(images, ", "))
+	}
+}
+
+// loadImage loads the image tarball at path and returns the images loaded,
+// as reported by the daemon.
+func (u *Updater) loadImage(ctx context.Context, path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	resp, err := u.client().ImageLoad(ctx, f, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !resp.JSON {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return nil, err
+	}
+
+	// The daemon reports progress and errors as a stream of JSON messages
+	var images []string
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err := dec.Decode(&msg); errors.Is(err, io.EOF) {
+			return images, nil
+		} else if err != nil {
+			return images, fmt.Errorf("error reading load progress: %w", err)
+		}
+		if msg.Error != "" {
+			return images, errors.New(msg.Error)
+		}
+		line := strings.TrimSpace(msg.Stream)
+		if image, ok := strings.CutPrefix(line, "Loaded image: "); ok {
+			images = append(images, image)
+		} else if id, ok := strings.CutPrefix(line, "Loaded image ID: "); ok {
+			images = append(images, ShortImageID(id))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package updater
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestLoadImages(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"web.tar": "web-v2", "notes.txt": "ignored"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cont := testContainer("web")
+	cont.ImageID = "sha256:old"
+	cli := &fakeClient{
+		containers: []types.Container{cont},
+		inspect:    map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})},
+		images:     map[string]types.ImageInspect{"web:latest": {ID: "sha256:new"}},
+		loadOutput: `{"stream":"Loaded image: web:latest\n"}`,
+	}
+	results, err := scanAll(cli, Config{ImageLoadDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsName(cli.calls, "load web-v2") || containsName(cli.calls, "load ignored") {
+		t.Errorf("got calls %v, want only web.tar loaded", cli.calls)
+	}
+	for _, call := range cli.calls {
+		if call == "pull web:latest" {
+			t.Error("image pulled with image_load_dir")
+		}
+	}
+	if len(results) != 1 || !results[0].Updated {
+		t.Errorf("got results %+v, want web recreated from the loaded image", results)
+	}
+
+	// Each tarball is loaded once, until it is replaced
+	u := New(cli, Config{ImageLoadDir: dir}, nil)
+	cli.calls = nil
+	u.loadImages(context.Background(), dir)
+	u.loadImages(context.Background(), dir)
+	if len(cli.calls) != 1 {
+		t.Errorf("got calls %v, want the tarball loaded once", cli.calls)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "web.tar"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	u.loadImages(context.Background(), dir)
+	if len(cli.calls) != 2 {
+		t.Errorf("got calls %v, want the replaced tarball loaded again", cli.calls)
+	}
+
+	// Failed loads are retried
+	cli.calls, cli.loadOutput = nil, `{"error":"unexpected EOF"}`
+	u = New(cli, Config{ImageLoadDir: dir}, nil)
+	u.loadImages(context.Background(), dir)
+	u.loadImages(context.Background(), dir)
+	if len(cli.calls) != 2 {
+		t.Errorf("got calls %v, want a failed load retried", cli.calls)
+	}
+}
@@ -0,0 +1,214 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+)
+
+// imageIDPattern matches a full or abbreviated image ID without its
+// algorithm prefix.
+var imageIDPattern = regexp.MustCompile(`^[0-9a-f]{12,64}$`)
+
+// isImageID reports whether ref is the ID of the image with the given full
+// ID rather than a repository reference.
+func isImageID(ref, id string) bool {
+	if strings.HasPrefix(ref, "sha256:") {
+		return true
+	}
+	return imageIDPattern.MatchString(ref) && strings.HasPrefix(strings.TrimPrefix(id, "sha256:"), ref)
+}
+
+// normalizeImageRef adds the implicit ":latest" tag to a reference without a
+// tag or digest, e.g. "myimage" becomes "myimage:latest".
+func normalizeImageRef(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %w", ref, err)
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named)), nil
+}
+
+// imageTag returns the tag of ref: "latest" if it has neither tag nor
+// digest, "" if it is only pinned by digest. ok is false if ref is no valid
+// reference.
+func imageTag(ref string) (tag string, ok bool) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", false
+	}
+	if tagged, isTagged := reference.TagNameOnly(named).(reference.Tagged); isTagged {
+		return tagged.Tag(), true
+	}
+	return "", true
+}
+
+// listedTag returns the tag of the image cont is listed with, or its
+// rollback label. ok is false if the tag is unknown because the container
+// is listed with an image ID.
+func listedTag(cont types.Container) (tag string, ok bool) {
+	ref := cont.Image
+	if label := cont.Labels[labelImage]; label != "" {
+		ref = label
+	} else if isImageID(ref, cont.ImageID) {
+		return "", false
+	}
+	return imageTag(ref)
+}
+
+// autoUpdateTag reports whether auto_update_tags allows updating a
+// container running an image tagged tag.
+func (c Config) autoUpdateTag(tag string) bool {
+	return len(c.AutoUpdateTags) == 0 || slices.Contains(c.AutoUpdateTags, tag)
+}
+
+// watchesImage reports whether ref refers to the same image as WatchImage,
+// e.g. "nginx" and "docker.io/library/nginx:latest".
+func (u *Updater) watchesImage(ref string) bool {
+	normalized, err := normalizeImageRef(ref)
+	if err != nil {
+		return false
+	}
+	watched, err := normalizeImageRef(u.WatchImage)
+	return err == nil && normalized == watched
+}
+
+// latestPolicy is how containers on the "latest" tag are treated.
+type latestPolicy string
+
+const (
+	latestUpdate latestPolicy = "update"
+	latestWarn   latestPolicy = "warn"
+	latestSkip   latestPolicy = "skip"
+)
+
+func parseLatestPolicy(s string) (latestPolicy, error) {
+	switch p := latestPolicy(s); p {
+	case latestUpdate, latestWarn, latestSkip:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown latest_policy %q", s)
+	}
+}
+
+// latestPolicy returns the configured latest_policy, defaulting to update.
+func (c Config) latestPolicy() latestPolicy {
+	if p, err := parseLatestPolicy(c.LatestPolicy); err == nil {
+		return p
+	}
+	return latestUpdate
+}
+
+// missingImage is what happens to a container whose image was removed
+// locally while it keeps running.
+type missingImage string
+
+const (
+	missingImageUpdate missingImage = "update"
+	missingImageSkip   missingImage = "skip"
+)
+
+func parseMissingImage(s string) (missingImage, error) {
+	switch m := missingImage(s); m {
+	case missingImageUpdate, missingImageSkip:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown missing_image %q", s)
+	}
+}
+
+// missingImage returns the configured missing_image, defaulting to update.
+func (c Config) missingImage() missingImage {
+	if m, err := parseMissingImage(c.MissingImage); err == nil {
+		return m
+	}
+	return missingImageUpdate
+}
+
+// imageRef returns the normalized reference to pull for cont. Docker lists a
+// container's image by ID once its tag has moved to another image, so an ID
+// is resolved back to the reference the container was created from (or, if
+// it was rolled back, the one recorded by the rollback), or failing that to
+// a tag of the image.
+func (u *Updater) imageRef(ctx context.Context, cont types.Container, inspectData types.ContainerJSON) (string, error) {
+	ref := cont.Image
+	if isImageID(ref, cont.ImageID) {
+		ref = ""
+		if inspectData.Config != nil && inspectData.Config.Labels[labelImage] != "" {
+			// Rolled back to its previous image
+			ref = inspectData.Config.Labels[labelImage]
+		} else if inspectData.Config != nil && inspectData.Config.Image != "" && !isImageID(inspectData.Config.Image, cont.ImageID) {
+			ref = inspectData.Config.Image
+		} else if img, _, err := u.client().ImageInspectWithRaw(ctx, cont.ImageID); err == nil && len(img.RepoTags) > 0 {
+			ref = img.RepoTags[0]
+		}
+		if ref == "" {
+			return "", fmt.Errorf("image %s has no tag to pull", ShortImageID(cont.ImageID))
+		}
+	}
+	return normalizeImageRef(ref)
+}
+
+// RegistryRewrite pulls images of registry From from registry To instead,
+// e.g. during a registry migration.
+type RegistryRewrite struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+func validateRegistryRewrites(rules []RegistryRewrite) []error {
+	var errs []error
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		for _, host := range []string{rule.From, rule.To} {
+			if host == "" || strings.Contains(host, "/") || !validRegistry(host) {
+				errs = append(errs, fmt.Errorf("registry_rewrite: invalid registry %q", host))
+			}
+		}
+		if seen[rule.From] {
+			errs = append(errs, fmt.Errorf("registry_rewrite has more than one rule for registry %q", rule.From))
+		}
+		seen[rule.From] = true
+	}
+	return errs
+}
+
+// validRegistry reports whether host is a registry host name, optionally
+// with a port.
+func validRegistry(host string) bool {
+	named, err := reference.ParseNormalizedNamed(host + "/image")
+	return err == nil && reference.Domain(named) == host
+}
+
+// rewriteRegistry returns ref with its registry replaced by the first rule
+// matching it. ok is false if no rule matches.
+func rewriteRegistry(rules []RegistryRewrite, ref string) (rewritten string, ok bool, err error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid image reference %q: %w", ref, err)
+	}
+	domain := reference.Domain(named)
+	for _, rule := range rules {
+		if rule.From != domain && !(domain == "docker.io" && rule.From == "index.docker.io") {
+			continue
+		}
+		rewritten = rule.To + "/" + reference.Path(named)
+		if tagged, ok := named.(reference.Tagged); ok {
+			rewritten += ":" + tagged.Tag()
+		}
+		if digested, ok := named.(reference.Digested); ok {
+			rewritten += "@" + digested.Digest().String()
+		}
+		named, err = reference.ParseNormalizedNamed(rewritten)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid rewritten image reference %q: %w", rewritten, err)
+		}
+		return reference.FamiliarString(named), true, nil
+	}
+	return ref, false, nil
+}
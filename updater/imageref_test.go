@@ -0,0 +1,133 @@
+package updater
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestNormalizeImageRef(t *testing.T) {
+	tests := map[string]string{
+		"myimage":                                   "myimage:latest",
+		"myimage:latest":                            "myimage:latest",
+		"library/nginx":                             "nginx:latest",
+		"ghcr.io/owner/app":                         "ghcr.io/owner/app:latest",
+		"registry:5000/app:1.2":                     "registry:5000/app:1.2",
+		"app@sha256:" + strings.Repeat("a", 64):     "app@sha256:" + strings.Repeat("a", 64),
+		"app:1.0@sha256:" + strings.Repeat("b", 64): "app:1.0@sha256:" + strings.Repeat("b", 64),
+	}
+	for in, want := range tests {
+		got, err := normalizeImageRef(in)
+		if err != nil {
+			t.Errorf("normalizeImageRef(%q): %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("normalizeImageRef(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestImageRefResolvesImageIDs(t *testing.T) {
+	id := "sha256:" + strings.Repeat("c", 64)
+	tagged := &fakeClient{images: map[string]types.ImageInspect{id: {ID: id, RepoTags: []string{"app:2.0"}}}}
+	untagged := &fakeClient{images: map[string]types.ImageInspect{id: {ID: id}}}
+	created := func(image string) types.ContainerJSON {
+		return types.ContainerJSON{Config: &container.Config{Image: image}}
+	}
+
+	tests := []struct {
+		name    string
+		cli     *fakeClient
+		image   string
+		inspect types.ContainerJSON
+		want    string
+	}{
+		{"tagless", untagged, "myimage", created("myimage"), "myimage:latest"},
+		{"full ID, created from a tag", untagged, id, created("app"), "app:latest"},
+		{"short ID, created from a tag", untagged, strings.Repeat("c", 64)[:12], created("app:1.0"), "app:1.0"},
+		{"ID, created by ID", tagged, id, created(id), "app:2.0"},
+		{"ID without tags", untagged, id, created(id), ""},
+	}
+	for _, tt := range tests {
+		u := New(tt.cli, Config{}, nil)
+		cont := types.Container{Image: tt.image, ImageID: id}
+		got, err := u.imageRef(context.Background(), cont, tt.inspect)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestUpdatePullsTaglessImageAsLatest(t *testing.T) {
+	cont := testContainer("web")
+	cont.Image = "myimage"
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})}}
+
+	if r := testUpdate(cli, Config{}, cont); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if cli.calls[0] != "pull myimage:latest" {
+		t.Errorf("got first call %q, want pull myimage:latest", cli.calls[0])
+	}
+	if got := cli.created[0].config.Image; got != "myimage:latest" {
+		t.Errorf("recreated with image %q, want myimage:latest", got)
+	}
+}
+
+func TestRewriteRegistry(t *testing.T) {
+	rules := []RegistryRewrite{
+		{From: "oldregistry.corp", To: "newregistry.corp"},
+		{From: "docker.io", To: "mirror.corp:5000"},
+	}
+	tests := []struct {
+		ref, want string
+		ok        bool
+	}{
+		{"oldregistry.corp/team/app:1.2", "newregistry.corp/team/app:1.2", true},
+		{"oldregistry.corp/app@sha256:" + strings.Repeat("a", 64), "newregistry.corp/app@sha256:" + strings.Repeat("a", 64), true},
+		{"nginx:latest", "mirror.corp:5000/library/nginx:latest", true},
+		{"ghcr.io/owner/app:1", "ghcr.io/owner/app:1", false},
+	}
+	for _, tt := range tests {
+		got, ok, err := rewriteRegistry(rules, tt.ref)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.ref, err)
+		}
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %s (%v), want %s (%v)", tt.ref, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	for _, rule := range []RegistryRewrite{{From: "", To: "newregistry.corp"}, {From: "oldregistry.corp", To: "newregistry.corp/path"}} {
+		if errs := validateRegistryRewrites([]RegistryRewrite{rule}); len(errs) == 0 {
+			t.Errorf("expected %+v to be invalid", rule)
+		}
+	}
+}
+
+func TestUpdateRewritesRegistry(t *testing.T) {
+	cont := testContainer("app")
+	cont.Image = "oldregistry.corp/team/app:1.2"
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("app", &container.HostConfig{})}}
+	cfg := Config{RegistryRewrite: []RegistryRewrite{{From: "oldregistry.corp", To: "newregistry.corp"}}}
+
+	if r := testUpdate(cli, cfg, cont); !r.Updated {
+		t.Fatalf("container not updated: %v", r.Err)
+	}
+	if !containsName(cli.calls, "pull newregistry.corp/team/app:1.2") {
+		t.Errorf("image not pulled from the new registry, got calls %v", cli.calls)
+	}
+	if got := cli.created[0].config.Image; got != "newregistry.corp/team/app:1.2" {
+		t.Errorf("recreated from %s, want the new registry", got)
+	}
+}
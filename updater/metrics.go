@@ -0,0 +1,185 @@
+package updater
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metric is a minimal Prometheus counter or gauge family. Series are keyed
+// by their rendered label set.
+type metric struct {
+	name, help, kind string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// collector is a metric family that can write itself.
+type collector interface {
+	write(w io.Writer)
+}
+
+var metrics []collector
+
+func newMetric(kind, name, help string) *metric {
+	m := &metric{name: name, help: help, kind: kind, values: make(map[string]float64)}
+	metrics = append(metrics, m)
+	return m
+}
+
+var (
+	updatesTotal = newMetric("counter", "hikup_updates_total",
+		"Containers successfully recreated.")
+	updateErrorsTotal = newMetric("counter", "hikup_update_errors_total",
+		"Failed container updates by the stage they failed in.")
+	containersVanishedTotal = newMetric("counter", "hikup_containers_vanished_total",
+		"Containers removed by someone else before they could be updated.")
+	forcedKillsTotal = newMetric("counter", "hikup_forced_kills_total",
+		"Containers killed because they did not stop within their stop timeout.")
+	buildInfo = newMetric("gauge", "hikup_build_info",
+		"Always 1, labeled with the version of the running hikup.")
+	imageUnresolvable = newMetric("gauge", "hikup_image_unresolvable",
+		"1 if the last pull of the container's image failed because the tag does not exist.")
+	phaseDuration = newHistogram("hikup_phase_duration_seconds",
+		"Time spent in each phase of container updates.",
+		[]float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600})
+)
+
+// labelKey renders name/value pairs as a Prometheus label set.
+func labelKey(labels ...string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// add increments the series identified by the label name/value pairs.
+func (m *metric) add(v float64, labels ...string) {
+	m.mu.Lock()
+	m.values[labelKey(labels...)] += v
+	m.mu.Unlock()
+}
+
+// set sets the series identified by the label name/value pairs.
+func (m *metric) set(v float64, labels ...string) {
+	m.mu.Lock()
+	m.values[labelKey(labels...)] = v
+	m.mu.Unlock()
+}
+
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %g\n", m.name, k, m.values[k])
+	}
+}
+
+// histogram is a minimal Prometheus histogram family. Series are keyed by
+// their rendered label set.
+type histogram struct {
+	name, help string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	h := &histogram{name: name, help: help, buckets: buckets, series: make(map[string]*histogramSeries)}
+	metrics = append(metrics, h)
+	return h
+}
+
+// observe records v in the series identified by the label name/value pairs.
+func (h *histogram) observe(v float64, labels ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelKey(labels...)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: labels[:len(labels):len(labels)], counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelKey(append(s.labels, "le", fmt.Sprint(le))...), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelKey(append(s.labels, "le", "+Inf")...), s.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n%s_count%s %d\n", h.name, k, s.sum, h.name, k, s.count)
+	}
+}
+
+// WriteMetrics writes all metrics in the Prometheus text format.
+func WriteMetrics(w io.Writer) {
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// SetBuildInfo labels the hikup_build_info metric with the build metadata of
+// the running program.
+func SetBuildInfo(version, commit, buildDate string) {
+	buildInfo.set(1, "version", version, "commit", commit, "build_date", buildDate)
+}
+
+// recordResult updates the metrics for the outcome of one container update.
+func recordResult(r Result) {
+	switch {
+	case r.Err == nil:
+		if r.Updated {
+			updatesTotal.add(1)
+		}
+		if r.Vanished {
+			containersVanishedTotal.add(1)
+		}
+	case r.Stage != "":
+		updateErrorsTotal.add(1, "stage", string(r.Stage))
+	default:
+		updateErrorsTotal.add(1, "stage", "unknown")
+	}
+}
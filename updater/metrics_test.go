@@ -0,0 +1,86 @@
+package updater
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+func TestRecordResultStageLabel(t *testing.T) {
+	err := failAt(StagePull, "error pulling image for container %s: %w", "abc", errors.New("registry down"))
+	recordResult(Result{Container: "web", ID: "abc"}.fail(err))
+
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	if !strings.Contains(buf.String(), `hikup_update_errors_total{stage="pull"} 1`) {
+		t.Errorf("pull failure not counted:\n%s", buf.String())
+	}
+}
+
+func TestImageUnresolvableGauge(t *testing.T) {
+	cont := testContainer("abandoned")
+	cont.Image = "gone/app:1.0"
+	cli := &fakeClient{
+		inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("abandoned", &container.HostConfig{})},
+		pullErr: errdefs.NotFound(errors.New("manifest unknown")),
+	}
+
+	if r := testUpdate(cli, Config{}, cont); r.Stage != StagePull {
+		t.Fatalf("got stage %q, want %q", r.Stage, StagePull)
+	}
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	if !strings.Contains(buf.String(), `hikup_image_unresolvable{container="abandoned"} 1`) {
+		t.Errorf("unresolvable image not reported:\n%s", buf.String())
+	}
+
+	cli.pullErr = nil
+	testUpdate(cli, Config{}, cont)
+	buf.Reset()
+	WriteMetrics(&buf)
+	if !strings.Contains(buf.String(), `hikup_image_unresolvable{container="abandoned"} 0`) {
+		t.Errorf("gauge not reset after a successful pull:\n%s", buf.String())
+	}
+}
+
+func TestHistogramWrite(t *testing.T) {
+	h := &histogram{name: "test_seconds", help: "Test.", buckets: []float64{1, 5}, series: make(map[string]*histogramSeries)}
+	h.observe(0.5, "phase", "pull")
+	h.observe(3, "phase", "pull")
+	h.observe(7, "phase", "pull")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	for _, want := range []string{
+		`test_seconds_bucket{phase="pull",le="1"} 1`,
+		`test_seconds_bucket{phase="pull",le="5"} 2`,
+		`test_seconds_bucket{phase="pull",le="+Inf"} 3`,
+		`test_seconds_sum{phase="pull"} 10.5`,
+		`test_seconds_count{phase="pull"} 3`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %s in:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestPhaseDurations(t *testing.T) {
+	cont := testContainer("timed")
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("timed", &container.HostConfig{})}}
+	if r := testUpdate(cli, Config{}, cont); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	for _, phase := range []string{"pull", "stop", "create", "start"} {
+		if !strings.Contains(buf.String(), `hikup_phase_duration_seconds_count{phase="`+phase+`"}`) {
+			t.Errorf("%s phase not timed:\n%s", phase, buf.String())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package updater
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Labels tracking the names given by name_template.
+const (
+	// labelBaseName is the name the container had before name_template
+	// first renamed it.
+	labelBaseName = "hikup.base-name"
+	// labelGeneration counts how often the container was recreated with
+	// name_template.
+	labelGeneration = "hikup.generation"
+)
+
+// baseName returns the name a container called name is configured and
+// tracked by: the name it had before name_template first renamed it, or
+// else name itself.
+func baseName(name string, labels map[string]string) string {
+	if base := labels[labelBaseName]; base != "" {
+		return base
+	}
+	return name
+}
+
+// nameTemplateData is the data name_template is executed with.
+type nameTemplateData struct {
+	// Name is the original name of the container.
+	Name string
+	// Generation is 1 for the first recreated container, then 2 and so on.
+	Generation int
+}
+
+func parseNameTemplate(text string) (*template.Template, error) {
+	return template.New("name_template").Option("missingkey=error").Parse(text)
+}
+
+// recreateName returns the name for the replacement of the inspected
+// container, and the labels to add to it. Without name_template, the name
+// stays the same.
+func (c Config) recreateName(inspectData types.ContainerJSON) (string, map[string]string, error) {
+	name := normalizeName(inspectData.Name)
+	if c.NameTemplate == "" {
+		return name, nil, nil
+	}
+	tmpl, err := parseNameTemplate(c.NameTemplate)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data := nameTemplateData{Name: name, Generation: 1}
+	if inspectData.Config != nil {
+		if base := inspectData.Config.Labels[labelBaseName]; base != "" {
+			data.Name = base
+		}
+		if gen, err := strconv.Atoi(inspectData.Config.Labels[labelGeneration]); err == nil {
+			data.Generation = gen + 1
+		}
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", nil, fmt.Errorf("error executing name_template: %w", err)
+	}
+	if b.Len() == 0 {
+		return "", nil, fmt.Errorf("name_template gave an empty name for container %s", name)
+	}
+	labels := map[string]string{
+		labelBaseName:   data.Name,
+		labelGeneration: strconv.Itoa(data.Generation),
+	}
+	return b.String(), labels, nil
+}
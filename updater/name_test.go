@@ -0,0 +1,77 @@
+package updater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestRecreateName(t *testing.T) {
+	tests := []struct {
+		template string
+		labels   map[string]string
+		want     string
+	}{
+		{"", nil, "web"},
+		{"{{.Name}}-v{{.Generation}}", nil, "web-v1"},
+		{"{{.Name}}-v{{.Generation}}", map[string]string{labelBaseName: "app", labelGeneration: "3"}, "app-v4"},
+	}
+	for _, tt := range tests {
+		inspect := namedInspect("web", &container.HostConfig{})
+		inspect.Config.Labels = tt.labels
+		name, _, err := Config{NameTemplate: tt.template}.recreateName(inspect)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.template, err)
+		}
+		if name != tt.want {
+			t.Errorf("%q with labels %v: got %q, want %q", tt.template, tt.labels, name, tt.want)
+		}
+	}
+}
+
+func TestNameTemplateRecreate(t *testing.T) {
+	cont := testContainer("web")
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{
+		cont.ID: namedInspect("web", &container.HostConfig{}),
+	}}
+
+	testUpdate(cli, Config{NameTemplate: "{{.Name}}-{{.Generation}}"}, cont)
+	if !containsName(cli.calls, "create web-1") {
+		t.Errorf("got calls %v, want container created as web-1", cli.calls)
+	}
+}
+
+func TestNameTemplateUpdatesRenamedContainer(t *testing.T) {
+	// web after its first update with name_template
+	cont := testContainer("web-1")
+	cont.Labels = map[string]string{labelBaseName: "web", labelGeneration: "1"}
+	inspect := namedInspect("web-1", &container.HostConfig{})
+	inspect.Config.Labels = cont.Labels
+	cli := &fakeClient{containers: []types.Container{cont}, inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+	u := New(cli, Config{NameTemplate: "{{.Name}}-{{.Generation}}", IncludeContainers: []string{"web"}}, nil)
+
+	results, err := u.ScanOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Updated || results[0].Container != "web" {
+		t.Fatalf("got results %+v, want web updated", results)
+	}
+	if !containsName(cli.calls, "create web-2") {
+		t.Errorf("got calls %v, want container created as web-2", cli.calls)
+	}
+
+	for _, name := range []string{"web", "web-1"} {
+		if _, err := u.UpdateContainer(context.Background(), name); err != nil {
+			t.Errorf("updating %s: %v", name, err)
+		}
+	}
+}
+
+func TestNameTemplateValidate(t *testing.T) {
+	if err := (Config{NameTemplate: "{{.Name"}).Validate(); err == nil {
+		t.Error("got no error for an invalid name_template")
+	}
+}
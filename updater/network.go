@@ -0,0 +1,70 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// primaryNetwork returns the network a container is created on: the one
+// named by its network mode, or else the first by name.
+func primaryNetwork(hostConfig *container.HostConfig, endpoints map[string]*network.EndpointSettings) string {
+	mode := string(hostConfig.NetworkMode)
+	if _, ok := endpoints[mode]; ok {
+		return mode
+	}
+	if mode == "default" {
+		if _, ok := endpoints["bridge"]; ok {
+			return "bridge"
+		}
+	}
+	names := make([]string, 0, len(endpoints))
+	for name := range endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// createContainer creates a container attached to the networks of
+// networkingConfig. Only the primary network is given at creation; the
+// others are connected before the container starts, each with its preserved
+// endpoint settings, so static addresses (IPAMConfig), e.g. on macvlan
+// networks, are reserved on every network, also by daemons before API 1.44
+// that only take one network at creation.
+func createContainer(ctx context.Context, cli DockerClient, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, name string) (container.CreateResponse, error) {
+	endpoints := networkingConfig.EndpointsConfig
+	primary := primaryNetwork(hostConfig, endpoints)
+	createNetworking := &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+	if primary != "" {
+		createNetworking.EndpointsConfig[primary] = endpoints[primary]
+	}
+
+	resp, err := cli.ContainerCreate(ctx, config, hostConfig, createNetworking, platform, name)
+	if err != nil {
+		return resp, err
+	}
+
+	others := make([]string, 0, len(endpoints))
+	for netName := range endpoints {
+		if netName != primary {
+			others = append(others, netName)
+		}
+	}
+	sort.Strings(others)
+	for _, netName := range others {
+		if err := cli.NetworkConnect(ctx, netName, resp.ID, endpoints[netName]); err != nil {
+			// Don't leave a half-configured container blocking the name
+			_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+			return resp, fmt.Errorf("error connecting to network %s: %w", netName, err)
+		}
+	}
+	return resp, nil
+}
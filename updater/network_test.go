@@ -0,0 +1,63 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestStaticIPMacvlan(t *testing.T) {
+	cont := testContainer("dns")
+	inspect := namedInspect("dns", &container.HostConfig{NetworkMode: "lan"})
+	inspect.NetworkSettings = &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+		"lan": {
+			IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "192.168.1.53"},
+			IPAddress:  "192.168.1.53",
+			MacAddress: "02:42:c0:a8:01:35",
+		},
+		"backend": {
+			IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "172.20.0.53", IPv6Address: "fd00::53"},
+			IPAddress:  "172.20.0.53",
+		},
+	}}
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+
+	if r := testUpdate(cli, Config{}, cont); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	endpoints := cli.created[0].networking.EndpointsConfig
+	lan, ok := endpoints["lan"]
+	if len(endpoints) != 1 || !ok {
+		t.Fatalf("got networks %v at creation, want only lan", endpoints)
+	}
+	if lan.IPAMConfig.IPv4Address != "192.168.1.53" || lan.MacAddress != "02:42:c0:a8:01:35" {
+		t.Errorf("got lan endpoint %+v, want its static IP and MAC address kept", lan)
+	}
+
+	if len(cli.connected) != 1 || cli.connected[0].network != "backend" {
+		t.Fatalf("got connections %+v, want backend connected", cli.connected)
+	}
+	backend := cli.connected[0].endpoint.IPAMConfig
+	if backend.IPv4Address != "172.20.0.53" || backend.IPv6Address != "fd00::53" {
+		t.Errorf("got backend IPAM config %+v, want the static addresses kept", backend)
+	}
+	if !containsName(cli.calls, "connect backend new-dns000000000000") {
+		t.Errorf("got calls %v", cli.calls)
+	}
+	// Connected before it starts, so the addresses are reserved right away
+	if indexOf(cli.calls, "connect backend new-dns000000000000") > indexOf(cli.calls, "start new-dns000000000000") {
+		t.Errorf("network connected after start: %v", cli.calls)
+	}
+}
+
+func indexOf(calls []string, call string) int {
+	for i, c := range calls {
+		if c == call {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// notification is the JSON document POSTed to every notify URL.
+type notification struct {
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	Container string `json:"container,omitempty"`
+	Stage     string `json:"stage,omitempty"`
+}
+
+const notifyTimeout = 10 * time.Second
+
+// notify sends n to all configured notify URLs. Errors are logged, never
+// returned: a broken notifier must not stop updates.
+func (u *Updater) notify(n notification) {
+	urls := u.Config().NotifyURLs
+
+	if len(urls) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		u.logger.Printf("Error encoding notification: %v", err)
+		return
+	}
+
+	for _, url := range urls {
+		if err := postNotification(url, body); err != nil {
+			u.logger.Printf("Error sending notification to %s: %v", url, err)
+		}
+	}
+}
+
+func postNotification(url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyFailure alerts about a failed update once the container has failed
+// failure_threshold scans in a row. It fires only when the threshold is
+// crossed, not again for every further failure.
+func (u *Updater) notifyFailure(r Result, consecutive int) {
+	threshold := u.Config().failureThreshold()
+
+	if consecutive != threshold {
+		return
+	}
+	n := notification{
+		Title:     fmt.Sprintf("hikup: updating %s failed", r.Container),
+		Message:   fmt.Sprintf("%v (%d consecutive failures)", r.Err, consecutive),
+		Container: r.Container,
+		Stage:     string(r.Stage),
+	}
+	if !u.holdForDigest(n, true) {
+		u.notify(n)
+	}
+}
+
+// notifyUpdate announces a successful update.
+func (u *Updater) notifyUpdate(r Result) {
+	n := notification{
+		Title:     fmt.Sprintf("hikup: updated %s", r.Container),
+		Message:   fmt.Sprintf("%s updated %s", r.Container, r.Change()),
+		Container: r.Container,
+	}
+	if !u.holdForDigest(n, false) {
+		u.notify(n)
+	}
+}
+
+// NotifyLifecycle sends a startup or shutdown notification if
+// notify_lifecycle is enabled. event is e.g. "started".
+func (u *Updater) NotifyLifecycle(event string) {
+	cfg := u.Config()
+	enabled := cfg.NotifyLifecycle
+	summary := cfg.Summary()
+
+	if !enabled {
+		return
+	}
+	host, _ := os.Hostname()
+	u.notify(notification{
+		Title:   fmt.Sprintf("hikup %s on %s", event, host),
+		Message: fmt.Sprintf("hikup %s %s on %s (%s)", u.Version, event, host, summary),
+	})
+}
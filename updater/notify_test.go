@@ -0,0 +1,107 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNotifyFailureThreshold(t *testing.T) {
+	var got []notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		got = append(got, n)
+	}))
+	defer srv.Close()
+
+	u := New(nil, Config{NotifyURLs: []string{srv.URL}, FailureThreshold: 3}, nil)
+
+	r := Result{Container: "web", Stage: StagePull, Err: errors.New("registry down")}
+	for consecutive := 1; consecutive <= 5; consecutive++ {
+		u.notifyFailure(r, consecutive)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("got %d notifications, want exactly 1 when crossing the threshold", len(got))
+	}
+	if got[0].Container != "web" || got[0].Stage != "pull" {
+		t.Errorf("unexpected notification %+v", got[0])
+	}
+}
+
+func TestNotifyLifecycle(t *testing.T) {
+	var got []notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		got = append(got, n)
+	}))
+	defer srv.Close()
+
+	u := New(nil, Config{NotifyURLs: []string{srv.URL}}, nil)
+	u.NotifyLifecycle("started")
+	if len(got) != 0 {
+		t.Fatalf("got %d notifications with notify_lifecycle off, want none", len(got))
+	}
+
+	u.SetConfig(Config{NotifyURLs: []string{srv.URL}, NotifyLifecycle: true, IncludeContainers: []string{"web"}})
+	u.NotifyLifecycle("started")
+	if len(got) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(got))
+	}
+	if want := "include_containers=[web], interval=1h0m0s"; !strings.Contains(got[0].Message, want) {
+		t.Errorf("message %q does not contain the config summary %q", got[0].Message, want)
+	}
+}
+
+func TestNotificationDigest(t *testing.T) {
+	var got []notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		got = append(got, n)
+	}))
+	defer srv.Close()
+
+	u := New(nil, Config{NotifyURLs: []string{srv.URL}, NotificationDigest: "0 8 * * *"}, nil)
+	u.notifyUpdate(Result{Container: "web", OldImage: "sha256:aaa", NewImage: "sha256:bbb"})
+	u.notifyFailure(Result{Container: "db", Stage: StagePull, Err: errors.New("registry down")}, 1)
+	if len(got) != 0 {
+		t.Fatalf("got %d notifications before the digest, want none", len(got))
+	}
+
+	u.SendDigest()
+	if len(got) != 1 {
+		t.Fatalf("got %d notifications, want one digest", len(got))
+	}
+	if !strings.Contains(got[0].Title, "1 updated, 1 failed") {
+		t.Errorf("unexpected digest title %q", got[0].Title)
+	}
+	if lines := strings.Split(got[0].Message, "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "db failed in stage pull: registry down") {
+		t.Errorf("unexpected digest message %q", got[0].Message)
+	}
+	u.SendDigest()
+	if len(got) != 1 {
+		t.Errorf("empty digest sent")
+	}
+
+	u.SetConfig(Config{NotifyURLs: []string{srv.URL}, NotificationDigest: "0 8 * * *", NotifyFailuresImmediately: true})
+	u.notifyFailure(Result{Container: "db", Stage: StagePull, Err: errors.New("registry down")}, 1)
+	if len(got) != 2 || got[1].Container != "db" {
+		t.Errorf("failure not sent right away with notify_failures_immediately, got %+v", got)
+	}
+
+	if err := (Config{NotificationDigest: "every morning"}).Validate(); err == nil {
+		t.Error("invalid notification_digest accepted")
+	}
+}
@@ -0,0 +1,87 @@
+package updater
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// podmanClient adapts hikup to the differences of Podman's
+// Docker-compatible API.
+type podmanClient struct {
+	DockerClient
+}
+
+// PodmanCompat wraps cli, a client of Podman's Docker-compatible API:
+//   - The infra containers of pods are not listed; Podman manages them.
+//   - Containers are created without the addresses their predecessors were
+//     assigned dynamically, which Podman would take as static addresses.
+//   - Removing a container started with --rm that Podman is removing
+//     already after it was stopped succeeds once it is gone.
+func PodmanCompat(cli DockerClient) DockerClient {
+	return &podmanClient{DockerClient: cli}
+}
+
+// isPodInfra reports whether cont is the infra container holding the
+// namespaces of a Podman pod.
+func isPodInfra(cont types.Container) bool {
+	image := cont.Image
+	if i := strings.LastIndexByte(image, ':'); i > strings.LastIndexByte(image, '/') {
+		image = image[:i]
+	}
+	return strings.HasSuffix(image, "/podman-pause") || strings.HasSuffix(image, "/pause")
+}
+
+func (c *podmanClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	containers, err := c.DockerClient.ContainerList(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	filtered := containers[:0]
+	for _, cont := range containers {
+		if !isPodInfra(cont) {
+			filtered = append(filtered, cont)
+		}
+	}
+	return filtered, nil
+}
+
+func (c *podmanClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	if networkingConfig != nil {
+		for _, endpoint := range networkingConfig.EndpointsConfig {
+			withoutDynamicAddresses(endpoint)
+		}
+	}
+	return c.DockerClient.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, containerName)
+}
+
+func (c *podmanClient) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	withoutDynamicAddresses(config)
+	return c.DockerClient.NetworkConnect(ctx, networkID, containerID, config)
+}
+
+// withoutDynamicAddresses clears the addresses of endpoint that were assigned
+// dynamically. Static addresses are kept in IPAMConfig.
+func withoutDynamicAddresses(endpoint *network.EndpointSettings) {
+	if endpoint == nil {
+		return
+	}
+	endpoint.IPAddress, endpoint.GlobalIPv6Address = "", ""
+	endpoint.NetworkID, endpoint.EndpointID = "", ""
+}
+
+func (c *podmanClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	err := c.DockerClient.ContainerRemove(ctx, containerID, options)
+	if err == nil || errdefs.IsNotFound(err) {
+		return err
+	}
+	if _, inspectErr := c.DockerClient.ContainerInspect(ctx, containerID); errdefs.IsNotFound(inspectErr) {
+		return nil
+	}
+	return err
+}
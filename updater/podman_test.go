@@ -0,0 +1,40 @@
+package updater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestPodmanCompat(t *testing.T) {
+	web, infra := testContainer("web"), testContainer("8c5a2b7e1f3d-infra")
+	infra.Image = "localhost/podman-pause:4.9.3-1708357294"
+	inspect := namedInspect("web", &container.HostConfig{})
+	inspect.NetworkSettings = &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+		"podman": {IPAddress: "10.88.0.5", NetworkID: "abc", IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "10.88.0.9"}},
+	}}
+	fake := &fakeClient{
+		containers: []types.Container{web, infra},
+		inspect:    map[string]types.ContainerJSON{web.ID: inspect},
+	}
+	cli := PodmanCompat(fake)
+
+	containers, err := cli.ContainerList(context.Background(), container.ListOptions{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || containerName(containers[0]) != "web" {
+		t.Fatalf("got containers %v, want the pod infra container hidden", containers)
+	}
+
+	if r := testUpdate(cli, Config{}, web); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	endpoint := fake.created[0].networking.EndpointsConfig["podman"]
+	if endpoint.IPAddress != "" || endpoint.NetworkID != "" || endpoint.IPAMConfig.IPv4Address != "10.88.0.9" {
+		t.Errorf("got endpoint %+v, want only the static address kept", endpoint)
+	}
+}
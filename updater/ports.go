@@ -0,0 +1,116 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+// portMismatch is what happens when a new image no longer exposes a port the
+// container publishes.
+type portMismatch string
+
+const (
+	portMismatchWarn   portMismatch = "warn"
+	portMismatchFail   portMismatch = "fail"
+	portMismatchIgnore portMismatch = "ignore"
+)
+
+func parsePortMismatch(s string) (portMismatch, error) {
+	switch m := portMismatch(s); m {
+	case portMismatchWarn, portMismatchFail, portMismatchIgnore:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown port_mismatch %q", s)
+	}
+}
+
+// portMismatch returns the configured port_mismatch, defaulting to warn.
+func (c Config) portMismatch() portMismatch {
+	if m, err := parsePortMismatch(c.PortMismatch); err == nil {
+		return m
+	}
+	return portMismatchWarn
+}
+
+// removedPorts returns the published ports of a container that its old
+// image exposed but the new one does not, sorted. Such a port usually moved
+// upstream, so the container would start but not be reachable. Ports the old
+// image did not expose either are published on purpose and not reported.
+func removedPorts(oldImage, newImage types.ImageInspect, bindings nat.PortMap) []string {
+	if oldImage.Config == nil {
+		return nil
+	}
+	var removed []string
+	for port := range bindings {
+		if _, ok := oldImage.Config.ExposedPorts[port]; !ok {
+			continue
+		}
+		if newImage.Config != nil {
+			if _, ok := newImage.Config.ExposedPorts[port]; ok {
+				continue
+			}
+		}
+		removed = append(removed, string(port))
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// unspecifiedHostIP reports whether ip binds all addresses of the host.
+func unspecifiedHostIP(ip string) bool {
+	return ip == "" || ip == "0.0.0.0" || ip == "::"
+}
+
+// portConflict returns the host port of bindings that another running
+// container already publishes, and that container's name, or "" if there is
+// none. Docker would only report it when starting the replacement, after the
+// old container is gone. Ephemeral and ranged host ports are left to Docker.
+func portConflict(bindings nat.PortMap, id string, running []types.Container) (port, owner string) {
+	for containerPort, hostBindings := range bindings {
+		for _, b := range hostBindings {
+			hostPort, err := strconv.ParseUint(b.HostPort, 10, 16)
+			if err != nil || hostPort == 0 {
+				continue
+			}
+			for _, other := range running {
+				if other.ID == id {
+					continue
+				}
+				for _, p := range other.Ports {
+					if uint64(p.PublicPort) != hostPort || p.Type != containerPort.Proto() {
+						continue
+					}
+					if b.HostIP == p.IP || unspecifiedHostIP(b.HostIP) || unspecifiedHostIP(p.IP) {
+						return fmt.Sprintf("%s/%s", b.HostPort, containerPort.Proto()), containerName(other)
+					}
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// checkPortConflicts fails if a host port the container with the given ID
+// publishes is taken by another running container, so it is not removed
+// only for its replacement to fail to start. The check is skipped if the
+// running containers cannot be listed.
+func (u *Updater) checkPortConflicts(ctx context.Context, id string, bindings nat.PortMap) error {
+	if len(bindings) == 0 {
+		return nil
+	}
+	running, err := u.client().ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		u.debugf("Not checking host ports of container %s: %v", id[:12], err)
+		return nil
+	}
+	if port, owner := portConflict(bindings, id, running); port != "" {
+		return fmt.Errorf("host port %s is already published by container %s", port, owner)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package updater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestRemovedPorts(t *testing.T) {
+	oldImage := types.ImageInspect{Config: &container.Config{ExposedPorts: nat.PortSet{"80/tcp": {}, "443/tcp": {}}}}
+	newImage := types.ImageInspect{Config: &container.Config{ExposedPorts: nat.PortSet{"8080/tcp": {}, "443/tcp": {}}}}
+	bindings := nat.PortMap{
+		"80/tcp":   {{HostPort: "80"}},
+		"443/tcp":  {{HostPort: "443"}},
+		"9000/tcp": {{HostPort: "9000"}}, // never exposed
+	}
+
+	if got, want := removedPorts(oldImage, newImage, bindings), []string{"80/tcp"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got removed ports %v, want %v", got, want)
+	}
+	if got := removedPorts(types.ImageInspect{}, newImage, bindings); got != nil {
+		t.Errorf("got removed ports %v with the old image unknown, want none", got)
+	}
+}
+
+func TestPortMismatchFail(t *testing.T) {
+	cont := testContainer("web")
+	cont.ImageID = "sha256:old"
+	inspect := namedInspect("web", &container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: "80"}}}})
+	inspect.Image = "sha256:old"
+	cli := &fakeClient{
+		inspect: map[string]types.ContainerJSON{cont.ID: inspect},
+		images: map[string]types.ImageInspect{
+			"sha256:old": {ID: "sha256:old", Config: &container.Config{ExposedPorts: nat.PortSet{"80/tcp": {}}}},
+			"web:latest": {ID: "sha256:new", Config: &container.Config{ExposedPorts: nat.PortSet{"8080/tcp": {}}}},
+		},
+	}
+
+	if r := testUpdate(cli, Config{}, cont); r.Err != nil || !r.Updated {
+		t.Errorf("got %+v, want a warning only by default", r)
+	}
+	cli.calls = nil
+	if r := testUpdate(cli, Config{PortMismatch: "fail"}, cont); r.Stage != StageVerify {
+		t.Errorf("got stage %q, want %q", r.Stage, StageVerify)
+	}
+	if containsName(cli.calls, "create web") {
+		t.Error("container recreated despite port_mismatch: fail")
+	}
+}
+
+func TestPortConflict(t *testing.T) {
+	bindings := nat.PortMap{
+		"80/tcp":  {{HostPort: "8080"}},
+		"53/udp":  {{HostIP: "127.0.0.1", HostPort: "5353"}},
+		"443/tcp": {{HostPort: ""}}, // ephemeral
+	}
+	other := func(ports ...types.Port) types.Container {
+		c := testContainer("other")
+		c.Ports = ports
+		return c
+	}
+	self := testContainer("web")
+	self.Ports = []types.Port{{IP: "0.0.0.0", PrivatePort: 80, PublicPort: 8080, Type: "tcp"}}
+
+	tests := []struct {
+		running []types.Container
+		want    string
+	}{
+		{[]types.Container{self}, ""},
+		{[]types.Container{other(types.Port{IP: "0.0.0.0", PublicPort: 8080, Type: "tcp"})}, "8080/tcp"},
+		{[]types.Container{other(types.Port{IP: "0.0.0.0", PublicPort: 8080, Type: "udp"})}, ""},
+		{[]types.Container{other(types.Port{IP: "127.0.0.1", PublicPort: 5353, Type: "udp"})}, "5353/udp"},
+		{[]types.Container{other(types.Port{IP: "192.168.1.2", PublicPort: 5353, Type: "udp"})}, ""},
+		{[]types.Container{other(types.Port{IP: "0.0.0.0", PublicPort: 443, Type: "tcp"})}, ""},
+	}
+	for i, tt := range tests {
+		if port, _ := portConflict(bindings, self.ID, tt.running); port != tt.want {
+			t.Errorf("case %d: got conflict %q, want %q", i, port, tt.want)
+		}
+	}
+}
+
+func TestPortConflictFailsBeforeStop(t *testing.T) {
+	cont := testContainer("web")
+	other := testContainer("other")
+	other.Ports = []types.Port{{IP: "0.0.0.0", PrivatePort: 80, PublicPort: 80, Type: "tcp"}}
+	cli := &fakeClient{
+		containers: []types.Container{cont, other},
+		inspect:    map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: "80"}}}})},
+	}
+
+	r := testUpdate(cli, Config{}, cont)
+	if r.Err == nil || r.Updated {
+		t.Fatalf("got %+v, want a port conflict", r)
+	}
+	if containsName(cli.calls, "stop "+cont.ID) {
+		t.Errorf("container stopped despite the port conflict, calls %v", cli.calls)
+	}
+}
@@ -0,0 +1,45 @@
+package updater
+
+import "os"
+
+// Labels recording who recreated a container and why, with
+// provenance_labels.
+const (
+	labelUpdatedBy = "hikup.updated-by"
+	labelTrigger   = "hikup.trigger"
+)
+
+// Triggers of an update, as recorded in the hikup.trigger label.
+const (
+	// triggerScheduled is a regular scan.
+	triggerScheduled = "scheduled"
+	// triggerManual is an update requested through the HTTP API or web UI.
+	triggerManual = "manual"
+	// triggerWebhook is an update after a registry reported a push.
+	triggerWebhook = "webhook"
+	// triggerRollback is a rollback, automatic or with --rollback-last.
+	triggerRollback = "rollback"
+)
+
+// instanceName returns the name this instance records in the
+// hikup.updated-by label: instance_name, or else the host name.
+func (c Config) instanceName() string {
+	if c.InstanceName != "" {
+		return c.InstanceName
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// setProvenance records in labels of a container being created which
+// instance updates it and why, if provenance_labels is enabled.
+func (c Config) setProvenance(labels map[string]string, trigger string) {
+	if !c.ProvenanceLabels {
+		return
+	}
+	labels[labelUpdatedBy] = c.instanceName()
+	labels[labelTrigger] = trigger
+}
@@ -0,0 +1,43 @@
+package updater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestProvenanceLabels(t *testing.T) {
+	cont := testContainer("web")
+	newCli := func() *fakeClient {
+		return &fakeClient{
+			containers: []types.Container{cont},
+			inspect:    map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})},
+		}
+	}
+	cfg := Config{ProvenanceLabels: true, InstanceName: "edge-1", IncludeContainers: []string{"web"}}
+
+	cli := newCli()
+	if _, err := New(cli, cfg, nil).UpdateContainer(context.Background(), "web"); err != nil {
+		t.Fatal(err)
+	}
+	labels := cli.created[0].config.Labels
+	if labels[labelUpdatedBy] != "edge-1" || labels[labelTrigger] != triggerManual {
+		t.Errorf("got %s=%q %s=%q, want edge-1 and %s", labelUpdatedBy, labels[labelUpdatedBy], labelTrigger, labels[labelTrigger], triggerManual)
+	}
+
+	cli = newCli()
+	if _, err := New(cli, cfg, nil).ScanOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := cli.created[0].config.Labels[labelTrigger]; got != triggerScheduled {
+		t.Errorf("got trigger %q for a scan, want %q", got, triggerScheduled)
+	}
+
+	cli = newCli()
+	testUpdate(cli, Config{}, cont)
+	if _, ok := cli.created[0].config.Labels[labelUpdatedBy]; ok {
+		t.Error("provenance recorded without provenance_labels")
+	}
+}
@@ -0,0 +1,63 @@
+package updater
+
+import (
+	"context"
+	"sync"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+// pullGroup lets only one pull of an image run at a time. Callers asking
+// for an image that is being pulled wait for that pull and share its
+// outcome instead of pulling it again, e.g. when a webhook and a scan
+// update containers of the same image at once.
+type pullGroup struct {
+	mu    sync.Mutex
+	calls map[string]*pullCall
+}
+
+type pullCall struct {
+	done chan struct{}
+	err  error
+}
+
+// do runs pull for key unless a pull of key is already running, in which
+// case it waits for that one. shared reports whether the outcome is
+// another caller's.
+func (g *pullGroup) do(key string, pull func() error) (err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*pullCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.err, true
+	}
+	c := &pullCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.err = pull()
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+	return c.err, false
+}
+
+// pull pulls ref once per scan: containers sharing an image reuse the
+// outcome of its first pull in cycle, and concurrent pulls of the same
+// image across scans and API requests are merged. cached reports whether
+// no pull was made for this call.
+func (u *Updater) pull(ctx context.Context, cycle *scanCycle, ref string, options image.PullOptions) (cached bool, err error) {
+	key := ref + "@" + options.Platform
+	if err, ok := cycle.pulled(key); ok {
+		return true, err
+	}
+	err, shared := u.pulls.do(key, func() error {
+		return pullImage(ctx, u.client(), ref, options)
+	})
+	cycle.setPulled(key, err)
+	return shared, err
+}
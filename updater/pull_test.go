@@ -0,0 +1,86 @@
+package updater
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestScanPullsSharedImageOnce(t *testing.T) {
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{}}
+	for _, name := range []string{"a", "b"} {
+		cont := testContainer(name)
+		cont.Image = "app:latest"
+		cli.containers = append(cli.containers, cont)
+		cli.inspect[cont.ID] = namedInspect(name, &container.HostConfig{})
+	}
+
+	results, err := scanAll(cli, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].Updated || !results[1].Updated {
+		t.Fatalf("got results %+v, want both containers updated", results)
+	}
+	pulls := 0
+	for _, call := range cli.calls {
+		if call == "pull app:latest" {
+			pulls++
+		}
+	}
+	if pulls != 1 {
+		t.Errorf("pulled app:latest %d times, want once", pulls)
+	}
+}
+
+func TestPullGroupMergesConcurrentPulls(t *testing.T) {
+	var g pullGroup
+	started, release := make(chan struct{}), make(chan struct{})
+	errPull := errors.New("pull failed")
+	var pulls atomic.Int32
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err, shared := g.do("app:latest@", func() error {
+			pulls.Add(1)
+			close(started)
+			<-release
+			return errPull
+		})
+		if err != errPull || shared {
+			t.Errorf("first pull: got err=%v shared=%v", err, shared)
+		}
+	}()
+	<-started
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err, shared := g.do("app:latest@", func() error {
+			pulls.Add(1)
+			return nil
+		})
+		if err != errPull || !shared {
+			t.Errorf("waiting pull: got err=%v shared=%v, want the first pull's error", err, shared)
+		}
+	}()
+	// Another image is not held up
+	if err, shared := g.do("db:latest@", func() error { return nil }); err != nil || shared {
+		t.Errorf("other image: got err=%v shared=%v", err, shared)
+	}
+
+	// Give the second caller time to start waiting
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := pulls.Load(); n != 1 {
+		t.Errorf("image pulled %d times, want once", n)
+	}
+}
@@ -0,0 +1,40 @@
+package updater
+
+import "fmt"
+
+// labelPullPolicy overrides the pull_policy of a container.
+const labelPullPolicy = "hikup.pull-policy"
+
+// pullPolicy is when the image of a container is pulled, like the
+// imagePullPolicy of Kubernetes.
+type pullPolicy string
+
+const (
+	// pullAlways pulls the image on every scan.
+	pullAlways pullPolicy = "always"
+	// pullIfNotPresent only pulls the image if its tag is missing locally.
+	pullIfNotPresent pullPolicy = "if-not-present"
+	// pullNever never pulls, like --no-pull for a single container.
+	pullNever pullPolicy = "never"
+)
+
+func parsePullPolicy(s string) (pullPolicy, error) {
+	switch p := pullPolicy(s); p {
+	case pullAlways, pullIfNotPresent, pullNever:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown pull policy %q", s)
+	}
+}
+
+// containerPullPolicy returns the pull policy selected by the
+// hikup.pull-policy label, defaulting to pull_policy and then to always.
+func (c Config) containerPullPolicy(labels map[string]string) (pullPolicy, error) {
+	if s := labels[labelPullPolicy]; s != "" {
+		return parsePullPolicy(s)
+	}
+	if c.PullPolicy != "" {
+		return parsePullPolicy(c.PullPolicy)
+	}
+	return pullAlways, nil
+}
@@ -0,0 +1,47 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestPullPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		global  string
+		label   string
+		missing bool
+		want    bool
+	}{
+		{"default", "", "", false, true},
+		{"never", "never", "", false, false},
+		{"label overrides", "never", "always", false, true},
+		{"if-not-present, present", "", "if-not-present", false, false},
+		{"if-not-present, missing", "", "if-not-present", true, true},
+	}
+	for _, tt := range tests {
+		cont := testContainer("web")
+		inspect := namedInspect("web", &container.HostConfig{})
+		if tt.label != "" {
+			inspect.Config.Labels = map[string]string{labelPullPolicy: tt.label}
+		}
+		cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+		if tt.missing {
+			// Missing until pulled
+			cli.missingImages = map[string]bool{"web:latest": true}
+		}
+
+		testUpdate(cli, Config{PullPolicy: tt.global}, cont)
+		if pulled := containsName(cli.calls, "pull web:latest"); pulled != tt.want {
+			t.Errorf("%s: got pulled %v, want %v", tt.name, pulled, tt.want)
+		}
+	}
+}
+
+func TestPullPolicyValidate(t *testing.T) {
+	if err := (Config{PullPolicy: "sometimes"}).Validate(); err == nil {
+		t.Error("got no error for an unknown pull_policy")
+	}
+}
@@ -0,0 +1,104 @@
+package updater
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// registryFixture serves the manifest of app:latest with the given digest
+// behind anonymous token authentication.
+func registryFixture(t *testing.T, digest string) (*httptest.Server, string) {
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if got := r.URL.Query().Get("scope"); got != "repository:app:pull" {
+				t.Errorf("got token scope %q", got)
+			}
+			w.Write([]byte(`{"token": "secret"}`))
+		case r.Method != http.MethodHead || r.URL.Path != "/v2/app/manifests/latest":
+			http.NotFound(w, r)
+		case r.Header.Get("Authorization") != "Bearer secret":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="test",scope="repository:app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Header().Set("Docker-Content-Digest", digest)
+		}
+	}))
+	return srv, strings.TrimPrefix(srv.URL, "https://") + "/app:latest"
+}
+
+func TestRemoteDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("d", 64)
+	srv, ref := registryFixture(t, digest)
+	defer srv.Close()
+
+	got, err := remoteDigest(context.Background(), srv.Client(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != digest {
+		t.Errorf("got digest %q, want %q", got, digest)
+	}
+}
+
+func TestRegistryHeadCheckSkipsUpToDateContainers(t *testing.T) {
+	current := "sha256:" + strings.Repeat("d", 64)
+	srv, ref := registryFixture(t, current)
+	defer srv.Close()
+
+	cont := testContainer("web")
+	cont.Image, cont.ImageID = ref, "sha256:old"
+	inspect := namedInspect("web", &container.HostConfig{})
+	inspect.Image = "sha256:old"
+	repo := strings.TrimSuffix(ref, ":latest")
+	cli := &fakeClient{
+		inspect: map[string]types.ContainerJSON{cont.ID: inspect},
+		images:  map[string]types.ImageInspect{"sha256:old": {ID: "sha256:old", RepoDigests: []string{repo + "@" + current}}},
+	}
+
+	u := New(cli, Config{RegistryHeadCheck: true}, nil)
+	u.registry = srv.Client()
+	if r := u.updateContainer(context.Background(), nil, cont); r.Err != nil || r.Updated {
+		t.Errorf("got updated=%v err=%v, want an up to date container left alone", r.Updated, r.Err)
+	}
+	if len(cli.calls) != 0 {
+		t.Errorf("got calls %v, want none", cli.calls)
+	}
+
+	// A new digest upstream: pull and recreate as usual
+	cli.images["sha256:old"] = types.ImageInspect{ID: "sha256:old", RepoDigests: []string{repo + "@sha256:" + strings.Repeat("0", 64)}}
+	if r := u.updateContainer(context.Background(), nil, cont); r.Err != nil || !r.Updated {
+		t.Errorf("got updated=%v err=%v, want an update", r.Updated, r.Err)
+	}
+	if want := "pull " + ref; len(cli.calls) == 0 || cli.calls[0] != want {
+		t.Errorf("got calls %v, want %q first", cli.calls, want)
+	}
+}
+
+func TestRegistryHeadCheckFallsBackToPull(t *testing.T) {
+	cont := testContainer("web")
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})}}
+
+	u := New(cli, Config{RegistryHeadCheck: true}, nil)
+	u.registry = &http.Client{Transport: failingTransport{}}
+	if r := u.updateContainer(context.Background(), nil, cont); r.Err != nil || !r.Updated {
+		t.Errorf("got updated=%v err=%v, want the update to go ahead", r.Updated, r.Err)
+	}
+	if want := []string{"pull web:latest"}; !reflect.DeepEqual(cli.calls[:1], want) {
+		t.Errorf("got calls %v, want a pull first", cli.calls)
+	}
+}
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, context.DeadlineExceeded
+}
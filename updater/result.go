@@ -0,0 +1,119 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Stage names the step of an update a failure happened in.
+type Stage string
+
+const (
+	StageInspect Stage = "inspect"
+	StagePull    Stage = "pull"
+	StageStop    Stage = "stop"
+	StageRemove  Stage = "remove"
+	StageCreate  Stage = "create"
+	StageStart   Stage = "start"
+	StageHealth  Stage = "health"
+	// StageVerify is a new image failing a check before it is deployed,
+	// such as its signature verification.
+	StageVerify Stage = "verify"
+	// StageRestartLoop is a new container restarting too often after its
+	// update.
+	StageRestartLoop Stage = "restart-loop"
+)
+
+// stageError tags an update error with the stage it happened in.
+type stageError struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *stageError) Error() string { return e.Err.Error() }
+func (e *stageError) Unwrap() error { return e.Err }
+
+// failAt formats an error like fmt.Errorf and tags it with stage.
+func failAt(stage Stage, format string, args ...interface{}) error {
+	return &stageError{Stage: stage, Err: fmt.Errorf(format, args...)}
+}
+
+// errorStage returns the stage err was tagged with, or "" if it was not.
+func errorStage(err error) Stage {
+	var se *stageError
+	if errors.As(err, &se) {
+		return se.Stage
+	}
+	return ""
+}
+
+// Result is the outcome of updating a single container. It is shared
+// by the --once summary, metrics and logging.
+type Result struct {
+	Container string
+	ID        string
+	// Updated is set if the container was recreated.
+	Updated bool
+	// Vanished is set if the container was removed by someone else before
+	// it could be updated. It is not a failure.
+	Vanished bool
+	// OldImage and NewImage are the IDs of the image the container ran
+	// and of the image it was (or would have been) recreated from.
+	OldImage, NewImage string
+	// OldVersion and NewVersion are the org.opencontainers.image.version
+	// labels of those images, if they have one.
+	OldVersion, NewVersion string
+	// Stage is the step that failed; empty on success.
+	Stage Stage
+	Err   error
+}
+
+// Change describes the update for humans, e.g. "from v1.2 to v1.3". Image
+// IDs stand in for missing version labels.
+func (r Result) Change() string {
+	from, to := r.OldVersion, r.NewVersion
+	if from == "" || to == "" || from == to {
+		from, to = ShortImageID(r.OldImage), ShortImageID(r.NewImage)
+	}
+	return fmt.Sprintf("from %s to %s", from, to)
+}
+
+// ShortImageID abbreviates an image ID like "sha256:0123…" to 12 hex digits.
+func ShortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// fail returns r marked as failed with err.
+func (r Result) fail(err error) Result {
+	r.Err = err
+	r.Stage = errorStage(err)
+	return r
+}
+
+// Vanished returns the names of the containers that vanished before they
+// could be updated.
+func Vanished(results []Result) []string {
+	var names []string
+	for _, r := range results {
+		if r.Vanished {
+			names = append(names, r.Container)
+		}
+	}
+	return names
+}
+
+// Failures returns the errors of the failed results.
+func Failures(results []Result) []error {
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,135 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+// labelImage records the image reference a rolled back container was
+// created from, since it runs its previous image by ID.
+const labelImage = "hikup.image"
+
+// rollback replaces the new container newID of a failed update with one
+// running the image the container ran before. The rolled back container
+// remembers its image reference, so later scans update it again.
+func (u *Updater) rollback(ctx context.Context, cycle *scanCycle, p pendingUpdate, newID string) {
+	name := normalizeName(p.inspect.Name)
+	if err := u.client().ContainerRemove(ctx, newID, container.RemoveOptions{Force: true}); err != nil {
+		u.logger.Printf("Rollback of container %s failed: error removing new container: %v", name, err)
+		return
+	}
+
+	config, hostConfig, networkingConfig := recreateConfig(p.inspect, p.r.OldImage)
+	config.Labels[labelImage] = p.cont.Image
+	u.Config().setProvenance(config.Labels, triggerRollback)
+	cycle.resolveReferences(hostConfig)
+	resp, err := createContainer(ctx, u.client(), config, hostConfig, networkingConfig, p.platform, name)
+	if err != nil {
+		u.logger.Printf("Rollback of container %s failed: %v", name, err)
+		return
+	}
+	if err := u.client().ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		u.logger.Printf("Rollback of container %s failed: error starting it: %v", name, err)
+		return
+	}
+	u.logger.Printf("Rolled back container %s to image %s", name, ShortImageID(p.r.OldImage))
+}
+
+// RollbackLast recreates the containers updated by the most recent scan in
+// the update history from the images they ran before. Containers updated
+// again or removed since are skipped and fail respectively. Like after an
+// automatic rollback, later scans update the containers again unless they
+// are excluded.
+func (u *Updater) RollbackLast(ctx context.Context) ([]Result, error) {
+	entries, err := u.history.entries("")
+	if err != nil {
+		return nil, err
+	}
+	var last time.Time
+	for _, e := range entries {
+		if e.Cycle.After(last) {
+			last = e.Cycle
+		}
+	}
+	if last.IsZero() {
+		return nil, errors.New("no updates recorded in the history")
+	}
+
+	containers, err := u.client().ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+	byName := make(map[string]types.Container, len(containers))
+	for _, cont := range containers {
+		byName[baseName(containerName(cont), cont.Labels)] = cont
+	}
+
+	u.logger.Printf("Rolling back the updates of the scan at %s", last.Local().Format(time.RFC3339))
+	cycle := newScanCycle(containers)
+	var results []Result
+	for _, e := range entries {
+		if !e.Cycle.Equal(last) {
+			continue
+		}
+		cont, ok := byName[e.Container]
+		if !ok {
+			r := Result{Container: e.Container, OldImage: e.To, NewImage: e.From}
+			results = append(results, r.fail(failAt(StageInspect, "cannot roll back container %s: it no longer exists", e.Container)))
+			continue
+		}
+		if cont.ImageID != e.To {
+			u.logger.Printf("Not rolling back container %s: it was updated again since", e.Container)
+			continue
+		}
+		result := u.rollbackTo(ctx, cycle, cont, e)
+		u.handleResult(cycle, result)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// rollbackTo recreates cont from the image it ran before the update e.
+func (u *Updater) rollbackTo(ctx context.Context, cycle *scanCycle, cont types.Container, e HistoryEntry) Result {
+	cli := u.client()
+	r := Result{Container: e.Container, ID: cont.ID, OldImage: e.To, NewImage: e.From, OldVersion: e.ToVersion, NewVersion: e.FromVersion}
+
+	inspectData, err := inspectContainer(ctx, cli, cont.ID)
+	if err != nil {
+		return r.fail(failAt(StageInspect, "error inspecting container %s: %w", cont.ID[:12], err))
+	}
+	ref, err := u.imageRef(ctx, cont, inspectData)
+	if err != nil {
+		return r.fail(failAt(StageInspect, "container %s: %w", cont.ID[:12], err))
+	}
+
+	if err := u.stopContainer(ctx, cont.ID, inspectData); err != nil {
+		return r.fail(failAt(StageStop, "error stopping container %s: %w", cont.ID[:12], err))
+	}
+	err = cli.ContainerRemove(ctx, cont.ID, container.RemoveOptions{Force: true})
+	if err != nil && !(inspectData.HostConfig.AutoRemove && errdefs.IsNotFound(err)) {
+		return r.fail(failAt(StageRemove, "error removing container %s: %w", cont.ID[:12], err))
+	}
+
+	config, hostConfig, networkingConfig := recreateConfig(inspectData, e.From)
+	config.Labels[labelImage] = ref
+	u.Config().setProvenance(config.Labels, triggerRollback)
+	cycle.resolveReferences(hostConfig)
+	name := normalizeName(inspectData.Name)
+	resp, err := createContainer(ctx, cli, config, hostConfig, networkingConfig, nil, name)
+	if err != nil {
+		return r.fail(failAt(StageCreate, "error creating container %s from %s: %w", name, ShortImageID(e.From), err))
+	}
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return r.fail(failAt(StageStart, "error starting container %s: %w", name, err))
+	}
+
+	u.logger.Printf("Rolled back container %s %s", name, r.Change())
+	r.Updated = true
+	return r
+}
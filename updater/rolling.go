@@ -0,0 +1,69 @@
+package updater
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+)
+
+// labelRollingKey names the replica set of a container updated with the
+// rolling strategy. Without it, replicas are grouped by image.
+const labelRollingKey = "hikup.rolling-key"
+
+// rollingKey returns the replica set cont belongs to, or "" if it is not
+// updated with the rolling strategy.
+func rollingKey(cont types.Container) string {
+	if updateStrategy(cont.Labels[labelStrategy]) != strategyRolling {
+		return ""
+	}
+	if key := cont.Labels[labelRollingKey]; key != "" {
+		return key
+	}
+	return "image:" + imageKey(cont)
+}
+
+// imageKey identifies the image cont runs, by the reference it was created
+// from even if it runs the image by ID.
+func imageKey(cont types.Container) string {
+	if image := cont.Labels[labelImage]; image != "" {
+		return image
+	}
+	if ref, err := normalizeImageRef(cont.Image); err == nil {
+		return ref
+	}
+	return cont.Image
+}
+
+// rollingReplicas returns the selected containers of the replica set key, in
+// the order given.
+func (u *Updater) rollingReplicas(key string, containers []types.Container) []types.Container {
+	var replicas []types.Container
+	for _, cont := range containers {
+		if rollingKey(cont) != key {
+			continue
+		}
+		if selected, _ := u.Select(cont); selected {
+			replicas = append(replicas, cont)
+		}
+	}
+	return replicas
+}
+
+// rollingUpdate updates the replicas one at a time. Each must become healthy
+// before the next is touched, so the service keeps running throughout. The
+// rollout stops at the first failure, leaving the remaining replicas on
+// their old image.
+func (u *Updater) rollingUpdate(ctx context.Context, cycle *scanCycle, key string, replicas []types.Container) []Result {
+	var results []Result
+	for i, cont := range replicas {
+		r := u.updateContainer(ctx, cycle, cont)
+		results = append(results, r)
+		if r.Err != nil {
+			if rest := len(replicas) - i - 1; rest > 0 {
+				u.logger.Printf("Stopping rolling update of %s, %d replica(s) keep their old image", key, rest)
+			}
+			break
+		}
+	}
+	return results
+}
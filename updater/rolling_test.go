@@ -0,0 +1,64 @@
+package updater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestRollingUpdate(t *testing.T) {
+	healthPollInterval = time.Millisecond
+	defer func() { healthPollInterval = time.Second }()
+
+	replica := func(name string) types.Container {
+		cont := testContainer(name)
+		cont.Image = "app:latest"
+		cont.Labels = map[string]string{labelStrategy: "rolling"}
+		return cont
+	}
+	app1, web, app2, app3 := replica("app-1"), testContainer("web"), replica("app-2"), replica("app-3")
+	running := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		State: &types.ContainerState{Running: true, Health: &types.Health{Status: types.Healthy}},
+	}}
+	unhealthy := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		State: &types.ContainerState{Running: true, Health: &types.Health{Status: types.Unhealthy}},
+	}}
+	cli := &fakeClient{
+		containers: []types.Container{app1, web, app2, app3},
+		inspect: map[string]types.ContainerJSON{
+			app1.ID:                 namedInspect("app-1", &container.HostConfig{}),
+			web.ID:                  namedInspect("web", &container.HostConfig{}),
+			app2.ID:                 namedInspect("app-2", &container.HostConfig{}),
+			app3.ID:                 namedInspect("app-3", &container.HostConfig{}),
+			"new-app-1000000000000": running,
+			"new-app-2000000000000": unhealthy,
+		},
+	}
+	for _, id := range []string{app1.ID, app2.ID, app3.ID} {
+		inspect := cli.inspect[id]
+		inspect.Config.Labels = map[string]string{labelStrategy: "rolling"}
+		cli.inspect[id] = inspect
+	}
+
+	results, err := scanAll(cli, Config{HealthTimeout: Duration(5 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, r := range results {
+		order = append(order, r.Container)
+	}
+	// The replicas are updated where the first one is listed, and app-3 is
+	// left alone once app-2 fails its health check
+	if len(results) != 3 || order[0] != "app-1" || order[1] != "app-2" || order[2] != "web" {
+		t.Fatalf("got results for %v, want app-1, app-2, web", order)
+	}
+	if results[1].Stage != StageHealth {
+		t.Errorf("got stage %q for app-2, want %q", results[1].Stage, StageHealth)
+	}
+	if containsName(cli.calls, "stop "+app3.ID) {
+		t.Error("app-3 updated after app-2 failed")
+	}
+}
@@ -0,0 +1,86 @@
+package updater
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// schemaEnums lists the accepted values of the string options that only
+// take a few, by JSON name.
+var schemaEnums = map[string][]string{
+	"pull_policy":            {string(pullAlways), string(pullIfNotPresent), string(pullNever)},
+	"missing_image":          {string(missingImageUpdate), string(missingImageSkip)},
+	"latest_policy":          {string(latestUpdate), string(latestWarn), string(latestSkip)},
+	"port_mismatch":          {string(portMismatchWarn), string(portMismatchFail), string(portMismatchIgnore)},
+	"cleanup_timing":         {string(cleanupAfterStart), string(cleanupAfterHealthy), string(cleanupNever)},
+	"vulnerability_severity": severities,
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// Schema returns a JSON Schema of the configuration file format, derived
+// from the json tags of Config so it covers every option.
+func Schema() map[string]interface{} {
+	s := typeSchema(reflect.TypeOf(Config{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "hikup configuration"
+	return s
+}
+
+// WriteSchema writes the schema returned by Schema as indented JSON.
+func WriteSchema(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Schema())
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == durationType {
+		return map[string]interface{}{
+			"type":        "string",
+			"pattern":     `^[-+]?(([0-9]*(\.[0-9]*)?)(ns|us|µs|ms|s|m|h))+$|^[-+]?0$`,
+			"description": `A duration like "30s" or "1h30m"`,
+		}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			p := typeSchema(f.Type)
+			if values, ok := schemaEnums[name]; ok {
+				p["enum"] = values
+			}
+			properties[name] = p
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
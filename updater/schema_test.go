@@ -0,0 +1,67 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSchema(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Type    string   `json:"type"`
+			Pattern string   `json:"pattern"`
+			Enum    []string `json:"enum"`
+			Items   struct {
+				Type       string                     `json:"type"`
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"items"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	// Every option is described
+	cfg := reflect.TypeOf(Config{})
+	for i := 0; i < cfg.NumField(); i++ {
+		f := cfg.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if _, ok := schema.Properties[f.Tag.Get("json")]; !ok {
+			t.Errorf("option %s is missing from the schema", f.Tag.Get("json"))
+		}
+	}
+
+	interval := schema.Properties["interval"]
+	if interval.Type != "string" {
+		t.Errorf("got type %q for interval, want string", interval.Type)
+	}
+	pattern := regexp.MustCompile(interval.Pattern)
+	for _, d := range []string{"30s", "1h30m", "1.5h", "0"} {
+		if !pattern.MatchString(d) {
+			t.Errorf("duration %q does not match the schema", d)
+		}
+	}
+	if pattern.MatchString("1 hour") {
+		t.Error(`duration "1 hour" matches the schema`)
+	}
+
+	if got := schema.Properties["max_restarts"].Type; got != "integer" {
+		t.Errorf("got type %q for max_restarts, want integer", got)
+	}
+	if got := schema.Properties["pull_policy"].Enum; !reflect.DeepEqual(got, []string{"always", "if-not-present", "never"}) {
+		t.Errorf("got pull_policy values %v", got)
+	}
+	groups := schema.Properties["groups"]
+	if groups.Type != "array" || groups.Items.Type != "object" || groups.Items.Properties["containers"] == nil {
+		t.Errorf("groups are not described as an array of objects with containers: %+v", groups)
+	}
+}
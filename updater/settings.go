@@ -0,0 +1,199 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Labels overriding the stop_timeout and health_timeout options for a
+// container, e.g. hikup.stop-timeout=2m.
+const (
+	labelStopTimeout   = "hikup.stop-timeout"
+	labelHealthTimeout = "hikup.health-timeout"
+)
+
+// defaultContainerConfigDir is where per-container settings files are read
+// from if container_config_dir is not set.
+const defaultContainerConfigDir = "/etc/hikup/containers"
+
+// ContainerSettings are the per-container settings read from
+// <container_config_dir>/<name>.yaml (or .yml or .json). Each field stands
+// in for the hikup label of the same name; the label wins if both are set.
+type ContainerSettings struct {
+	Strategy      string   `json:"strategy" yaml:"strategy"`
+	Window        string   `json:"window" yaml:"window"`
+	PullPolicy    string   `json:"pull_policy" yaml:"pull_policy"`
+	Canary        bool     `json:"canary" yaml:"canary"`
+	StopTimeout   Duration `json:"stop_timeout" yaml:"stop_timeout"`
+	HealthTimeout Duration `json:"health_timeout" yaml:"health_timeout"`
+	IdleCheck     string   `json:"idle_check" yaml:"idle_check"`
+	RegistryAuth  string   `json:"registry_auth" yaml:"registry_auth"`
+	UpdateGroup   string   `json:"update_group" yaml:"update_group"`
+}
+
+// labels returns the settings as the labels they stand in for.
+func (s ContainerSettings) labels() map[string]string {
+	labels := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+	set(labelStrategy, s.Strategy)
+	set(labelWindow, s.Window)
+	set(labelPullPolicy, s.PullPolicy)
+	set(labelIdleCheck, s.IdleCheck)
+	set(labelRegistryAuth, s.RegistryAuth)
+	set(labelUpdateGroup, s.UpdateGroup)
+	if s.Canary {
+		labels[labelCanary] = "true"
+	}
+	if s.StopTimeout > 0 {
+		labels[labelStopTimeout] = s.StopTimeout.String()
+	}
+	if s.HealthTimeout > 0 {
+		labels[labelHealthTimeout] = s.HealthTimeout.String()
+	}
+	return labels
+}
+
+func (c Config) containerConfigDir() string {
+	if c.ContainerConfigDir != "" {
+		return c.ContainerConfigDir
+	}
+	return defaultContainerConfigDir
+}
+
+// loadContainerSettings reads the settings files in dir, by container name.
+// A missing dir holds no settings. Files that cannot be read or parsed are
+// skipped and reported.
+func loadContainerSettings(dir string) (map[string]ContainerSettings, []error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	settings := make(map[string]ContainerSettings)
+	var errs []error
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if _, ok := settings[name]; ok {
+			errs = append(errs, fmt.Errorf("%s: more than one settings file for container %s", dir, name))
+			continue
+		}
+		s, err := readContainerSettings(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		settings[name] = s
+	}
+	return settings, errs
+}
+
+func readContainerSettings(path string) (ContainerSettings, error) {
+	var s ContainerSettings
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if filepath.Ext(path) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&s)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(&s); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return s, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// reloadSettings reads the per-container settings files again, logging the
+// ones that are invalid. Called at the start of every scan and update.
+func (u *Updater) reloadSettings() {
+	settings, errs := loadContainerSettings(u.Config().containerConfigDir())
+	for _, err := range errs {
+		u.logger.Printf("Error loading container settings: %v", err)
+	}
+	u.mu.Lock()
+	u.settings = settings
+	u.mu.Unlock()
+}
+
+// containerLabels returns labels of the container name merged over its
+// settings file, if it has one.
+func (u *Updater) containerLabels(name string, labels map[string]string) map[string]string {
+	u.mu.RLock()
+	s, ok := u.settings[name]
+	u.mu.RUnlock()
+	if !ok {
+		return labels
+	}
+	merged := s.labels()
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+// withSettings returns containers with their settings files merged into
+// their labels, for the decisions made from the container list.
+func (u *Updater) withSettings(containers []types.Container) []types.Container {
+	merged := make([]types.Container, len(containers))
+	for i, cont := range containers {
+		cont.Labels = u.containerLabels(baseName(containerName(cont), cont.Labels), cont.Labels)
+		merged[i] = cont
+	}
+	return merged
+}
+
+// labelDuration parses the duration label key, or returns 0 if it is unset
+// or invalid.
+func (u *Updater) labelDuration(labels map[string]string, key string) time.Duration {
+	value, ok := labels[key]
+	if !ok {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		u.logger.Printf("Warning: ignoring invalid %s=%q", key, value)
+		return 0
+	}
+	return d
+}
+
+// stopTimeout returns how many seconds the inspected container gets to exit
+// when stopped: its hikup.stop-timeout, or else what Config.stopTimeout says.
+func (u *Updater) stopTimeout(inspectData types.ContainerJSON) int {
+	if inspectData.Config != nil {
+		labels := u.containerLabels(baseName(normalizeName(inspectData.Name), inspectData.Config.Labels), inspectData.Config.Labels)
+		if timeout := u.labelDuration(labels, labelStopTimeout); timeout > 0 {
+			return int((timeout + time.Second - 1) / time.Second)
+		}
+	}
+	return u.Config().stopTimeout(inspectData)
+}
@@ -0,0 +1,55 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestContainerSettingsFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"web.yaml":    "strategy: restart-only\n",
+		"api.json":    `{"strategy": "restart-only", "stop_timeout": "45s"}`,
+		"worker.yaml": "stratgey: restart-only\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	web, api, worker := testContainer("web"), testContainer("api"), testContainer("worker")
+	// Labels win over the settings file
+	api.Labels = map[string]string{labelStrategy: "recreate"}
+	apiInspect := namedInspect("api", &container.HostConfig{})
+	apiInspect.Config.Labels = api.Labels
+	cli := &fakeClient{
+		containers: []types.Container{web, api, worker},
+		inspect: map[string]types.ContainerJSON{
+			web.ID:    namedInspect("web", &container.HostConfig{}),
+			api.ID:    apiInspect,
+			worker.ID: namedInspect("worker", &container.HostConfig{}),
+		},
+	}
+
+	if _, err := scanAll(cli, Config{ContainerConfigDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if !containsName(cli.calls, "restart "+web.ID) {
+		t.Error("web not restarted as its settings file says")
+	}
+	if !containsName(cli.calls, "create api") {
+		t.Error("api not recreated as its label says")
+	}
+	if len(cli.stopTimeouts) == 0 || cli.stopTimeouts[0] != 45 {
+		t.Errorf("got stop timeouts %v, want 45s from the settings file of api", cli.stopTimeouts)
+	}
+	// The misspelled option invalidates the file
+	if !containsName(cli.calls, "create worker") {
+		t.Error("worker not recreated despite its invalid settings file")
+	}
+}
@@ -0,0 +1,108 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+)
+
+// SignaturePolicy requires images to be signed with a cosign key.
+type SignaturePolicy struct {
+	// Registry is the registry the policy applies to, e.g. "ghcr.io" or
+	// "docker.io". Empty for all images without a more specific policy.
+	Registry string `json:"registry" yaml:"registry"`
+	// Key is the path of the cosign public key.
+	Key string `json:"key" yaml:"key"`
+}
+
+// cosignCommand is the cosign binary; a variable so tests can replace it.
+var cosignCommand = "cosign"
+
+const cosignTimeout = 2 * time.Minute
+
+func validateSignaturePolicies(policies []SignaturePolicy) []error {
+	var errs []error
+	seen := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		if p.Key == "" {
+			errs = append(errs, fmt.Errorf("verify_signatures entry for registry %q has no key", p.Registry))
+		}
+		if seen[p.Registry] {
+			errs = append(errs, fmt.Errorf("verify_signatures has more than one entry for registry %q", p.Registry))
+		}
+		seen[p.Registry] = true
+	}
+	return errs
+}
+
+// signatureKey returns the cosign key images from ref's registry must be
+// signed with, or "" if they need not be signed.
+func (c Config) signatureKey(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	domain := reference.Domain(named)
+
+	var key string
+	for _, p := range c.VerifySignatures {
+		switch p.Registry {
+		case domain:
+			return p.Key
+		case "":
+			key = p.Key
+		}
+	}
+	return key
+}
+
+// verifyRef returns the reference to verify for img pulled as ref: the
+// digest it was pulled by, so cosign checks exactly the image that will run,
+// or ref itself if the image has no digest for its repository.
+func verifyRef(img types.ImageInspect, ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	for _, repoDigest := range img.RepoDigests {
+		local, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if _, ok := local.(reference.Canonical); ok && local.Name() == named.Name() {
+			return local.String()
+		}
+	}
+	return ref
+}
+
+// verifySignature runs `cosign verify` for ref with the given public key.
+func verifySignature(ctx context.Context, ref, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, cosignTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cosignCommand, "verify", "--key", key, ref)
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("cosign verify timed out after %s", cosignTimeout)
+	}
+	if err != nil {
+		// The last line of cosign's output names the problem
+		msg := strings.TrimSpace(string(out))
+		if i := strings.LastIndexByte(msg, '\n'); i >= 0 {
+			msg = msg[i+1:]
+		}
+		if msg == "" {
+			return fmt.Errorf("cosign verify failed: %w", err)
+		}
+		return fmt.Errorf("cosign verify failed: %w: %s", err, msg)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestSignatureKey(t *testing.T) {
+	cfg := Config{VerifySignatures: []SignaturePolicy{
+		{Key: "all.pub"},
+		{Registry: "ghcr.io", Key: "ghcr.pub"},
+	}}
+	tests := []struct{ ref, want string }{
+		{"nginx:latest", "all.pub"},
+		{"ghcr.io/owner/app:1", "ghcr.pub"},
+	}
+	for _, tt := range tests {
+		if got := cfg.signatureKey(tt.ref); got != tt.want {
+			t.Errorf("%s: got key %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+	if got := (Config{}).signatureKey("nginx:latest"); got != "" {
+		t.Errorf("got key %q without verify_signatures, want none", got)
+	}
+}
+
+func TestVerifySignatureBlocksUpdate(t *testing.T) {
+	// A fake cosign accepting only digest references
+	cosign := filepath.Join(t.TempDir(), "cosign")
+	script := "#!/bin/sh\ncase \"$4\" in *@sha256:*) exit 0;; esac\necho 'no matching signatures' >&2\nexit 1\n"
+	if err := os.WriteFile(cosign, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cosignCommand = cosign
+	defer func() { cosignCommand = "cosign" }()
+
+	cont := testContainer("web")
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{
+		cont.ID: namedInspect("web", &container.HostConfig{}),
+	}}
+	cfg := Config{VerifySignatures: []SignaturePolicy{{Key: "cosign.pub"}}}
+
+	r := testUpdate(cli, cfg, cont)
+	if r.Stage != StageVerify {
+		t.Fatalf("got stage %q err=%v, want %q", r.Stage, r.Err, StageVerify)
+	}
+	if containsName(cli.calls, "create web") {
+		t.Error("container recreated from an unverified image")
+	}
+}
@@ -0,0 +1,78 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// smokeTestSuffix is appended to the name of the throwaway container of a
+// smoke test.
+const smokeTestSuffix = "-hikup-smoke"
+
+// smokeTestPeriod is how long a smoke-tested container without a
+// healthcheck must keep running; a variable so tests can shorten it.
+var smokeTestPeriod = 10 * time.Second
+
+// smokeTest starts the new image of p in a throwaway container to check
+// that it starts at all before the real container is replaced. The
+// throwaway container has the container's command and environment but
+// neither its volumes, nor networks, nor published ports, so it cannot
+// touch the data or traffic of the real one. It must become healthy, or
+// without a healthcheck keep running for smokeTestPeriod or exit with 0.
+func (u *Updater) smokeTest(ctx context.Context, p pendingUpdate) error {
+	cli := u.client()
+	config := &container.Config{
+		Image:       p.r.NewImage,
+		Cmd:         p.inspect.Config.Cmd,
+		Entrypoint:  p.inspect.Config.Entrypoint,
+		Env:         p.inspect.Config.Env,
+		WorkingDir:  p.inspect.Config.WorkingDir,
+		User:        p.inspect.Config.User,
+		Healthcheck: p.inspect.Config.Healthcheck,
+		Labels:      map[string]string{labelManaged: "true"},
+	}
+	hostConfig := &container.HostConfig{NetworkMode: "none"}
+	name := normalizeName(p.inspect.Name) + smokeTestSuffix
+
+	resp, err := cli.ContainerCreate(ctx, config, hostConfig, &network.NetworkingConfig{}, p.platform, name)
+	if err != nil {
+		return fmt.Errorf("error creating smoke test container: %w", err)
+	}
+	defer func() {
+		if err := cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true}); err != nil {
+			u.logger.Printf("Error removing smoke test container %s: %v", name, err)
+		}
+	}()
+
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("error starting smoke test container: %w", err)
+	}
+	cfg := u.Config()
+	for started := time.Now(); ; {
+		inspect, err := cli.ContainerInspect(ctx, resp.ID)
+		if err != nil {
+			return fmt.Errorf("error inspecting smoke test container: %w", err)
+		}
+		// With a healthcheck, of the container or the image, it must pass
+		if inspect.ContainerJSONBase != nil && inspect.State != nil && inspect.State.Health != nil {
+			if err := waitHealthy(ctx, cli, resp.ID, cfg.blueGreenHealthTimeout(), time.Duration(cfg.HealthStartPeriod)); err != nil {
+				return fmt.Errorf("smoke test failed: %w", err)
+			}
+			return nil
+		}
+		if inspect.ContainerJSONBase != nil && inspect.State != nil && !inspect.State.Running {
+			if inspect.State.ExitCode != 0 {
+				return fmt.Errorf("smoke test failed: container exited with code %d", inspect.State.ExitCode)
+			}
+			return nil
+		}
+		if time.Since(started) >= smokeTestPeriod {
+			return nil
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
@@ -0,0 +1,118 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// containerState is what hikup remembers about a container across scans and
+// restarts.
+type containerState struct {
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+	// IdleDeferrals counts the updates deferred in a row because the
+	// container's idle check failed.
+	IdleDeferrals int `json:"idle_deferrals,omitempty"`
+}
+
+// stateStore holds per-container state keyed by container name. If path is
+// set, the state is persisted there as JSON after every change.
+type stateStore struct {
+	path string
+
+	mu         sync.Mutex
+	Containers map[string]*containerState `json:"containers"`
+}
+
+func newStateStore(path string) *stateStore {
+	return &stateStore{path: path, Containers: make(map[string]*containerState)}
+}
+
+// loadState reads the state file at path. A missing file yields an empty
+// state.
+func loadState(path string) (*stateStore, error) {
+	s := newStateStore(path)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("error reading state file: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return s, fmt.Errorf("error parsing state file: %w", err)
+	}
+	if s.Containers == nil {
+		s.Containers = make(map[string]*containerState)
+	}
+	return s, nil
+}
+
+// save writes the state atomically. It must be called with mu held.
+func (s *stateStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	return nil
+}
+
+// container returns the state for name, creating it if needed. It must be
+// called with mu held.
+func (s *stateStore) container(name string) *containerState {
+	cs, ok := s.Containers[name]
+	if !ok {
+		cs = &containerState{}
+		s.Containers[name] = cs
+	}
+	return cs
+}
+
+// recordOutcome updates the consecutive failure count of a container and
+// returns the new count. An error means the state could not be persisted.
+func (s *stateStore) recordOutcome(name string, failed bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs := s.container(name)
+	if failed {
+		cs.ConsecutiveFailures++
+	} else {
+		cs.ConsecutiveFailures = 0
+	}
+	return cs.ConsecutiveFailures, s.save()
+}
+
+// recordDeferral counts a deferred update of a busy container, or resets
+// the count, and returns the new count. An error means the state could not
+// be persisted.
+func (s *stateStore) recordDeferral(name string, deferred bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs := s.container(name)
+	if deferred {
+		cs.IdleDeferrals++
+	} else if cs.IdleDeferrals == 0 {
+		return 0, nil
+	} else {
+		cs.IdleDeferrals = 0
+	}
+	return cs.IdleDeferrals, s.save()
+}
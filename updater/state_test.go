@@ -0,0 +1,33 @@
+package updater
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStateConsecutiveFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for want := 1; want <= 3; want++ {
+		if got, _ := s.recordOutcome("web", true); got != want {
+			t.Fatalf("failure %d: got count %d", want, got)
+		}
+	}
+
+	// The count survives a restart
+	s, err = loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.recordOutcome("web", true); got != 4 {
+		t.Errorf("got count %d after reload, want 4", got)
+	}
+
+	if got, _ := s.recordOutcome("web", false); got != 0 {
+		t.Errorf("success should reset the count, got %d", got)
+	}
+}
@@ -0,0 +1,61 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// labelStrategy selects how a container is updated.
+const labelStrategy = "hikup.strategy"
+
+// updateStrategy is a way of replacing a container with one running the new
+// image.
+type updateStrategy string
+
+const (
+	// strategyRecreate stops and removes the container, then creates and
+	// starts its replacement.
+	strategyRecreate updateStrategy = "recreate"
+	// strategyBlueGreen starts the replacement before removing the
+	// container, see blueGreenUpdate.
+	strategyBlueGreen updateStrategy = "blue-green"
+	// strategyNoStart recreates the container but leaves it stopped.
+	strategyNoStart updateStrategy = "no-start"
+	// strategyRestartOnly pulls the image but only restarts the container,
+	// which keeps running its current image.
+	strategyRestartOnly updateStrategy = "restart-only"
+	// strategyRolling recreates the replicas of a service one at a time,
+	// each waiting for the previous to be healthy, see rollingUpdate.
+	strategyRolling updateStrategy = "rolling"
+)
+
+// containerStrategy returns the strategy selected by the hikup.strategy
+// label. Without the label, Traefik-routed containers are updated blue/green
+// if traefikBlueGreen is set, all others are recreated.
+func containerStrategy(labels map[string]string, traefikBlueGreen bool) (updateStrategy, error) {
+	switch s := updateStrategy(labels[labelStrategy]); s {
+	case "":
+		if traefikBlueGreen && labels[labelTraefikEnable] == "true" {
+			return strategyBlueGreen, nil
+		}
+		return strategyRecreate, nil
+	case strategyRecreate, strategyBlueGreen, strategyNoStart, strategyRestartOnly, strategyRolling:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown %s %q", labelStrategy, s)
+	}
+}
+
+// restartContainer restarts the container in place for the restart-only
+// strategy.
+func (u *Updater) restartContainer(ctx context.Context, r Result, inspectData types.ContainerJSON) Result {
+	timeout := u.stopTimeout(inspectData)
+	if err := u.client().ContainerRestart(ctx, r.ID, container.StopOptions{Timeout: &timeout}); err != nil {
+		return r.fail(failAt(StageStart, "error restarting container %s: %w", r.ID[:12], err))
+	}
+	u.logger.Printf("Restarted container %s (restart-only)", r.ID[:12])
+	return r
+}
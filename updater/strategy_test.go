@@ -0,0 +1,61 @@
+package updater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestContainerStrategy(t *testing.T) {
+	tests := []struct {
+		labels    map[string]string
+		blueGreen bool
+		want      updateStrategy
+	}{
+		{nil, false, strategyRecreate},
+		{map[string]string{labelStrategy: "no-start"}, false, strategyNoStart},
+		{map[string]string{labelTraefikEnable: "true"}, false, strategyRecreate},
+		{map[string]string{labelTraefikEnable: "true"}, true, strategyBlueGreen},
+		// The label wins over traefik_blue_green
+		{map[string]string{labelTraefikEnable: "true", labelStrategy: "recreate"}, true, strategyRecreate},
+	}
+	for _, tt := range tests {
+		got, err := containerStrategy(tt.labels, tt.blueGreen)
+		if err != nil || got != tt.want {
+			t.Errorf("labels %v, traefik_blue_green %v: got %q (%v), want %q", tt.labels, tt.blueGreen, got, err, tt.want)
+		}
+	}
+
+	if _, err := containerStrategy(map[string]string{labelStrategy: "yolo"}, false); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestUpdateStrategies(t *testing.T) {
+	cont := testContainer("web")
+	cont.Image = "nginx:latest"
+	newID := "new-web" + "000000000000"
+
+	tests := []struct {
+		strategy  string
+		wantCalls []string
+	}{
+		{"no-start", []string{"pull nginx:latest", "stop " + cont.ID, "remove " + cont.ID, "create web"}},
+		{"restart-only", []string{"pull nginx:latest", "restart " + cont.ID}},
+		{"recreate", []string{"pull nginx:latest", "stop " + cont.ID, "remove " + cont.ID, "create web", "start " + newID}},
+	}
+	for _, tt := range tests {
+		inspect := namedInspect("web", &container.HostConfig{})
+		inspect.Config = &container.Config{Labels: map[string]string{labelStrategy: tt.strategy}}
+		cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+
+		if r := testUpdate(cli, Config{}, cont); r.Err != nil {
+			t.Errorf("%s: %v", tt.strategy, r.Err)
+		}
+		if !reflect.DeepEqual(cli.calls, tt.wantCalls) {
+			t.Errorf("%s: got calls %v, want %v", tt.strategy, cli.calls, tt.wantCalls)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package updater
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of scans and updates. Unless the program sets an
+// OpenTelemetry tracer provider, e.g. for otlp_endpoint, its spans are
+// no-ops.
+var tracer = otel.Tracer("github.com/lnksz/hikup/updater")
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endUpdateSpan records the outcome r of a container update on span and
+// ends it.
+func endUpdateSpan(span trace.Span, r Result) {
+	span.SetAttributes(
+		attribute.Bool("hikup.updated", r.Updated),
+		attribute.String("hikup.old_image", r.OldImage),
+		attribute.String("hikup.new_image", r.NewImage),
+	)
+	if r.Stage != "" {
+		span.SetAttributes(attribute.String("hikup.stage", string(r.Stage)))
+	}
+	endSpan(span, r.Err)
+}
+
+// phase is one phase of the update of a container, timed in a child span of
+// the update, in hikup_phase_duration_seconds and in the debug log.
+type phase struct {
+	u               *Updater
+	container, name string
+	start           time.Time
+	span            trace.Span
+	// untimed phases are traced but not observed, e.g. a pull that was
+	// shared with another container
+	untimed bool
+}
+
+// startPhase starts the phase name of the update of container. The returned
+// context carries its span.
+func (u *Updater) startPhase(ctx context.Context, container, name string) (context.Context, *phase) {
+	ctx, span := tracer.Start(ctx, name)
+	return ctx, &phase{u: u, container: container, name: name, start: time.Now(), span: span}
+}
+
+// end ends the phase, which failed with err if it is not nil. Only
+// successful phases are timed.
+func (p *phase) end(err error) {
+	endSpan(p.span, err)
+	if err != nil || p.untimed {
+		return
+	}
+	d := time.Since(p.start)
+	phaseDuration.observe(d.Seconds(), "phase", p.name)
+	p.u.debugf("Container %s: %s took %s", p.container, p.name, d.Round(time.Millisecond))
+}
@@ -0,0 +1,48 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestUpdateSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := tracer
+	defer func() { tracer = prev }()
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	cont := testContainer("web")
+	cli := &fakeClient{
+		containers: []types.Container{cont},
+		inspect:    map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})},
+	}
+	if _, err := scanAll(cli, Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	scan, update := spans["scan"], spans["update"]
+	if scan == nil || update == nil {
+		t.Fatalf("missing scan or update span, got %v", spans)
+	}
+	if update.Parent().SpanID() != scan.SpanContext().SpanID() {
+		t.Error("update span is not a child of the scan span")
+	}
+	for _, phase := range []string{"pull", "stop", "create", "start"} {
+		span := spans[phase]
+		if span == nil {
+			t.Errorf("missing %s span", phase)
+			continue
+		}
+		if span.Parent().SpanID() != update.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of the update span", phase)
+		}
+	}
+}
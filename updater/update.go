@@ -0,0 +1,699 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Labels hikup adds to every container it recreates.
+const (
+	labelManaged    = "hikup.managed"
+	labelLastUpdate = "hikup.last-update"
+)
+
+// labelUpdating marks a container as being updated. Docker cannot change the
+// labels of an existing container, so hikup cannot set it on the container
+// it is recreating; it only honors the label, e.g. on containers created by
+// deployment tooling that must not be touched yet.
+const labelUpdating = "hikup.updating"
+
+// inProgress guards against updating the same container twice at once from
+// within this process.
+type inProgress struct {
+	sync.Mutex
+	names map[string]bool
+}
+
+// begin marks name as being updated. It returns false if an update of it is
+// already in progress.
+func (p *inProgress) begin(name string) bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.names[name] {
+		return false
+	}
+	p.names[name] = true
+	return true
+}
+
+func (p *inProgress) end(name string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.names, name)
+}
+
+// labelScope assigns a container to the hikup instance with the same scope.
+const labelScope = "hikup.scope"
+
+// withHikupLabels returns a copy of labels with the hikup bookkeeping labels
+// added. The preserved labels are left untouched otherwise.
+func withHikupLabels(labels map[string]string, now time.Time) map[string]string {
+	merged := make(map[string]string, len(labels)+2)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	// Only set on rolled back containers
+	delete(merged, labelImage)
+	merged[labelManaged] = "true"
+	merged[labelLastUpdate] = now.UTC().Format(time.RFC3339)
+	return merged
+}
+
+// imagePlatform returns the platform of img, or nil if it is unknown.
+func imagePlatform(img types.ImageInspect) *ocispec.Platform {
+	if img.Os == "" || img.Architecture == "" {
+		return nil
+	}
+	return &ocispec.Platform{OS: img.Os, Architecture: img.Architecture, Variant: img.Variant}
+}
+
+// labelImageVersion is the OCI annotation images use to carry their
+// human-readable version.
+const labelImageVersion = "org.opencontainers.image.version"
+
+// imageVersion returns the version label of img, or "" if it has none.
+func imageVersion(img types.ImageInspect) string {
+	if img.Config == nil {
+		return ""
+	}
+	return img.Config.Labels[labelImageVersion]
+}
+
+// platformString formats p as "os/arch[/variant]" for image pulls.
+func platformString(p *ocispec.Platform) string {
+	if p == nil {
+		return ""
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// pullImage pulls ref and waits for the pull to finish. The daemon streams
+// progress while pulling, and the pull is only complete once that stream has
+// been read to EOF.
+func pullImage(ctx context.Context, cli DockerClient, ref string, options image.PullOptions) error {
+	body, err := cli.ImagePull(ctx, ref, options)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return fmt.Errorf("error reading pull progress: %w", err)
+	}
+	return nil
+}
+
+// containerUptime returns how long the inspected container has been running.
+// ok is false if it is not running.
+func containerUptime(inspectData types.ContainerJSON, now time.Time) (uptime time.Duration, ok bool) {
+	if inspectData.ContainerJSONBase == nil || inspectData.State == nil || !inspectData.State.Running {
+		return 0, false
+	}
+	started, err := time.Parse(time.RFC3339Nano, inspectData.State.StartedAt)
+	if err != nil {
+		return 0, false
+	}
+	return now.Sub(started), true
+}
+
+// inspectAttempts is how often inspecting a container is tried before its
+// update fails, waiting inspectRetryDelay times the attempt in between.
+const inspectAttempts = 3
+
+// inspectRetryDelay is a variable so tests can shorten it.
+var inspectRetryDelay = time.Second
+
+// inspectContainer inspects the container with the given ID, retrying
+// transient errors such as a busy daemon. A container that does not exist
+// (anymore) is not retried.
+func inspectContainer(ctx context.Context, cli DockerClient, id string) (types.ContainerJSON, error) {
+	for attempt := 1; ; attempt++ {
+		inspectData, err := cli.ContainerInspect(ctx, id)
+		if err == nil || errdefs.IsNotFound(err) || attempt == inspectAttempts {
+			if err != nil && attempt > 1 {
+				err = fmt.Errorf("after %d attempts: %w", attempt, err)
+			}
+			return inspectData, err
+		}
+		select {
+		case <-ctx.Done():
+			return inspectData, err
+		case <-time.After(time.Duration(attempt) * inspectRetryDelay):
+		}
+	}
+}
+
+// stopContainer stops the container with the given ID and inspect data,
+// which is killed if it does not exit within its stop timeout. A kill is
+// logged and counted, since the container may not have shut down cleanly.
+func (u *Updater) stopContainer(ctx context.Context, id string, inspectData types.ContainerJSON) error {
+	timeout := u.stopTimeout(inspectData)
+	if err := u.client().ContainerStop(ctx, id, container.StopOptions{Timeout: &timeout}); err != nil {
+		return err
+	}
+	// A container started with --rm may be gone already, then it is unknown
+	stopped, err := u.client().ContainerInspect(ctx, id)
+	if err == nil && wasKilled(stopped, inspectData) {
+		name := normalizeName(inspectData.Name)
+		u.logger.Printf("Warning: container %s did not stop within %ds and was killed", name, timeout)
+		forcedKillsTotal.add(1, "container", name)
+	}
+	return nil
+}
+
+// wasKilled reports whether the stopped container was killed after its
+// stop timeout rather than exiting on its stop signal.
+func wasKilled(stopped, before types.ContainerJSON) bool {
+	if stopped.ContainerJSONBase == nil || stopped.State == nil || stopped.State.OOMKilled {
+		return false
+	}
+	if before.Config != nil {
+		switch before.Config.StopSignal {
+		case "SIGKILL", "KILL", "9":
+			return false
+		}
+	}
+	return stopped.State.ExitCode == 137 // 128 + SIGKILL
+}
+
+// pendingUpdate is a container whose new image has been pulled and that is
+// ready to be replaced.
+type pendingUpdate struct {
+	cont     types.Container
+	inspect  types.ContainerJSON
+	platform *ocispec.Platform
+	strategy updateStrategy
+	r        Result
+	// unchanged is set if the container needs no update, because its local
+	// image did not change without a pull or it already runs the image the
+	// registry serves.
+	unchanged bool
+}
+
+// updateContainer recreates cont with the latest version of its image.
+// The result reports whether the container was actually recreated and from
+// which image to which.
+func (u *Updater) updateContainer(ctx context.Context, cycle *scanCycle, cont types.Container) Result {
+	r, _ := u.checkedUpdate(ctx, cycle, cont)
+	return r
+}
+
+// checkedUpdate is updateContainer, but also reports whether cont was left
+// alone because it was found to be up to date, rather than skipped.
+func (u *Updater) checkedUpdate(ctx context.Context, cycle *scanCycle, cont types.Container) (result Result, upToDate bool) {
+	r := Result{Container: baseName(containerName(cont), cont.Labels), ID: cont.ID, OldImage: cont.ImageID}
+	ctx, span := tracer.Start(ctx, "update", trace.WithAttributes(
+		attribute.String("hikup.container", r.Container),
+		attribute.String("hikup.image", cont.Image),
+	))
+	defer func() { endUpdateSpan(span, result) }()
+
+	if !u.updating.begin(r.Container) {
+		u.logger.Printf("Skipping container %s: an update of it is already in progress", cont.ID[:12])
+		return r, false
+	}
+	defer u.updating.end(r.Container)
+
+	unlock, err := u.updateGroups.lock(ctx, u.containerLabels(r.Container, cont.Labels)[labelUpdateGroup])
+	if err != nil {
+		return r.fail(failAt(StageInspect, "container %s: waiting for its update group: %w", cont.ID[:12], err)), false
+	}
+	defer unlock()
+
+	p, ok := u.prepareUpdate(ctx, cycle, cont, r)
+	if !ok {
+		return p.r, p.unchanged
+	}
+	if !u.approve(p) {
+		return p.r, false
+	}
+	if u.SmokeTest {
+		if err := u.smokeTest(ctx, p); err != nil {
+			return p.r.fail(failAt(StageVerify, "not updating container %s: image %s: %w", cont.ID[:12], cont.Image, err)), false
+		}
+	}
+	if !u.awaitIdle(ctx, p) {
+		return p.r, false
+	}
+
+	switch p.strategy {
+	case strategyRestartOnly:
+		return u.restartContainer(ctx, p.r, p.inspect), false
+	case strategyBlueGreen:
+		if reason := blueGreenBlocker(p.inspect); reason != "" {
+			u.logger.Printf("Cannot update container %s blue/green (%s), recreating it instead", cont.ID[:12], reason)
+		} else {
+			return u.blueGreenUpdate(ctx, cycle, p.cont, p.inspect, p.platform, p.r), false
+		}
+	}
+
+	if err := u.checkPortConflicts(ctx, cont.ID, p.inspect.HostConfig.PortBindings); err != nil {
+		return p.r.fail(failAt(StageInspect, "not updating container %s: %w", cont.ID[:12], err)), false
+	}
+
+	// Stop the container
+	stopCtx, stop := u.startPhase(ctx, p.r.Container, "stop")
+	err = u.stopContainer(stopCtx, cont.ID, p.inspect)
+	stop.end(err)
+	if err != nil {
+		return p.r.fail(failAt(StageStop, "error stopping container %s: %w", cont.ID[:12], err)), false
+	}
+	return u.recreateStopped(ctx, cycle, p), false
+}
+
+// prepareUpdate inspects cont and pulls its image. ok is false if the
+// container is not to be replaced, in which case p.r is final.
+func (u *Updater) prepareUpdate(ctx context.Context, cycle *scanCycle, cont types.Container, r Result) (p pendingUpdate, ok bool) {
+	cli := u.client()
+	p.r = r
+
+	// Inspect the container to get its full configuration
+	inspectData, err := inspectContainer(ctx, cli, cont.ID)
+	if errdefs.IsNotFound(err) {
+		u.logger.Printf("Container %s vanished before it could be updated", r.Container)
+		p.r.Vanished = true
+		return p, false
+	}
+	if err != nil {
+		p.r = r.fail(failAt(StageInspect, "error inspecting container %s: %w", cont.ID[:12], err))
+		return p, false
+	}
+	if inspectData.Config != nil && inspectData.Config.Labels[labelUpdating] == "true" {
+		u.logger.Printf("Skipping container %s: labeled %s=true", cont.ID[:12], labelUpdating)
+		return p, false
+	}
+
+	if uptime, ok := containerUptime(inspectData, time.Now()); ok && uptime < time.Duration(u.Config().MinUptime) {
+		u.logger.Printf("Skipping recently started container %s: up for %s, min_uptime is %s", r.Container, uptime.Round(time.Second), u.Config().MinUptime)
+		return p, false
+	}
+	if status := healthStatus(inspectData); u.Config().RequireHealthyBeforeUpdate && status != "" && status != types.Healthy {
+		u.logger.Printf("Skipping container %s: it is %s, and require_healthy_before_update is set", r.Container, status)
+		return p, false
+	}
+
+	labels := u.containerLabels(r.Container, inspectData.Config.Labels)
+	strategy, err := containerStrategy(labels, u.Config().TraefikBlueGreen)
+	if err != nil {
+		p.r = r.fail(failAt(StageInspect, "container %s: %w", cont.ID[:12], err))
+		return p, false
+	}
+
+	// Pull and recreate with an explicit tag, even if the container runs
+	// an image by ID
+	if cont.Image, err = u.imageRef(ctx, cont, inspectData); err != nil {
+		p.r = r.fail(failAt(StageInspect, "container %s: %w", cont.ID[:12], err))
+		return p, false
+	}
+	requested, override := cycle.requestedImage(r.Container)
+	if u.WatchImage != "" {
+		// Containers listed with an image ID are only checked now
+		if !override && !u.watchesImage(cont.Image) {
+			u.debugf("Skipping container %s: image %s is not --watch-image %s", r.Container, cont.Image, u.WatchImage)
+			return p, false
+		}
+	} else if _, listed := u.desiredImage(r.Container); !override && !listed {
+		// Containers listed with an image ID are only checked now
+		tag, _ := imageTag(cont.Image)
+		if !u.Config().autoUpdateTag(tag) {
+			u.logger.Printf("Skipping container %s: tag %q of image %s is not in auto_update_tags", r.Container, tag, cont.Image)
+			return p, false
+		}
+		if tag == "latest" {
+			switch u.Config().latestPolicy() {
+			case latestSkip:
+				u.logger.Printf("Skipping container %s: image %s uses the latest tag, skipped by latest_policy", r.Container, cont.Image)
+				return p, false
+			case latestWarn:
+				u.logger.Printf("Warning: updating container %s on the mutable latest tag of %s, pin a version to control when it changes", r.Container, cont.Image)
+			}
+		}
+	}
+	if override {
+		u.logger.Printf("Updating container %s to the requested image %s", r.Container, requested)
+		cont.Image = requested
+	}
+	rewritten, rewrite, err := rewriteRegistry(u.Config().RegistryRewrite, cont.Image)
+	if err != nil {
+		p.r = r.fail(failAt(StageInspect, "container %s: %w", cont.ID[:12], err))
+		return p, false
+	}
+	if rewrite {
+		u.logger.Printf("Rewriting image %s of container %s to %s", cont.Image, r.Container, rewritten)
+		cont.Image = rewritten
+	}
+
+	policy, err := u.Config().containerPullPolicy(labels)
+	if err != nil {
+		p.r = r.fail(failAt(StageInspect, "container %s: %w", cont.ID[:12], err))
+		return p, false
+	}
+	// With image_load_dir, images arrive as tarballs instead
+	pull := !u.NoPull && policy != pullNever && u.Config().ImageLoadDir == ""
+	if pull && policy == pullIfNotPresent {
+		if _, _, err := cli.ImageInspectWithRaw(ctx, cont.Image); err == nil {
+			pull = false
+		}
+	}
+
+	// Keep the platform the container currently runs on, so a multi-arch
+	// image does not switch to another variant. The image may be gone
+	// already, then the platform and version are simply unknown.
+	oldImage, _, oldErr := cli.ImageInspectWithRaw(ctx, inspectData.Image)
+	platform := imagePlatform(oldImage)
+	r.OldVersion = imageVersion(oldImage)
+	// Nothing can be compared with a removed image, e.g. after pruning
+	missing := errdefs.IsNotFound(oldErr)
+	if missing {
+		if u.Config().missingImage() == missingImageSkip {
+			u.logger.Printf("Skipping container %s: its image %s is gone locally (missing_image: skip)", r.Container, ShortImageID(inspectData.Image))
+			return p, false
+		}
+		u.logger.Printf("Image %s of container %s is gone locally, treating the container as out of date", ShortImageID(inspectData.Image), r.Container)
+	}
+
+	desired, reconcile := u.desiredImage(r.Container)
+	if reconcile && !override {
+		ref, digest, err := desiredRef(cont.Image, desired)
+		if err != nil {
+			p.r = r.fail(failAt(StageInspect, "container %s: desired state: %w", cont.ID[:12], err))
+			return p, false
+		}
+		if hasRepoDigest(oldImage, ref, digest) {
+			u.debugf("Container %s is in its desired state %s", r.Container, ref)
+			r.NewImage, r.NewVersion = oldImage.ID, r.OldVersion
+			p = pendingUpdate{cont: cont, inspect: inspectData, platform: platform, strategy: strategy, r: r, unchanged: true}
+			return p, false
+		}
+		u.logger.Printf("Reconciling container %s to its desired state %s", r.Container, ref)
+		cont.Image = ref
+	}
+
+	if min := u.Config().VulnerabilitySeverity; min != "" && !missing {
+		min, _ = parseSeverity(min)
+		ids, err := scanVulnerabilities(ctx, inspectData.Image, min, u.Config().TrivyServer)
+		if err != nil {
+			p.r = r.fail(failAt(StageVerify, "error scanning image of container %s for vulnerabilities: %w", cont.ID[:12], err))
+			return p, false
+		}
+		if len(ids) == 0 {
+			u.logger.Printf("Skipping container %s: its image has no vulnerabilities of severity %s or higher", r.Container, min)
+			r.NewImage, r.NewVersion = r.OldImage, r.OldVersion
+			p = pendingUpdate{cont: cont, inspect: inspectData, platform: platform, strategy: strategy, r: r, unchanged: true}
+			return p, false
+		}
+		u.logger.Printf("Image of container %s has %d vulnerabilities of severity %s or higher: %s", r.Container, len(ids), min, vulnerabilitySummary(ids))
+	}
+
+	if pull && u.Config().RegistryHeadCheck && !reconcile {
+		digest, err := remoteDigest(ctx, u.registry, cont.Image)
+		switch {
+		case err != nil:
+			u.logger.Printf("Registry check of %s failed, pulling instead: %v", cont.Image, err)
+		case hasRepoDigest(oldImage, cont.Image, digest):
+			u.logger.Printf("Container %s is up to date with %s", cont.ID[:12], cont.Image)
+			r.NewImage, r.NewVersion = oldImage.ID, r.OldVersion
+			p = pendingUpdate{cont: cont, inspect: inspectData, platform: platform, strategy: strategy, r: r, unchanged: true}
+			return p, false
+		}
+	}
+
+	if pull {
+		if err := u.checkFreeDisk(); err != nil {
+			p.r = r.fail(failAt(StagePull, "not pulling image for container %s: %w", cont.ID[:12], err))
+			return p, false
+		}
+		// Pull the latest image
+		auth, err := u.pullAuth(ctx, labels, cont.Image)
+		if err != nil {
+			u.logger.Printf("Error getting registry credentials for %s, pulling anonymously: %v", cont.Image, err)
+		}
+		pullCtx, pullPhase := u.startPhase(ctx, r.Container, "pull")
+		cached, err := u.pull(pullCtx, cycle, cont.Image, image.PullOptions{Platform: platformString(platform), RegistryAuth: auth})
+		pullPhase.untimed = cached
+		pullPhase.end(err)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				// The tag is gone upstream, often an abandoned image
+				u.logger.Printf("Image unresolvable: %s of container %s does not exist in the registry", cont.Image, r.Container)
+				imageUnresolvable.set(1, "container", r.Container)
+			}
+			p.r = r.fail(failAt(StagePull, "error pulling image for container %s: %w", cont.ID[:12], err))
+			return p, false
+		}
+		imageUnresolvable.set(0, "container", r.Container)
+
+		if cached {
+			u.debugf("Image %s of container %s was already pulled", cont.Image, cont.ID[:12])
+		} else {
+			u.logger.Printf("Pulled latest image for container %s", cont.ID[:12])
+		}
+	}
+
+	newImage, _, err := cli.ImageInspectWithRaw(ctx, cont.Image)
+	if err != nil {
+		p.r = r.fail(failAt(StagePull, "error inspecting image %s for container %s: %w", cont.Image, cont.ID[:12], err))
+		return p, false
+	}
+	r.NewImage = newImage.ID
+	r.NewVersion = imageVersion(newImage)
+
+	p = pendingUpdate{cont: cont, inspect: inspectData, platform: platform, strategy: strategy, r: r}
+	if !pull {
+		// The image is distributed externally or already present; only act
+		// if the local tag now points at a different image than the
+		// container runs.
+		if newImage.ID == cont.ImageID {
+			p.unchanged = true
+			return p, false
+		}
+		u.logger.Printf("Local image %s changed for container %s", cont.Image, cont.ID[:12])
+	}
+
+	followImageUser(&p.inspect, oldImage)
+	if u.Config().FollowImageCommand {
+		if changed := followImageCommand(&p.inspect, oldImage, newImage); len(changed) > 0 {
+			u.logger.Printf("Container %s follows the new default %s of image %s", r.Container, strings.Join(changed, " and "), cont.Image)
+		}
+	}
+
+	if removed := removedPorts(oldImage, newImage, inspectData.HostConfig.PortBindings); len(removed) > 0 {
+		switch u.Config().portMismatch() {
+		case portMismatchFail:
+			p.r = r.fail(failAt(StageVerify, "refusing to update container %s: image %s no longer exposes published port(s) %s", cont.ID[:12], cont.Image, strings.Join(removed, ", ")))
+			return p, false
+		case portMismatchWarn:
+			u.logger.Printf("Warning: image %s no longer exposes port(s) %s published by container %s", cont.Image, strings.Join(removed, ", "), r.Container)
+		}
+	}
+
+	if key := u.Config().signatureKey(cont.Image); key != "" {
+		if err := verifySignature(ctx, verifyRef(newImage, cont.Image), key); err != nil {
+			p.r = r.fail(failAt(StageVerify, "refusing to update container %s: image %s is not signed with %s: %w", cont.ID[:12], cont.Image, key, err))
+			return p, false
+		}
+		u.logger.Printf("Verified signature of %s for container %s", cont.Image, cont.ID[:12])
+	}
+	return p, true
+}
+
+// approve reports whether the prepared update may go ahead. It logs the
+// update instead with --dry-run and asks Confirm, if set.
+func (u *Updater) approve(p pendingUpdate) bool {
+	if u.DryRun {
+		u.logger.Printf("Would update container %s %s", p.cont.ID[:12], p.r.Change())
+		config, hostConfig, _ := recreateConfig(p.inspect, p.cont.Image)
+		diffs := append(specDiff("Config", p.inspect.Config, config), specDiff("HostConfig", p.inspect.HostConfig, hostConfig)...)
+		for _, d := range diffs {
+			u.logger.Printf("  %s", d)
+		}
+		return false
+	}
+
+	if u.Confirm != nil && !u.Confirm(normalizeName(p.inspect.Name), p.cont.ImageID, p.r.NewImage) {
+		u.logger.Printf("Update of container %s declined", p.cont.ID[:12])
+		return false
+	}
+	return true
+}
+
+// recreateStopped replaces the stopped container of p with a new one running
+// the pulled image.
+func (u *Updater) recreateStopped(ctx context.Context, cycle *scanCycle, p pendingUpdate) Result {
+	cli, cont, inspectData, r := u.client(), p.cont, p.inspect, p.r
+	cfg := u.Config()
+	cleanup := cfg.cleanupTiming()
+	healthTimeout, startPeriod := time.Duration(cfg.HealthTimeout), time.Duration(cfg.HealthStartPeriod)
+	if timeout := u.labelDuration(u.containerLabels(r.Container, inspectData.Config.Labels), labelHealthTimeout); timeout > 0 {
+		healthTimeout = timeout
+	}
+	if p.strategy == strategyRolling && healthTimeout == 0 {
+		// The next replica may only go down once this one is healthy
+		healthTimeout = cfg.blueGreenHealthTimeout()
+	}
+
+	name, nameLabels, err := cfg.recreateName(inspectData)
+	if err != nil {
+		return r.fail(failAt(StageCreate, "container %s: %w", cont.ID[:12], err))
+	}
+
+	// Remove the container. A container created with --rm is already gone
+	// once stopped.
+	err = cli.ContainerRemove(ctx, cont.ID, container.RemoveOptions{RemoveVolumes: false, RemoveLinks: false, Force: true})
+	if err != nil && !(inspectData.HostConfig.AutoRemove && errdefs.IsNotFound(err)) {
+		return r.fail(failAt(StageRemove, "error removing container %s: %w", cont.ID[:12], err))
+	}
+
+	config, hostConfig, networkingConfig := recreateConfig(inspectData, cont.Image)
+	for k, v := range nameLabels {
+		config.Labels[k] = v
+	}
+	cfg.setProvenance(config.Labels, cycle.updateTrigger())
+	// The namespace or volume owner may have been recreated under a new ID
+	// already
+	cycle.resolveReferences(hostConfig)
+
+	// Create a new container with the same configuration
+	createCtx, create := u.startPhase(ctx, r.Container, "create")
+	resp, err := createContainer(createCtx, cli, config, hostConfig, networkingConfig, p.platform, name)
+	create.end(err)
+	if err != nil {
+		return r.fail(failAt(StageCreate, "error creating new container %s (replacing %s): %w", name, cont.ID[:12], err))
+	}
+	cycle.rename(cont.ID, name)
+
+	// Start the new container
+	if p.strategy == strategyNoStart {
+		u.logger.Printf("Not starting new container %s (no-start)", resp.ID[:12])
+	} else {
+		startCtx, start := u.startPhase(ctx, r.Container, "start")
+		err = cli.ContainerStart(startCtx, resp.ID, container.StartOptions{})
+		start.end(err)
+		if err != nil {
+			return r.fail(failAt(StageStart, "error starting new container %s (replacing %s): %w", name, cont.ID[:12], err))
+		}
+	}
+
+	if cleanup == cleanupAfterStart {
+		u.removeOldImage(ctx, r)
+	}
+	if healthTimeout > 0 && p.strategy != strategyNoStart {
+		healthCtx, health := u.startPhase(ctx, r.Container, "health")
+		err := waitHealthy(healthCtx, cli, resp.ID, healthTimeout, startPeriod)
+		health.end(err)
+		if err != nil {
+			return r.fail(failAt(StageHealth, "new container %s (replacing %s) did not become healthy: %w", name, cont.ID[:12], err))
+		}
+	}
+	if watch := time.Duration(cfg.RestartWatch); watch > 0 && p.strategy != strategyNoStart {
+		if err := watchRestarts(ctx, cli, resp.ID, watch, cfg.MaxRestarts); err != nil {
+			r = r.fail(failAt(StageRestartLoop, "new container %s (replacing %s) is crash-looping: %w", name, cont.ID[:12], err))
+			if cfg.RollbackOnRestartLoop {
+				u.rollback(ctx, cycle, p, resp.ID)
+			}
+			return r
+		}
+	}
+	if cleanup == cleanupAfterHealthy {
+		u.removeOldImage(ctx, r)
+	}
+
+	u.logger.Printf("Successfully updated container %s to %s (%s)", cont.ID[:12], resp.ID[:12], r.Change())
+	r.Updated = true
+	if cfg.RecreateVolumesFromDependents {
+		u.recreateVolumesFromDependents(ctx, cycle, cont.ID, normalizeName(inspectData.Name), name)
+	}
+	return r
+}
+
+// recreateConfig builds the configuration for a replacement of the inspected
+// container running imageRef.
+func recreateConfig(inspectData types.ContainerJSON, imageRef string) (*container.Config, *container.HostConfig, *network.NetworkingConfig) {
+	// Prepare the container configuration
+	config := &container.Config{
+		Image:        imageRef,
+		Cmd:          inspectData.Config.Cmd,
+		Env:          inspectData.Config.Env,
+		ExposedPorts: inspectData.Config.ExposedPorts,
+		Labels:       withHikupLabels(inspectData.Config.Labels, time.Now()),
+		Volumes:      inspectData.Config.Volumes,
+		WorkingDir:   inspectData.Config.WorkingDir,
+		Entrypoint:   inspectData.Config.Entrypoint,
+		// A --user override; followImageUser clears the old image's USER
+		User: inspectData.Config.User,
+		// Interactive containers, e.g. created with -it
+		Tty:          inspectData.Config.Tty,
+		OpenStdin:    inspectData.Config.OpenStdin,
+		StdinOnce:    inspectData.Config.StdinOnce,
+		AttachStdin:  inspectData.Config.AttachStdin,
+		AttachStdout: inspectData.Config.AttachStdout,
+		AttachStderr: inspectData.Config.AttachStderr,
+		// An inline --health-cmd is not part of the image
+		Healthcheck: inspectData.Config.Healthcheck,
+	}
+
+	// Prepare the host configuration
+	hostConfig := &container.HostConfig{
+		Binds:           inspectData.HostConfig.Binds,
+		PortBindings:    inspectData.HostConfig.PortBindings,
+		RestartPolicy:   inspectData.HostConfig.RestartPolicy,
+		NetworkMode:     inspectData.HostConfig.NetworkMode,
+		Privileged:      inspectData.HostConfig.Privileged,
+		PublishAllPorts: inspectData.HostConfig.PublishAllPorts,
+		VolumesFrom:     inspectData.HostConfig.VolumesFrom,
+		Links:           recreateLinks(inspectData.HostConfig.Links),
+		AutoRemove:      inspectData.HostConfig.AutoRemove,
+		Tmpfs:           inspectData.HostConfig.Tmpfs,
+		ShmSize:         inspectData.HostConfig.ShmSize,
+		ReadonlyRootfs:  inspectData.HostConfig.ReadonlyRootfs,
+		// Logging driver and options, e.g. --log-driver journald
+		LogConfig:   inspectData.HostConfig.LogConfig,
+		OomScoreAdj: inspectData.HostConfig.OomScoreAdj,
+		// Resource limits come from the live inspect, so limits changed
+		// with `docker update` after creation are kept. This also carries
+		// the devices and GPU requests (--gpus).
+		Resources: inspectData.HostConfig.Resources,
+	}
+
+	// Prepare the network configuration
+	endpointsConfig := make(map[string]*network.EndpointSettings)
+	for netName, netConfig := range inspectData.NetworkSettings.Networks {
+		endpointsConfig[netName] = &network.EndpointSettings{
+			IPAMConfig:          netConfig.IPAMConfig,
+			Links:               netConfig.Links,
+			Aliases:             netConfig.Aliases,
+			NetworkID:           netConfig.NetworkID,
+			EndpointID:          netConfig.EndpointID,
+			Gateway:             netConfig.Gateway,
+			IPAddress:           netConfig.IPAddress,
+			IPPrefixLen:         netConfig.IPPrefixLen,
+			IPv6Gateway:         netConfig.IPv6Gateway,
+			GlobalIPv6Address:   netConfig.GlobalIPv6Address,
+			GlobalIPv6PrefixLen: netConfig.GlobalIPv6PrefixLen,
+			MacAddress:          netConfig.MacAddress,
+		}
+	}
+
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: endpointsConfig,
+	}
+
+	return config, hostConfig, networkingConfig
+}
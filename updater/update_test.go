@@ -0,0 +1,475 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// inspectFixture returns inspect data for a container with the given host
+// configuration.
+func inspectFixture(hostConfig *container.HostConfig) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			Name:       "/web",
+			HostConfig: hostConfig,
+		},
+		Config:          &container.Config{Image: "nginx:latest"},
+		NetworkSettings: &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{}},
+	}
+}
+
+// namedInspect is inspectFixture for a container called name.
+func namedInspect(name string, hostConfig *container.HostConfig) types.ContainerJSON {
+	inspect := inspectFixture(hostConfig)
+	inspect.Name = "/" + name
+	return inspect
+}
+
+func TestRecreateKeepsReadonlyRootfs(t *testing.T) {
+	oomKillDisable := true
+	orig := &container.HostConfig{
+		ReadonlyRootfs: true,
+		AutoRemove:     true,
+		Tmpfs:          map[string]string{"/run": "rw,size=64m"},
+		ShmSize:        256 << 20,
+		Resources:      container.Resources{OomKillDisable: &oomKillDisable},
+	}
+
+	_, hostConfig, _ := recreateConfig(inspectFixture(orig), "nginx:latest")
+
+	if !hostConfig.ReadonlyRootfs {
+		t.Error("recreated container lost its read-only root filesystem")
+	}
+	if !hostConfig.AutoRemove {
+		t.Error("AutoRemove not preserved")
+	}
+	if !reflect.DeepEqual(hostConfig.Tmpfs, orig.Tmpfs) {
+		t.Errorf("got tmpfs %v, want %v", hostConfig.Tmpfs, orig.Tmpfs)
+	}
+	if hostConfig.ShmSize != orig.ShmSize {
+		t.Errorf("got shm size %d, want %d", hostConfig.ShmSize, orig.ShmSize)
+	}
+	if hostConfig.OomKillDisable == nil || !*hostConfig.OomKillDisable {
+		t.Error("OomKillDisable not preserved")
+	}
+}
+
+func TestRecreateKeepsDockerUpdateLimits(t *testing.T) {
+	// Limits as reported by inspect after `docker update --memory 512m
+	// --cpus 1.5 --pids-limit 200 --restart unless-stopped`
+	pids := int64(200)
+	orig := &container.HostConfig{
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+		Resources: container.Resources{
+			Memory:      512 << 20,
+			MemorySwap:  1 << 30,
+			NanoCPUs:    1_500_000_000,
+			CPUShares:   512,
+			PidsLimit:   &pids,
+			BlkioWeight: 300,
+		},
+	}
+
+	_, hostConfig, _ := recreateConfig(inspectFixture(orig), "nginx:latest")
+
+	if !reflect.DeepEqual(hostConfig.Resources, orig.Resources) {
+		t.Errorf("got resources %+v, want %+v", hostConfig.Resources, orig.Resources)
+	}
+	if hostConfig.RestartPolicy != orig.RestartPolicy {
+		t.Errorf("got restart policy %+v, want %+v", hostConfig.RestartPolicy, orig.RestartPolicy)
+	}
+}
+
+func TestRecreateKeepsDeviceRequests(t *testing.T) {
+	// As created by `docker run --gpus all`
+	orig := &container.HostConfig{
+		Resources: container.Resources{
+			DeviceRequests: []container.DeviceRequest{{
+				Driver:       "nvidia",
+				Count:        -1,
+				Capabilities: [][]string{{"gpu"}},
+			}},
+		},
+	}
+
+	_, hostConfig, _ := recreateConfig(inspectFixture(orig), "pytorch:latest")
+
+	if !reflect.DeepEqual(hostConfig.DeviceRequests, orig.DeviceRequests) {
+		t.Errorf("got device requests %+v, want %+v", hostConfig.DeviceRequests, orig.DeviceRequests)
+	}
+}
+
+func TestUpdateKeepsLogConfigAndOomScoreAdj(t *testing.T) {
+	// As created by `docker run --log-driver journald --log-opt tag=web
+	// --oom-score-adj 500`
+	orig := &container.HostConfig{
+		LogConfig:   container.LogConfig{Type: "journald", Config: map[string]string{"tag": "web"}},
+		OomScoreAdj: 500,
+	}
+	cont := testContainer("web")
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", orig)}}
+
+	if r := testUpdate(cli, Config{}, cont); !r.Updated {
+		t.Fatalf("container not updated: %v", r.Err)
+	}
+	hostConfig := cli.created[0].hostConfig
+	if !reflect.DeepEqual(hostConfig.LogConfig, orig.LogConfig) {
+		t.Errorf("got log config %+v, want %+v", hostConfig.LogConfig, orig.LogConfig)
+	}
+	if hostConfig.OomScoreAdj != 500 {
+		t.Errorf("got OOM score adjustment %d, want 500", hostConfig.OomScoreAdj)
+	}
+}
+
+func TestUpdateKeepsUser(t *testing.T) {
+	// As created by `docker run --user 1000:1000`
+	cont := testContainer("web")
+	inspect := namedInspect("web", &container.HostConfig{})
+	inspect.Config = &container.Config{User: "1000:1000"}
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+
+	if r := testUpdate(cli, Config{}, cont); !r.Updated {
+		t.Fatalf("container not updated: %v", r.Err)
+	}
+	if got := cli.created[0].config.User; got != "1000:1000" {
+		t.Errorf("got user %q after the update, want 1000:1000", got)
+	}
+}
+
+func TestUpdateKeepsTty(t *testing.T) {
+	// As created by `docker run -it`
+	cont := testContainer("shell")
+	inspect := namedInspect("shell", &container.HostConfig{})
+	inspect.Config = &container.Config{Tty: true, OpenStdin: true, AttachStdin: true, AttachStdout: true, AttachStderr: true}
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+
+	if r := testUpdate(cli, Config{}, cont); !r.Updated {
+		t.Fatalf("container not updated: %v", r.Err)
+	}
+	config := cli.created[0].config
+	if !config.Tty || !config.OpenStdin || !config.AttachStdin || !config.AttachStdout || !config.AttachStderr {
+		t.Errorf("interactive settings lost on update: %+v", config)
+	}
+}
+
+func TestUpdateFollowsImageUser(t *testing.T) {
+	// The image's USER shows up in the inspect, but is no override
+	cont := testContainer("web")
+	inspect := namedInspect("web", &container.HostConfig{})
+	inspect.Config = &container.Config{User: "nginx"}
+	inspect.Image = "sha256:old"
+	cli := &fakeClient{
+		inspect: map[string]types.ContainerJSON{cont.ID: inspect},
+		images:  map[string]types.ImageInspect{"sha256:old": {ID: "sha256:old", Config: &container.Config{User: "nginx"}}},
+	}
+
+	if r := testUpdate(cli, Config{}, cont); !r.Updated {
+		t.Fatalf("container not updated: %v", r.Err)
+	}
+	if got := cli.created[0].config.User; got != "" {
+		t.Errorf("got user %q after the update, want the new image's default", got)
+	}
+}
+
+func TestRecreateKeepsInlineHealthcheck(t *testing.T) {
+	// As created by `docker run --health-cmd "curl -f localhost"
+	// --health-interval 10s`
+	inspect := inspectFixture(&container.HostConfig{})
+	inspect.Config = &container.Config{Healthcheck: &container.HealthConfig{
+		Test:     []string{"CMD-SHELL", "curl -f localhost"},
+		Interval: 10 * time.Second,
+	}}
+
+	config, _, _ := recreateConfig(inspect, "nginx:latest")
+
+	if !reflect.DeepEqual(config.Healthcheck, inspect.Config.Healthcheck) {
+		t.Errorf("got healthcheck %+v, want %+v", config.Healthcheck, inspect.Config.Healthcheck)
+	}
+}
+
+func TestUpdateKeepsPlatform(t *testing.T) {
+	cont := testContainer("web")
+	cont.Image = "nginx:latest"
+	inspect := namedInspect("web", &container.HostConfig{})
+	inspect.Image = "sha256:old"
+	cli := &fakeClient{
+		inspect: map[string]types.ContainerJSON{cont.ID: inspect},
+		images: map[string]types.ImageInspect{
+			"sha256:old": {ID: "sha256:old", Os: "linux", Architecture: "arm", Variant: "v7"},
+		},
+	}
+
+	if r := testUpdate(cli, Config{}, cont); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	if got := cli.pulls[0].Platform; got != "linux/arm/v7" {
+		t.Errorf("pulled platform %q, want linux/arm/v7", got)
+	}
+	want := &ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	if got := cli.created[0].platform; !reflect.DeepEqual(got, want) {
+		t.Errorf("created with platform %+v, want %+v", got, want)
+	}
+}
+
+// pullBody records how much of the pull progress stream was consumed.
+type pullBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *pullBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+type drainClient struct {
+	*fakeClient
+	body *pullBody
+}
+
+func (c *drainClient) ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error) {
+	c.fakeClient.ImagePull(ctx, refStr, options)
+	return c.body, nil
+}
+
+func TestUpdateDrainsPullStream(t *testing.T) {
+	cont := testContainer("web")
+	cont.Image = "nginx:latest"
+	progress := strings.NewReader(`{"status":"Downloading"}` + "\n" + `{"status":"Pull complete"}` + "\n")
+	cli := &drainClient{
+		fakeClient: &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})}},
+		body:       &pullBody{Reader: progress},
+	}
+
+	if r := testUpdate(cli, Config{}, cont); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if progress.Len() != 0 {
+		t.Errorf("got %d unread bytes of pull progress, want the stream drained", progress.Len())
+	}
+	if !cli.body.closed {
+		t.Error("pull progress stream was not closed")
+	}
+}
+
+func TestUpdateReportsImageVersions(t *testing.T) {
+	version := func(id, v string) types.ImageInspect {
+		return types.ImageInspect{ID: id, Config: &container.Config{Labels: map[string]string{labelImageVersion: v}}}
+	}
+	cont := testContainer("web")
+	cont.Image, cont.ImageID = "app:latest", "sha256:old"
+	inspect := namedInspect("web", &container.HostConfig{})
+	inspect.Image = "sha256:old"
+	cli := &fakeClient{
+		inspect: map[string]types.ContainerJSON{cont.ID: inspect},
+		images: map[string]types.ImageInspect{
+			"sha256:old": version("sha256:old", "v1.2"),
+			"app:latest": version("sha256:new", "v1.3"),
+		},
+	}
+
+	r := testUpdate(cli, Config{}, cont)
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if got, want := r.Change(), "from v1.2 to v1.3"; got != want {
+		t.Errorf("got change %q, want %q", got, want)
+	}
+}
+
+func TestChangeFallsBackToImageIDs(t *testing.T) {
+	r := Result{OldImage: "sha256:0123456789abcdef", NewImage: "sha256:fedcba9876543210", NewVersion: "v2"}
+	if got, want := r.Change(), "from 0123456789ab to fedcba987654"; got != want {
+		t.Errorf("got change %q, want %q", got, want)
+	}
+}
+
+func TestUpdateSkipsContainersBeingUpdated(t *testing.T) {
+	cont := testContainer("web")
+	labeled := namedInspect("web", &container.HostConfig{})
+	labeled.Config = &container.Config{Labels: map[string]string{labelUpdating: "true"}}
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: labeled}}
+
+	if r := testUpdate(cli, Config{}, cont); r.Err != nil || r.Updated {
+		t.Errorf("got updated=%v err=%v for a container labeled %s", r.Updated, r.Err, labelUpdating)
+	}
+
+	// An update already in progress in this process
+	cli.inspect[cont.ID] = namedInspect("web", &container.HostConfig{})
+	u := New(cli, Config{}, nil)
+	u.updating.begin("web")
+	r := u.updateContainer(context.Background(), nil, cont)
+	u.updating.end("web")
+	if r.Err != nil || r.Updated {
+		t.Errorf("got updated=%v err=%v for a container already being updated", r.Updated, r.Err)
+	}
+	if len(cli.calls) != 0 {
+		t.Errorf("got calls %v, want none", cli.calls)
+	}
+}
+
+func TestDryRunDoesNotRecreate(t *testing.T) {
+	cont := testContainer("web")
+	cont.Image = "nginx:latest"
+	inspect := namedInspect("web", &container.HostConfig{CapAdd: []string{"NET_ADMIN"}})
+	inspect.Config = &container.Config{Image: "nginx:latest"}
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+
+	var logs bytes.Buffer
+	u := New(cli, Config{}, log.New(&logs, "", 0))
+	u.DryRun = true
+	if r := u.updateContainer(context.Background(), nil, cont); r.Err != nil || r.Updated {
+		t.Errorf("got updated=%v err=%v, want a dry run to leave the container alone", r.Updated, r.Err)
+	}
+	if want := []string{"pull nginx:latest"}; !reflect.DeepEqual(cli.calls, want) {
+		t.Errorf("got calls %v, want %v", cli.calls, want)
+	}
+	if !strings.Contains(logs.String(), "HostConfig.CapAdd: [NET_ADMIN] -> (dropped)") {
+		t.Errorf("dry run did not log the dropped field:\n%s", logs.String())
+	}
+}
+
+// flakyInspectClient fails the first inspections of every container.
+type flakyInspectClient struct {
+	*fakeClient
+	failures int
+}
+
+func (f *flakyInspectClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	if f.failures > 0 {
+		f.failures--
+		return types.ContainerJSON{}, errors.New("daemon busy")
+	}
+	return f.fakeClient.ContainerInspect(ctx, containerID)
+}
+
+func TestInspectRetries(t *testing.T) {
+	cont := testContainer("web")
+	cli := &flakyInspectClient{fakeClient: &fakeClient{inspect: map[string]types.ContainerJSON{
+		cont.ID: namedInspect("web", &container.HostConfig{}),
+	}}, failures: inspectAttempts - 1}
+
+	if r := testUpdate(cli, Config{}, cont); r.Err != nil || !r.Updated {
+		t.Fatalf("got %+v after transient inspect errors, want the container updated", r)
+	}
+
+	cli.failures = inspectAttempts
+	if r := testUpdate(cli, Config{}, cont); r.Stage != StageInspect {
+		t.Errorf("got stage %q after persistent inspect errors, want %q", r.Stage, StageInspect)
+	}
+}
+
+func TestInspectVanished(t *testing.T) {
+	cont := testContainer("web")
+	cli := &fakeClient{inspectErr: map[string]error{cont.ID: errdefs.NotFound(errors.New("no such container"))}}
+
+	r := testUpdate(cli, Config{}, cont)
+	if r.Err != nil || !r.Vanished {
+		t.Errorf("got %+v, want the container reported as vanished without error", r)
+	}
+}
+
+func TestMinUptime(t *testing.T) {
+	tests := []struct {
+		started time.Duration
+		want    bool
+	}{
+		{2 * time.Minute, false},
+		{time.Hour, true},
+	}
+	for _, tt := range tests {
+		cont := testContainer("web")
+		inspect := namedInspect("web", &container.HostConfig{})
+		inspect.State = &types.ContainerState{Running: true, StartedAt: time.Now().Add(-tt.started).Format(time.RFC3339Nano)}
+		cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+
+		r := testUpdate(cli, Config{MinUptime: Duration(10 * time.Minute)}, cont)
+		if r.Updated != tt.want {
+			t.Errorf("started %s ago: got updated %v, want %v", tt.started, r.Updated, tt.want)
+		}
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	own := 30
+	tests := []struct {
+		name        string
+		stopTimeout Duration
+		own         *int
+		want        int
+	}{
+		{"default", 0, nil, 10},
+		{"configured", Duration(1500 * time.Millisecond), nil, 2},
+		{"--stop-timeout", Duration(time.Minute), &own, 30},
+	}
+	for _, tt := range tests {
+		cont := testContainer("web")
+		inspect := namedInspect("web", &container.HostConfig{})
+		inspect.Config.StopTimeout = tt.own
+		cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+
+		testUpdate(cli, Config{StopTimeout: tt.stopTimeout}, cont)
+		if len(cli.stopTimeouts) != 1 || cli.stopTimeouts[0] != tt.want {
+			t.Errorf("%s: got stop timeouts %v, want %d", tt.name, cli.stopTimeouts, tt.want)
+		}
+	}
+}
+
+func TestForcedKill(t *testing.T) {
+	cont := testContainer("killed")
+	inspect := namedInspect("killed", &container.HostConfig{})
+	inspect.State = &types.ContainerState{ExitCode: 137}
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: inspect}}
+
+	var logs bytes.Buffer
+	u := New(cli, Config{}, log.New(&logs, "", 0))
+	u.updateContainer(context.Background(), nil, cont)
+	if !strings.Contains(logs.String(), "did not stop within 10s and was killed") {
+		t.Errorf("got log %q, want a forced kill warning", logs.String())
+	}
+	if got := forcedKillsTotal.values[`{container="killed"}`]; got != 1 {
+		t.Errorf("got %v forced kills, want 1", got)
+	}
+}
+
+func TestMissingImageTreatedAsOutOfDate(t *testing.T) {
+	cont := testContainer("web")
+	cont.ImageID = "sha256:old"
+	inspect := namedInspect("web", &container.HostConfig{})
+	inspect.Image = "sha256:old"
+	fixture := func() *fakeClient {
+		return &fakeClient{
+			inspect:       map[string]types.ContainerJSON{cont.ID: inspect},
+			missingImages: map[string]bool{"sha256:old": true},
+		}
+	}
+
+	// Even in security updates only mode, which cannot scan a removed image
+	cli := fixture()
+	if r := testUpdate(cli, Config{VulnerabilitySeverity: "high"}, cont); r.Err != nil || !r.Updated {
+		t.Errorf("got %+v, want the container with a removed image updated", r)
+	}
+	if !containsName(cli.calls, "pull web:latest") {
+		t.Errorf("image not pulled, calls %v", cli.calls)
+	}
+
+	cli = fixture()
+	if r := testUpdate(cli, Config{MissingImage: "skip"}, cont); r.Err != nil || r.Updated || len(cli.calls) != 0 {
+		t.Errorf("got %+v and calls %v, want the container left alone with missing_image: skip", r, cli.calls)
+	}
+}
@@ -0,0 +1,63 @@
+package updater
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// labelUpdateGroup names a group of containers that are never updated at
+// the same time, e.g. by a scan and a webhook, while containers of other
+// groups may be.
+const labelUpdateGroup = "hikup.update-group"
+
+// updateGroupLocks serializes the updates within each hikup.update-group.
+// The zero value is ready to use.
+type updateGroupLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func (l *updateGroupLocks) get(group string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks == nil {
+		l.locks = make(map[string]chan struct{})
+	}
+	lock, ok := l.locks[group]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		l.locks[group] = lock
+	}
+	return lock
+}
+
+// lock waits until no other update holds any of groups, then holds them
+// until unlock is called. Empty group names are ignored. Groups are taken
+// in sorted order, so two callers cannot deadlock.
+func (l *updateGroupLocks) lock(ctx context.Context, groups ...string) (unlock func(), err error) {
+	groups = slices.Clone(groups)
+	slices.Sort(groups)
+	groups = slices.Compact(groups)
+
+	var held []chan struct{}
+	unlock = func() {
+		for _, lock := range held {
+			<-lock
+		}
+	}
+	for _, group := range groups {
+		if group == "" {
+			continue
+		}
+		lock := l.get(group)
+		select {
+		case lock <- struct{}{}:
+			held = append(held, lock)
+		case <-ctx.Done():
+			unlock()
+			return nil, ctx.Err()
+		}
+	}
+	return unlock, nil
+}
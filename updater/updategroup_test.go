@@ -0,0 +1,85 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestUpdateGroupLocks(t *testing.T) {
+	var l updateGroupLocks
+	ctx := context.Background()
+
+	unlock, err := l.lock(ctx, "db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other groups are not held up
+	other, err := l.lock(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	acquired := make(chan struct{})
+	go func() {
+		unlock, err := l.lock(ctx, "web", "db")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		close(acquired)
+		unlock()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("group db locked twice at once")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("group db not released by unlock")
+	}
+
+	// The groups of a failed lock are released again
+	unlock, _ = l.lock(ctx, "db")
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.lock(canceled, "api", "db"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	unlock()
+	if unlock, err := l.lock(ctx, "api"); err != nil {
+		t.Errorf("group api still held after a failed lock: %v", err)
+	} else {
+		unlock()
+	}
+}
+
+func TestUpdateWaitsForUpdateGroup(t *testing.T) {
+	cont := testContainer("web")
+	cont.Labels = map[string]string{labelUpdateGroup: "frontend"}
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})}}
+	u := New(cli, Config{}, nil)
+
+	unlock, err := u.updateGroups.lock(context.Background(), "frontend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := u.updateContainer(ctx, nil, cont); r.Err == nil || len(cli.calls) != 0 {
+		t.Errorf("container updated while another update of its group was running: %+v, calls %v", r, cli.calls)
+	}
+	unlock()
+
+	if r := u.updateContainer(context.Background(), nil, cont); !r.Updated {
+		t.Errorf("got %+v, want the container updated once its group is free", r)
+	}
+}
@@ -0,0 +1,670 @@
+// Package updater implements hikup's container updates: selecting the
+// containers to manage, pulling their images and recreating them. The hikup
+// command wraps an Updater, but it can be embedded in other programs as well.
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DockerClient is the subset of the Docker API hikup relies on. It is
+// implemented by *client.Client.
+type DockerClient interface {
+	ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error
+	ContainerRename(ctx context.Context, container, newContainerName string) error
+	NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error
+	ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerExecCreate(ctx context.Context, container string, options container.ExecOptions) (types.IDResponse, error)
+	ContainerExecStart(ctx context.Context, execID string, config container.ExecStartOptions) error
+	ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error)
+	ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error)
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error)
+	ImageLoad(ctx context.Context, input io.Reader, quiet bool) (image.LoadResponse, error)
+}
+
+// Updater updates the containers selected by its configuration. The
+// exported fields must be set before the first scan.
+type Updater struct {
+	// RecreateAll selects every container regardless of the include and
+	// exclude lists.
+	RecreateAll bool
+	// NoPull never pulls images. Instead, containers whose image tag points
+	// at a different local image than the one they run are recreated.
+	NoPull bool
+	// DryRun logs what would be updated instead of recreating containers.
+	DryRun bool
+	// Scope, if set, overrides the scope of the configuration.
+	Scope string
+	// IncludeSwarm also updates containers managed by a swarm service.
+	IncludeSwarm bool
+	// Since, if set, only selects containers created at most this long ago.
+	Since time.Duration
+	// WatchImage, if set, selects exactly the containers running this image
+	// reference, e.g. "nginx", ignoring the include and exclude lists.
+	WatchImage string
+	// SmokeTest starts every new image in a throwaway container before
+	// replacing a container with it, and skips the update if it fails.
+	SmokeTest bool
+	// Debug logs details such as why containers are skipped.
+	Debug bool
+	// Confirm, if set, is asked before each container is recreated.
+	Confirm func(name, from, to string) bool
+	// Version is reported in lifecycle notifications.
+	Version string
+
+	logger *log.Logger
+	// registry is used for registry_head_check
+	registry *http.Client
+
+	mu     sync.RWMutex
+	cli    DockerClient
+	config Config
+	// settings are the per-container settings files, by container name
+	settings map[string]ContainerSettings
+	// desired is the desired state file, see desired_state_file
+	desired map[string]string
+
+	state    *stateStore
+	history  historyLog
+	updating inProgress
+	pulls    pullGroup
+	// updateGroups serializes updates within a hikup.update-group
+	updateGroups updateGroupLocks
+	// digest holds notifications back for notification_digest
+	digest digestBuffer
+	// loadedTarballs are the tarballs of image_load_dir loaded already
+	loadedTarballs loadedTarballs
+	// scanned is set once the first scan listed the containers
+	scanned atomic.Bool
+	// warnedSwarm records the swarm containers already warned about, to
+	// warn once per container rather than every scan.
+	warnedSwarm sync.Map
+}
+
+// New returns an Updater managing containers through cli according to cfg.
+// A nil logger discards all log output.
+func New(cli DockerClient, cfg Config, logger *log.Logger) *Updater {
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	return &Updater{
+		cli:      cli,
+		logger:   logger,
+		registry: &http.Client{Timeout: registryTimeout},
+		config:   cfg,
+		state:    newStateStore(""),
+		updating: inProgress{names: map[string]bool{}},
+	}
+}
+
+// Config returns the configuration in effect.
+func (u *Updater) Config() Config {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.config
+}
+
+// client returns the Docker client in use.
+func (u *Updater) client() DockerClient {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.cli
+}
+
+// SetClient replaces the Docker client, e.g. after reconnecting to a
+// restarted daemon. Updates already running finish with the previous one.
+func (u *Updater) SetClient(cli DockerClient) {
+	u.mu.Lock()
+	u.cli = cli
+	u.mu.Unlock()
+}
+
+// SetConfig replaces the configuration. Scans already running finish with
+// the previous one.
+func (u *Updater) SetConfig(cfg Config) {
+	u.mu.Lock()
+	u.config = cfg
+	u.mu.Unlock()
+}
+
+// LoadState reads per-container state, such as consecutive failure counts,
+// from the file at path and persists it there from now on. On error, the
+// Updater starts with an empty state that is still persisted to path.
+func (u *Updater) LoadState(path string) error {
+	s, err := loadState(path)
+	u.state = s
+	return err
+}
+
+// SetHistoryFile makes the Updater append every update to the JSONL log at
+// path. An empty path disables the history.
+func (u *Updater) SetHistoryFile(path string) {
+	u.history.setPath(path)
+}
+
+// ScanOnce runs one update pass. A non-nil err means the scan itself could
+// not run; results holds the outcome for every container an update was
+// attempted for. Once ctx is done, no further updates are started, but an
+// update in progress is finished so no container is left half replaced.
+func (u *Updater) ScanOnce(ctx context.Context) (results []Result, err error) {
+	ctx, span := tracer.Start(ctx, "scan")
+	defer func() { endSpan(span, err) }()
+	cfg := u.Config()
+	commandTimeout := time.Duration(cfg.WithDefaults().CycleCommandTimeout)
+
+	if cfg.PreCycleCommand != "" {
+		if err := u.runCycleCommand("pre_cycle_command", cfg.PreCycleCommand, commandTimeout); err != nil {
+			return nil, fmt.Errorf("scan aborted: %w", err)
+		}
+	}
+	if cfg.PostCycleCommand != "" {
+		defer func() {
+			updated, failed := 0, len(Failures(results))
+			for _, r := range results {
+				if r.Updated {
+					updated++
+				}
+			}
+			env := []string{fmt.Sprintf("HIKUP_UPDATED=%d", updated), fmt.Sprintf("HIKUP_FAILED=%d", failed)}
+			if err := u.runCycleCommand("post_cycle_command", cfg.PostCycleCommand, commandTimeout, env...); err != nil {
+				u.logger.Println(err)
+			}
+		}()
+	}
+
+	if cfg.ImageLoadDir != "" {
+		u.loadImages(ctx, cfg.ImageLoadDir)
+	}
+
+	containers, err := u.client().ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+	u.reloadSettings()
+	u.reloadDesiredState()
+	containers = u.withSettings(containers)
+
+	stagger := time.Duration(cfg.Stagger)
+	maxUpdates, limit := cfg.MaxUpdatesPerCycle, "max_updates_per_cycle"
+	// The first scan after startup may be gentler
+	if first := !u.scanned.Swap(true); first && cfg.StartupMaxUpdates > 0 && (maxUpdates == 0 || cfg.StartupMaxUpdates < maxUpdates) {
+		maxUpdates, limit = cfg.StartupMaxUpdates, "startup_max_updates"
+	}
+
+	cycle := newScanCycle(containers)
+
+	attempted, updated := 0, 0
+	doneGroups := make(map[string]bool)
+	doneRolling := make(map[string]bool)
+	doneCanaries := make(map[string]bool)
+	for _, cont := range cycle.orderContainers(containers) {
+		selected, reason := u.Select(cont)
+		if !selected && strings.HasPrefix(reason, "managed by swarm") {
+			if _, warned := u.warnedSwarm.LoadOrStore(cont.ID, true); !warned {
+				u.logger.Printf("Warning: skipping container %s, %s; use --include-swarm to update it anyway", containerName(cont), reason)
+			}
+		}
+		if !selected {
+			u.debugf("Skipping container %s: %s", containerName(cont), reason)
+		}
+		if selected {
+			// The members of a group are updated together when the first
+			// of them comes up
+			batch := []types.Container{cont}
+			group, inGroup := cfg.groupOf(baseName(containerName(cont), cont.Labels))
+			if inGroup {
+				if doneGroups[group.Name] {
+					continue
+				}
+				doneGroups[group.Name] = true
+				batch = u.groupMembers(group, containers)
+			}
+			// So are the replicas of a rolling update
+			key := rollingKey(cont)
+			rolling := !inGroup && key != ""
+			if rolling {
+				if doneRolling[key] {
+					continue
+				}
+				doneRolling[key] = true
+				batch = u.rollingReplicas(key, containers)
+			}
+			// And the containers of an image with a canary, which goes
+			// first
+			var canary types.Container
+			canaried := false
+			if !inGroup && !rolling {
+				var peers []types.Container
+				if canary, peers, canaried = u.canaryFleet(cont, containers); canaried {
+					if doneCanaries[imageKey(cont)] {
+						continue
+					}
+					doneCanaries[imageKey(cont)] = true
+					batch = append([]types.Container{canary}, peers...)
+				}
+			}
+
+			if deferred := deferredMember(batch, time.Now()); deferred != "" {
+				u.logger.Printf("Deferring container %s", deferred)
+				continue
+			}
+			if maxUpdates > 0 && updated >= maxUpdates {
+				u.logger.Printf("Deferring container %s to the next scan: %s (%d) reached", containerName(cont), limit, maxUpdates)
+				continue
+			}
+			if attempted > 0 && stagger > 0 {
+				wait := time.NewTimer(stagger)
+				select {
+				case <-ctx.Done():
+				case <-wait.C:
+				}
+				wait.Stop()
+			}
+			if ctx.Err() != nil {
+				u.logger.Printf("Scan interrupted, leaving the remaining containers to the next scan")
+				break
+			}
+			attempted++
+
+			updateCtx := context.WithoutCancel(ctx)
+			var batchResults []Result
+			switch {
+			case inGroup:
+				batchResults = u.updateGroup(updateCtx, cycle, group, batch)
+			case rolling:
+				batchResults = u.rollingUpdate(updateCtx, cycle, key, batch)
+			case canaried:
+				batchResults = u.canaryUpdate(updateCtx, cycle, canary, batch[1:])
+			default:
+				batchResults = []Result{u.updateContainer(updateCtx, cycle, cont)}
+			}
+			for _, result := range batchResults {
+				u.handleResult(cycle, result)
+				results = append(results, result)
+				if result.Updated {
+					updated++
+				}
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// deferredMember describes the first of containers outside its update
+// window at now, or returns "" if all may be updated.
+func deferredMember(containers []types.Container, now time.Time) string {
+	for _, cont := range containers {
+		if ok, reason := inUpdateWindow(cont, now); !ok {
+			return fmt.Sprintf("%s: %s", containerName(cont), reason)
+		}
+	}
+	return ""
+}
+
+// ErrUnmanaged is returned by UpdateContainer for a container this
+// instance must not touch.
+var ErrUnmanaged = errors.New("container has no label with the required_label_prefix")
+
+// ErrInvalidImage is returned by UpdateContainerImage for an image that is
+// not a valid reference.
+var ErrInvalidImage = errors.New("invalid image reference")
+
+// UpdateContainer updates the container with the given name right away,
+// whether or not it is selected by the configuration. err is only set if
+// the container could not be found or lacks the required_label_prefix; the
+// outcome of the update itself is reported in the result.
+func (u *Updater) UpdateContainer(ctx context.Context, name string) (Result, error) {
+	return u.UpdateContainerImage(ctx, name, "")
+}
+
+// UpdateContainerImage is UpdateContainer, but recreates the container from
+// image, e.g. "nginx:1.27", instead of the latest version of its own image.
+// Later scans follow image. An empty image updates as UpdateContainer does.
+func (u *Updater) UpdateContainerImage(ctx context.Context, name, image string) (Result, error) {
+	if image != "" {
+		ref, err := normalizeImageRef(image)
+		if err != nil {
+			return Result{}, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		image = ref
+	}
+	containers, err := u.client().ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return Result{}, fmt.Errorf("error listing containers: %w", err)
+	}
+	u.reloadSettings()
+	u.reloadDesiredState()
+	containers = u.withSettings(containers)
+	for _, cont := range containers {
+		// A container renamed by name_template is found by either name
+		if current := containerName(cont); current == name || baseName(current, cont.Labels) == name {
+			if prefix := u.Config().RequiredLabelPrefix; prefix != "" && !hasLabelPrefix(cont.Labels, prefix) {
+				return Result{}, fmt.Errorf("container %s: %w", name, ErrUnmanaged)
+			}
+			cycle := newScanCycle(containers)
+			cycle.trigger = triggerManual
+			if image != "" {
+				cycle.images = map[string]string{baseName(containerName(cont), cont.Labels): image}
+			}
+			result := u.updateContainer(ctx, cycle, cont)
+			u.handleResult(cycle, result)
+			return result, nil
+		}
+	}
+	return Result{}, fmt.Errorf("container %s not found", name)
+}
+
+// UpdateImage updates the selected containers that use ref, e.g.
+// after a registry reported a push of it. Containers running the image by
+// ID match the reference they were created from.
+func (u *Updater) UpdateImage(ctx context.Context, ref string) ([]Result, error) {
+	ref, err := normalizeImageRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := u.client().ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+	u.reloadSettings()
+	u.reloadDesiredState()
+	containers = u.withSettings(containers)
+
+	cycle := newScanCycle(containers)
+	cycle.trigger = triggerWebhook
+	var results []Result
+	for _, cont := range cycle.orderContainers(containers) {
+		image := cont.Image
+		if label := cont.Labels[labelImage]; label != "" {
+			image = label
+		}
+		if normalized, err := normalizeImageRef(image); err != nil || normalized != ref {
+			continue
+		}
+		if ok, reason := u.Select(cont); !ok {
+			u.debugf("Skipping container %s: %s", containerName(cont), reason)
+			continue
+		}
+		result := u.updateContainer(ctx, cycle, cont)
+		u.handleResult(cycle, result)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// handleResult records the outcome of a container update in the log,
+// metrics and state and sends any alert it warrants.
+func (u *Updater) handleResult(cycle *scanCycle, r Result) {
+	if r.Err != nil {
+		u.logger.Printf("Update failed (%s): %v", r.Stage, r.Err)
+	}
+	recordResult(r)
+	if r.Updated {
+		if err := u.history.record(cycle, r); err != nil {
+			u.logger.Printf("Error recording update history: %v", err)
+		}
+		u.notifyUpdate(r)
+	}
+	consecutive, err := u.state.recordOutcome(r.Container, r.Err != nil)
+	if err != nil {
+		u.logger.Printf("Error saving state: %v", err)
+	}
+	if r.Err != nil {
+		u.notifyFailure(r, consecutive)
+	}
+}
+
+// debugf logs only with Debug set.
+func (u *Updater) debugf(format string, v ...interface{}) {
+	if u.Debug {
+		u.logger.Printf(format, v...)
+	}
+}
+
+// swarmServiceLabel is set by swarm on the containers of its service tasks.
+const swarmServiceLabel = "com.docker.swarm.service.name"
+
+// swarmService returns the swarm service cont is a task of, if any.
+func swarmService(cont types.Container) (string, bool) {
+	for label := range cont.Labels {
+		if strings.HasPrefix(label, "com.docker.swarm.") {
+			return cont.Labels[swarmServiceLabel], true
+		}
+	}
+	return "", false
+}
+
+// composeServiceLabel is set by Docker Compose to the service a container
+// belongs to, independent of the replica suffix in the container name.
+const composeServiceLabel = "com.docker.compose.service"
+
+// Select decides whether cont is managed by the Updater. The reason
+// explains the decision for diagnostics.
+func (u *Updater) Select(cont types.Container) (bool, string) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	config := u.config
+
+	// Instances with different scopes never touch each other's containers,
+	// not even with -a
+	scope := config.Scope
+	if u.Scope != "" {
+		scope = u.Scope
+	}
+	if containerScope := cont.Labels[labelScope]; containerScope != scope {
+		if scope == "" {
+			return false, fmt.Sprintf("in scope %q, this instance has no scope", containerScope)
+		}
+		return false, fmt.Sprintf("not in scope %q", scope)
+	}
+
+	// Swarm reconciles its tasks itself and would fight a recreate
+	if service, ok := swarmService(cont); ok && !u.IncludeSwarm {
+		return false, fmt.Sprintf("managed by swarm service %q", service)
+	}
+
+	if u.WatchImage != "" {
+		ref := cont.Image
+		if label := cont.Labels[labelImage]; label != "" {
+			ref = label
+		} else if isImageID(ref, cont.ImageID) {
+			return true, "listed by image ID, checked against --watch-image once resolved"
+		}
+		if !u.watchesImage(ref) {
+			return false, fmt.Sprintf("image %s is not --watch-image %s", ref, u.WatchImage)
+		}
+		return true, "runs --watch-image " + u.WatchImage
+	}
+
+	if config.RequiredLabelPrefix != "" && !hasLabelPrefix(cont.Labels, config.RequiredLabelPrefix) {
+		return false, fmt.Sprintf("no label with the required_label_prefix %q", config.RequiredLabelPrefix)
+	}
+
+	if u.Since > 0 {
+		if age := time.Since(time.Unix(cont.Created, 0)); age > u.Since {
+			return false, fmt.Sprintf("created %s ago, before --since %s", age.Round(time.Second), u.Since)
+		}
+	}
+
+	name := baseName(containerName(cont), cont.Labels)
+	if _, listed := u.desired[name]; !listed {
+		if tag, ok := listedTag(cont); ok && !config.autoUpdateTag(tag) {
+			return false, fmt.Sprintf("tag %q not in auto_update_tags", tag)
+		} else if ok && tag == "latest" && config.latestPolicy() == latestSkip {
+			return false, `tag "latest" skipped by latest_policy`
+		}
+	}
+
+	if u.RecreateAll {
+		return true, "-a selects all containers"
+	}
+
+	service := cont.Labels[composeServiceLabel]
+
+	var excludedBy string
+	switch {
+	case containsName(config.ExcludeContainers, name):
+		excludedBy = "excluded by exclude_containers"
+	case service != "" && containsName(config.ExcludeServices, service):
+		excludedBy = fmt.Sprintf("service %q excluded by exclude_services", service)
+	}
+
+	// Check if '*' is in the include list
+	for _, include := range config.IncludeContainers {
+		if include == "*" {
+			// Update everything except excluded containers
+			if excludedBy != "" {
+				return false, excludedBy
+			}
+			return true, `matched "*" in include_containers`
+		}
+	}
+
+	// Check if the container is in the include list
+	if containsName(config.IncludeContainers, name) {
+		return true, "included by include_containers"
+	}
+
+	if _, ok := u.desired[name]; ok && excludedBy == "" {
+		return true, "listed in desired_state_file"
+	}
+
+	// Check if the container or its service is in an exclude list
+	if excludedBy != "" {
+		return false, excludedBy
+	}
+
+	// Check if the container's Compose service is in the include list
+	if service != "" && containsName(config.IncludeServices, service) {
+		return true, fmt.Sprintf("service %q included by include_services", service)
+	}
+
+	// If not in include or exclude list, don't update by default
+	return false, "not in any include list"
+}
+
+// Candidate is the selection decision for one container.
+type Candidate struct {
+	Name     string
+	Selected bool
+	Reason   string
+}
+
+// Candidates returns the selection decision for every container.
+func (u *Updater) Candidates(ctx context.Context) ([]Candidate, error) {
+	containers, err := u.client().ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+
+	candidates := make([]Candidate, 0, len(containers))
+	for _, cont := range containers {
+		selected, reason := u.Select(cont)
+		candidates = append(candidates, Candidate{Name: containerName(cont), Selected: selected, Reason: reason})
+	}
+	return candidates, nil
+}
+
+// ContainerStatus describes a container for status pages.
+type ContainerStatus struct {
+	Candidate
+	// Image is the image reference the container was created from and
+	// ImageID the image it runs.
+	Image, ImageID string
+	// State is the container state, e.g. "running" or "exited".
+	State string
+	// LastUpdate is when hikup last recreated the container; zero if it
+	// never did.
+	LastUpdate time.Time
+	// UpdateAvailable is set if the container's image tag points at a
+	// different local image than the one it runs, e.g. after a pull by
+	// --dry-run or a failed update.
+	UpdateAvailable bool
+}
+
+// Status returns the status of every container.
+func (u *Updater) Status(ctx context.Context) ([]ContainerStatus, error) {
+	containers, err := u.client().ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+
+	statuses := make([]ContainerStatus, 0, len(containers))
+	for _, cont := range containers {
+		selected, reason := u.Select(cont)
+		s := ContainerStatus{
+			Candidate: Candidate{Name: containerName(cont), Selected: selected, Reason: reason},
+			Image:     cont.Image,
+			ImageID:   cont.ImageID,
+			State:     cont.State,
+		}
+		if label := cont.Labels[labelImage]; label != "" {
+			s.Image = label
+		}
+		s.LastUpdate, _ = time.Parse(time.RFC3339, cont.Labels[labelLastUpdate])
+		if !isImageID(s.Image, cont.ImageID) {
+			if img, _, err := u.client().ImageInspectWithRaw(ctx, s.Image); err == nil {
+				s.UpdateAvailable = img.ID != cont.ImageID
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// normalizeName strips the single leading slash Docker puts in front of
+// container names. Degenerate names ("" or "/") normalize to "".
+func normalizeName(name string) string {
+	return strings.TrimPrefix(name, "/")
+}
+
+// containerName returns the primary name of a listed container, or "" if it
+// has none.
+func containerName(cont types.Container) string {
+	if len(cont.Names) == 0 {
+		return ""
+	}
+	return normalizeName(cont.Names[0])
+}
+
+// hasLabelPrefix reports whether any of labels starts with prefix.
+func hasLabelPrefix(labels map[string]string, prefix string) bool {
+	for key := range labels {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsName(names []string, target string) bool {
+	for _, name := range names {
+		if name == target {
+			return true
+		}
+	}
+	return false
+}
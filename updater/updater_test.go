@@ -0,0 +1,601 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeClient implements DockerClient for tests. Methods that a test does not
+// override panic through the nil embedded interface.
+type fakeClient struct {
+	DockerClient
+	containers []types.Container
+	listErr    error
+	inspectErr map[string]error
+	inspect    map[string]types.ContainerJSON
+	pullErr    error
+	images     map[string]types.ImageInspect
+	// missingImages lists image references that are not present locally.
+	missingImages map[string]bool
+	// execExitCodes are the exit codes of commands executed in containers,
+	// by container ID.
+	execExitCodes map[string]int
+	// loadOutput is the JSON stream answering ImageLoad.
+	loadOutput string
+
+	// calls records the mutating calls made, e.g. "stop web" or
+	// "create web".
+	calls     []string
+	pulls     []image.PullOptions
+	created   []createCall
+	connected []connectCall
+	// stopTimeouts records the timeout of every stop.
+	stopTimeouts []int
+}
+
+type createCall struct {
+	name       string
+	config     *container.Config
+	hostConfig *container.HostConfig
+	networking *network.NetworkingConfig
+	platform   *ocispec.Platform
+}
+
+type connectCall struct {
+	network, container string
+	endpoint           *network.EndpointSettings
+}
+
+func (f *fakeClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	return f.containers, f.listErr
+}
+
+func (f *fakeClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	return f.inspect[containerID], f.inspectErr[containerID]
+}
+
+func (f *fakeClient) ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error) {
+	f.calls = append(f.calls, "pull "+refStr)
+	f.pulls = append(f.pulls, options)
+	if f.pullErr != nil {
+		return nil, f.pullErr
+	}
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	f.calls = append(f.calls, "stop "+containerID)
+	if options.Timeout != nil {
+		f.stopTimeouts = append(f.stopTimeouts, *options.Timeout)
+	}
+	return nil
+}
+
+func (f *fakeClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	f.calls = append(f.calls, "remove "+containerID)
+	return nil
+}
+
+func (f *fakeClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	f.calls = append(f.calls, "create "+containerName)
+	f.created = append(f.created, createCall{containerName, config, hostConfig, networkingConfig, platform})
+	return container.CreateResponse{ID: "new-" + containerName + strings.Repeat("0", 12)}, nil
+}
+
+func (f *fakeClient) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	f.calls = append(f.calls, "connect "+networkID+" "+containerID)
+	f.connected = append(f.connected, connectCall{networkID, containerID, config})
+	return nil
+}
+
+func (f *fakeClient) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	f.calls = append(f.calls, "start "+containerID)
+	return nil
+}
+
+func (f *fakeClient) ContainerRename(ctx context.Context, containerID, newContainerName string) error {
+	f.calls = append(f.calls, "rename "+containerID+" "+newContainerName)
+	return nil
+}
+
+func (f *fakeClient) ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error {
+	f.calls = append(f.calls, "restart "+containerID)
+	return nil
+}
+
+func (f *fakeClient) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (types.IDResponse, error) {
+	f.calls = append(f.calls, "exec "+containerID)
+	return types.IDResponse{ID: containerID}, nil
+}
+
+func (f *fakeClient) ContainerExecStart(ctx context.Context, execID string, config container.ExecStartOptions) error {
+	return nil
+}
+
+func (f *fakeClient) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	return container.ExecInspect{ExecID: execID, ExitCode: f.execExitCodes[execID]}, nil
+}
+
+func (f *fakeClient) ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error) {
+	f.calls = append(f.calls, "rmi "+imageID)
+	return nil, nil
+}
+
+func (f *fakeClient) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (image.LoadResponse, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return image.LoadResponse{}, err
+	}
+	f.calls = append(f.calls, "load "+string(data))
+	return image.LoadResponse{Body: io.NopCloser(strings.NewReader(f.loadOutput)), JSON: true}, nil
+}
+
+// ImageInspectWithRaw returns the configured image. Unknown references
+// resolve to a made-up image ID unless listed in missingImages.
+func (f *fakeClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	if f.missingImages[imageID] {
+		return types.ImageInspect{}, nil, errdefs.NotFound(errors.New("no such image"))
+	}
+	img, ok := f.images[imageID]
+	if !ok {
+		img.ID = "sha256:" + imageID
+	}
+	return img, nil, nil
+}
+
+func init() {
+	// Inspect errors of the fake client are not transient
+	inspectRetryDelay = time.Millisecond
+}
+
+// testUpdate updates cont with a fresh Updater using cfg.
+func testUpdate(cli DockerClient, cfg Config, cont types.Container) Result {
+	return New(cli, cfg, nil).updateContainer(context.Background(), nil, cont)
+}
+
+// scanAll runs a scan with -a.
+func scanAll(cli DockerClient, cfg Config) ([]Result, error) {
+	u := New(cli, cfg, nil)
+	u.RecreateAll = true
+	return u.ScanOnce(context.Background())
+}
+
+func testContainer(name string) types.Container {
+	return types.Container{ID: name + strings.Repeat("0", 64-len(name)), Names: []string{"/" + name}, Image: name + ":latest"}
+}
+
+func TestScanAggregatesFailures(t *testing.T) {
+	a, b := testContainer("a"), testContainer("b")
+	errInspect := errors.New("inspect failed")
+	cli := &fakeClient{
+		containers: []types.Container{a, b},
+		inspectErr: map[string]error{a.ID: errInspect, b.ID: errInspect},
+	}
+
+	results, err := scanAll(cli, Config{})
+	if err != nil {
+		t.Fatalf("scan returned error: %v", err)
+	}
+	failed := Failures(results)
+	if len(failed) != 2 {
+		t.Fatalf("got %d failures, want 2", len(failed))
+	}
+	for _, err := range failed {
+		if !errors.Is(err, errInspect) {
+			t.Errorf("failure %q does not wrap the Docker error", err)
+		}
+	}
+	for _, r := range results {
+		if r.Stage != StageInspect {
+			t.Errorf("%s: got stage %q, want %q", r.Container, r.Stage, StageInspect)
+		}
+	}
+}
+
+func TestScanListError(t *testing.T) {
+	errList := errors.New("cannot connect")
+	results, err := scanAll(&fakeClient{listErr: errList}, Config{})
+	if !errors.Is(err, errList) {
+		t.Fatalf("got error %v, want it to wrap %v", err, errList)
+	}
+	if results != nil {
+		t.Errorf("got results %v, want none", results)
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := map[string]string{
+		"/web":  "web",
+		"web":   "web",
+		"/":     "",
+		"":      "",
+		"//web": "/web",
+	}
+	for in, want := range tests {
+		if got := normalizeName(in); got != want {
+			t.Errorf("normalizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestShouldUpdateDegenerateNames(t *testing.T) {
+	u := New(nil, Config{IncludeContainers: []string{"*"}, ExcludeContainers: []string{"db"}}, nil)
+
+	for _, cont := range []types.Container{
+		{Names: nil},
+		{Names: []string{}},
+		{Names: []string{""}},
+		{Names: []string{"/"}},
+	} {
+		if selected, _ := u.Select(cont); !selected {
+			t.Errorf("container with names %q should match the wildcard", cont.Names)
+		}
+	}
+	if selected, _ := u.Select(types.Container{Names: []string{"/db"}}); selected {
+		t.Error("excluded container should not be updated")
+	}
+}
+
+func TestNoPullSkipsUnchangedImage(t *testing.T) {
+	cont := testContainer("web")
+	cont.Image, cont.ImageID = "nginx:latest", "sha256:aaa"
+	cli := &fakeClient{
+		inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})},
+		images:  map[string]types.ImageInspect{"nginx:latest": {ID: "sha256:aaa"}},
+	}
+
+	u := New(cli, Config{}, nil)
+	u.NoPull = true
+	r := u.updateContainer(context.Background(), nil, cont)
+	if r.Err != nil || r.Updated {
+		t.Errorf("got updated=%v err=%v, want an unchanged container to be left alone", r.Updated, r.Err)
+	}
+}
+
+func TestShouldUpdateComposeServices(t *testing.T) {
+	service := func(name, svc string) types.Container {
+		return types.Container{Names: []string{"/" + name}, Labels: map[string]string{composeServiceLabel: svc}}
+	}
+	u := New(nil, Config{
+		IncludeContainers: []string{"proj-db-1"},
+		IncludeServices:   []string{"web", "worker"},
+		ExcludeServices:   []string{"db"},
+	}, nil)
+
+	tests := []struct {
+		cont types.Container
+		want bool
+	}{
+		{service("proj-web-1", "web"), true},
+		{service("other-web-3", "web"), true},
+		{service("proj-worker-2", "worker"), true},
+		{service("proj-cache-1", "cache"), false},
+		{service("proj-db-2", "db"), false},
+		// Explicitly included by name despite the excluded service
+		{service("proj-db-1", "db"), true},
+		{types.Container{Names: []string{"/web"}}, false},
+	}
+	for _, tt := range tests {
+		if got, _ := u.Select(tt.cont); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.cont.Names[0], got, tt.want)
+		}
+	}
+
+	u.SetConfig(Config{IncludeContainers: []string{"*"}, ExcludeServices: []string{"db"}})
+	if selected, _ := u.Select(service("proj-db-2", "db")); selected {
+		t.Error("wildcard include should honor exclude_services")
+	}
+}
+
+func TestShouldUpdateScope(t *testing.T) {
+	scoped := func(scope string) types.Container {
+		c := types.Container{Names: []string{"/web"}}
+		if scope != "" {
+			c.Labels = map[string]string{labelScope: scope}
+		}
+		return c
+	}
+	u := New(nil, Config{}, nil)
+	u.RecreateAll = true
+
+	tests := []struct {
+		instance, container string
+		want                bool
+	}{
+		{"", "", true},
+		{"", "blue", false},
+		{"blue", "", false},
+		{"blue", "blue", true},
+		{"blue", "green", false},
+	}
+	for _, tt := range tests {
+		u.SetConfig(Config{Scope: tt.instance})
+		if got, _ := u.Select(scoped(tt.container)); got != tt.want {
+			t.Errorf("instance scope %q, container scope %q: got %v, want %v", tt.instance, tt.container, got, tt.want)
+		}
+	}
+
+	// The field overrides the config
+	u.SetConfig(Config{Scope: "blue"})
+	u.Scope = "green"
+	if got, _ := u.Select(scoped("green")); !got {
+		t.Error("Scope should override the scope config")
+	}
+}
+
+func TestShouldUpdateRequiredLabelPrefix(t *testing.T) {
+	cfg := Config{IncludeContainers: []string{"*"}, RequiredLabelPrefix: "mycompany."}
+	u := New(nil, cfg, nil)
+	labeled := types.Container{Names: []string{"/web"}, Labels: map[string]string{"mycompany.team": "web"}}
+	unlabeled := types.Container{Names: []string{"/db"}, Labels: map[string]string{"other.team": "db"}}
+
+	if got, reason := u.Select(labeled); !got {
+		t.Errorf("labeled container not selected: %s", reason)
+	}
+	if got, _ := u.Select(unlabeled); got {
+		t.Error("container without the required label prefix selected")
+	}
+	u.RecreateAll = true
+	if got, _ := u.Select(unlabeled); got {
+		t.Error("container without the required label prefix selected with -a")
+	}
+
+	cont := testContainer("db")
+	cli := &fakeClient{containers: []types.Container{cont}}
+	if _, err := New(cli, cfg, nil).UpdateContainer(context.Background(), "db"); !errors.Is(err, ErrUnmanaged) {
+		t.Errorf("got error %v updating a container without the required label prefix, want ErrUnmanaged", err)
+	}
+	if len(cli.calls) != 0 {
+		t.Errorf("got calls %v, want none", cli.calls)
+	}
+}
+
+func TestScanMaxUpdatesPerCycle(t *testing.T) {
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{}}
+	for _, name := range []string{"a", "b", "c"} {
+		cont := testContainer(name)
+		cli.containers = append(cli.containers, cont)
+		cli.inspect[cont.ID] = namedInspect(name, &container.HostConfig{})
+	}
+
+	results, err := scanAll(cli, Config{MaxUpdatesPerCycle: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d updates, want 2", len(results))
+	}
+	for _, r := range results {
+		if r.Container == "c" {
+			t.Error("container c should have been deferred")
+		}
+	}
+}
+
+func TestScanStartupMaxUpdates(t *testing.T) {
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{}}
+	for _, name := range []string{"a", "b", "c"} {
+		cont := testContainer(name)
+		cli.containers = append(cli.containers, cont)
+		cli.inspect[cont.ID] = namedInspect(name, &container.HostConfig{})
+	}
+	u := New(cli, Config{StartupMaxUpdates: 1, MaxUpdatesPerCycle: 2}, nil)
+	u.RecreateAll = true
+
+	for _, want := range []int{1, 2, 2} {
+		results, err := u.ScanOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != want {
+			t.Errorf("got %d updates, want %d", len(results), want)
+		}
+	}
+}
+
+func TestScanInterruptedDuringStagger(t *testing.T) {
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{}}
+	for _, name := range []string{"a", "b"} {
+		cont := testContainer(name)
+		cli.containers = append(cli.containers, cont)
+		cli.inspect[cont.ID] = namedInspect(name, &container.HostConfig{})
+	}
+	u := New(cli, Config{Stagger: Duration(time.Hour)}, nil)
+	u.RecreateAll = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results, err := u.ScanOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Container != "a" || !results[0].Updated {
+		t.Fatalf("got results %+v, want only a updated", results)
+	}
+}
+
+func TestShouldUpdateSkipsSwarmTasks(t *testing.T) {
+	task := types.Container{
+		Names:  []string{"/web.1.abc"},
+		Labels: map[string]string{swarmServiceLabel: "web", "com.docker.swarm.task.id": "abc"},
+	}
+	u := New(nil, Config{}, nil)
+	u.RecreateAll = true
+	if selected, reason := u.Select(task); selected {
+		t.Errorf("swarm task selected (%s), want it skipped", reason)
+	}
+
+	u.IncludeSwarm = true
+	if selected, _ := u.Select(task); !selected {
+		t.Error("IncludeSwarm should select swarm tasks")
+	}
+}
+
+func TestWithHikupLabels(t *testing.T) {
+	orig := map[string]string{"app": "web"}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	got := withHikupLabels(orig, now)
+	want := map[string]string{
+		"app":           "web",
+		labelManaged:    "true",
+		labelLastUpdate: "2024-05-01T12:00:00Z",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got labels %v, want %v", got, want)
+	}
+	if len(orig) != 1 {
+		t.Errorf("original labels were modified: %v", orig)
+	}
+}
+
+func TestSelectSince(t *testing.T) {
+	u := New(nil, Config{}, nil)
+	u.RecreateAll = true
+	u.Since = time.Hour
+
+	fresh := types.Container{Names: []string{"/fresh"}, Created: time.Now().Add(-10 * time.Minute).Unix()}
+	old := types.Container{Names: []string{"/old"}, Created: time.Now().Add(-2 * time.Hour).Unix()}
+	if selected, reason := u.Select(fresh); !selected {
+		t.Errorf("container created 10m ago not selected: %s", reason)
+	}
+	if selected, _ := u.Select(old); selected {
+		t.Error("container created 2h ago selected with --since 1h")
+	}
+}
+
+func TestUpdateImage(t *testing.T) {
+	web, api := testContainer("web"), testContainer("api")
+	web.Image = "nginx"
+	cli := &fakeClient{
+		containers: []types.Container{web, api},
+		inspect: map[string]types.ContainerJSON{
+			web.ID: namedInspect("web", &container.HostConfig{}),
+			api.ID: namedInspect("api", &container.HostConfig{}),
+		},
+	}
+	u := New(cli, Config{}, nil)
+	u.RecreateAll = true
+
+	results, err := u.UpdateImage(context.Background(), "docker.io/library/nginx:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Container != "web" {
+		t.Errorf("got results %+v, want only web updated", results)
+	}
+}
+
+func TestUpdateContainerImage(t *testing.T) {
+	cont := testContainer("web")
+	cli := &fakeClient{
+		containers: []types.Container{cont},
+		inspect:    map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})},
+	}
+	u := New(cli, Config{}, nil)
+
+	result, err := u.UpdateContainerImage(context.Background(), "web", "nginx:1.27")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Updated {
+		t.Fatalf("container not updated: %v", result.Err)
+	}
+	if !containsName(cli.calls, "pull nginx:1.27") {
+		t.Errorf("requested image not pulled, got calls %v", cli.calls)
+	}
+	if got := cli.created[0].config.Image; got != "nginx:1.27" {
+		t.Errorf("recreated from %s, want the requested nginx:1.27", got)
+	}
+
+	if _, err := u.UpdateContainerImage(context.Background(), "web", "Not An Image"); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("got error %v for an invalid image, want ErrInvalidImage", err)
+	}
+}
+
+func TestSelectAutoUpdateTags(t *testing.T) {
+	u := New(nil, Config{IncludeContainers: []string{"*"}, AutoUpdateTags: []string{"stable", "latest"}}, nil)
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{"nginx:stable", true},
+		{"nginx", true},
+		{"nginx:1.27", false},
+		{"nginx@sha256:" + strings.Repeat("a", 64), false},
+		{"sha256:" + strings.Repeat("b", 64), true}, // checked once resolved
+	}
+	for _, tt := range tests {
+		cont := testContainer("web")
+		cont.Image, cont.ImageID = tt.image, "sha256:"+strings.Repeat("b", 64)
+		if got, reason := u.Select(cont); got != tt.want {
+			t.Errorf("%s: got selected=%v (%s), want %v", tt.image, got, reason, tt.want)
+		}
+	}
+
+	cont := testContainer("web")
+	cont.Image = "web:1.0"
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})}}
+	if r := testUpdate(cli, Config{AutoUpdateTags: []string{"stable"}}, cont); r.Updated || r.Err != nil {
+		t.Errorf("container on a tag not in auto_update_tags updated: %+v", r)
+	}
+}
+
+func TestLatestPolicy(t *testing.T) {
+	u := New(nil, Config{IncludeContainers: []string{"*"}, LatestPolicy: "skip"}, nil)
+	for image, want := range map[string]bool{"nginx": false, "nginx:latest": false, "nginx:1.27": true} {
+		cont := testContainer("web")
+		cont.Image = image
+		if got, reason := u.Select(cont); got != want {
+			t.Errorf("%s: got selected=%v (%s), want %v", image, got, reason, want)
+		}
+	}
+
+	cont := testContainer("web")
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})}}
+	if r := testUpdate(cli, Config{LatestPolicy: "skip"}, cont); r.Updated || r.Err != nil {
+		t.Errorf("container on the latest tag updated with latest_policy skip: %+v", r)
+	}
+	cli = &fakeClient{inspect: map[string]types.ContainerJSON{cont.ID: namedInspect("web", &container.HostConfig{})}}
+	if r := testUpdate(cli, Config{LatestPolicy: "warn"}, cont); !r.Updated {
+		t.Errorf("container on the latest tag not updated with latest_policy warn: %+v", r)
+	}
+
+	if err := (Config{LatestPolicy: "never"}).Validate(); err == nil {
+		t.Error("unknown latest_policy accepted")
+	}
+}
+
+func TestSelectWatchImage(t *testing.T) {
+	u := New(nil, Config{ExcludeContainers: []string{"web"}}, nil)
+	u.WatchImage = "nginx"
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{"nginx:latest", true},
+		{"docker.io/library/nginx", true},
+		{"nginx:1.27", false},
+		{"web:latest", false},
+		{"sha256:" + strings.Repeat("b", 64), true}, // checked once resolved
+	}
+	for _, tt := range tests {
+		cont := testContainer("web")
+		cont.Image, cont.ImageID = tt.image, "sha256:"+strings.Repeat("b", 64)
+		if got, reason := u.Select(cont); got != tt.want {
+			t.Errorf("%s: got selected=%v (%s), want %v", tt.image, got, reason, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+// volumesFromRefersTo reports whether the VolumesFrom entry
+// "<ref>[:ro|:rw]" refers to the container with the given ID or name.
+func volumesFromRefersTo(entry, id, name string) bool {
+	ref, _, _ := strings.Cut(entry, ":")
+	return ref != "" && (ref == name || strings.HasPrefix(id, ref))
+}
+
+// recreateVolumesFromDependents recreates the containers that mount the
+// volumes of the container oldID, formerly named oldName and replaced by one
+// named newName, with --volumes-from. Otherwise they keep the volumes of the
+// removed container, which are not the anonymous volumes of its replacement.
+// Failures are only logged, since the update itself already succeeded.
+func (u *Updater) recreateVolumesFromDependents(ctx context.Context, cycle *scanCycle, oldID, oldName, newName string) {
+	cli := u.client()
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		u.logger.Printf("Error listing the containers using the volumes of %s: %v", newName, err)
+		return
+	}
+	for _, cont := range containers {
+		if cont.ID == oldID {
+			continue
+		}
+		inspectData, err := inspectContainer(ctx, cli, cont.ID)
+		if err != nil || inspectData.HostConfig == nil || inspectData.Config == nil {
+			continue
+		}
+		if !slices.ContainsFunc(inspectData.HostConfig.VolumesFrom, func(entry string) bool {
+			return volumesFromRefersTo(entry, oldID, oldName)
+		}) {
+			continue
+		}
+
+		name := baseName(containerName(cont), cont.Labels)
+		if !u.updating.begin(name) {
+			u.logger.Printf("Not recreating container %s using the volumes of %s: an update of it is in progress", name, newName)
+			continue
+		}
+		if err := u.recreateDependent(ctx, cycle, cont.ID, inspectData, oldID, oldName, newName); err != nil {
+			u.logger.Printf("Error recreating container %s using the volumes of %s: %v", name, newName, err)
+		} else {
+			u.logger.Printf("Recreated container %s to use the volumes of the updated container %s", name, newName)
+		}
+		u.updating.end(name)
+	}
+}
+
+// recreateDependent recreates the inspected container id from the image
+// reference it was created with, referring to newName instead of the
+// container it took its volumes from.
+func (u *Updater) recreateDependent(ctx context.Context, cycle *scanCycle, id string, inspectData types.ContainerJSON, oldID, oldName, newName string) error {
+	cli := u.client()
+	if err := u.stopContainer(ctx, id, inspectData); err != nil {
+		return fmt.Errorf("error stopping it: %w", err)
+	}
+	err := cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: true})
+	if err != nil && !(inspectData.HostConfig.AutoRemove && errdefs.IsNotFound(err)) {
+		return fmt.Errorf("error removing it: %w", err)
+	}
+
+	config, hostConfig, networkingConfig := recreateConfig(inspectData, inspectData.Config.Image)
+	cycle.resolveReferences(hostConfig)
+	for i, entry := range hostConfig.VolumesFrom {
+		if volumesFromRefersTo(entry, oldID, oldName) {
+			_, mode, hasMode := strings.Cut(entry, ":")
+			hostConfig.VolumesFrom[i] = newName
+			if hasMode {
+				hostConfig.VolumesFrom[i] += ":" + mode
+			}
+		}
+	}
+	name := normalizeName(inspectData.Name)
+	resp, err := createContainer(ctx, cli, config, hostConfig, networkingConfig, nil, name)
+	if err != nil {
+		return err
+	}
+	cycle.rename(id, name)
+	if inspectData.State != nil && inspectData.State.Running {
+		if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+			return fmt.Errorf("error starting it: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package updater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestResolveVolumesFrom(t *testing.T) {
+	data := testContainer("data")
+	cycle := newScanCycle([]types.Container{data})
+	got := cycle.resolveVolumesFrom([]string{data.ID + ":ro", data.ID[:12], "other"})
+	if want := []string{"data:ro", "data", "other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestVolumesFromSurvivesUpdate(t *testing.T) {
+	data, app := testContainer("data"), testContainer("app")
+	appInspect := namedInspect("app", &container.HostConfig{VolumesFrom: []string{data.ID + ":ro"}})
+	appInspect.Config.Image = "app:1.0"
+	appInspect.State = &types.ContainerState{Running: true}
+	cli := &fakeClient{
+		containers: []types.Container{data, app},
+		inspect: map[string]types.ContainerJSON{
+			data.ID: namedInspect("data", &container.HostConfig{}),
+			app.ID:  appInspect,
+		},
+	}
+
+	if r := testUpdate(cli, Config{}, data); !r.Updated {
+		t.Fatalf("got %+v, want data updated", r)
+	}
+	if containsName(cli.calls, "create app") {
+		t.Error("dependent recreated without recreate_volumes_from_dependents")
+	}
+
+	cli.calls, cli.created = nil, nil
+	if r := testUpdate(cli, Config{RecreateVolumesFromDependents: true}, data); !r.Updated {
+		t.Fatalf("got %+v, want data updated", r)
+	}
+	if len(cli.created) != 2 || cli.created[1].name != "app" {
+		t.Fatalf("got calls %v, want data and then app recreated", cli.calls)
+	}
+	dependent := cli.created[1]
+	if got, want := dependent.hostConfig.VolumesFrom, []string{"data:ro"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependent recreated with VolumesFrom %v, want %v", got, want)
+	}
+	if dependent.config.Image != "app:1.0" {
+		t.Errorf("dependent recreated from %s, want its own image app:1.0", dependent.config.Image)
+	}
+	if !containsName(cli.calls, "start new-app000000000000") {
+		t.Errorf("running dependent not started again, calls %v", cli.calls)
+	}
+}
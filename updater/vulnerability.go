@@ -0,0 +1,105 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// severities are the vulnerability severities trivy reports, from lowest to
+// highest.
+var severities = []string{"LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// trivyCommand is the trivy binary; a variable so tests can replace it.
+var trivyCommand = "trivy"
+
+const trivyTimeout = 5 * time.Minute
+
+func parseSeverity(s string) (string, error) {
+	for _, severity := range severities {
+		if strings.EqualFold(s, severity) {
+			return severity, nil
+		}
+	}
+	return "", fmt.Errorf("unknown vulnerability_severity %q", s)
+}
+
+// severitiesFrom returns min and every severity above it.
+func severitiesFrom(min string) []string {
+	for i, severity := range severities {
+		if severity == min {
+			return severities[i:]
+		}
+	}
+	return nil
+}
+
+// trivyReport is the part of `trivy image --format json` hikup reads.
+type trivyReport struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID string
+			Severity        string
+		}
+	}
+}
+
+// scanVulnerabilities runs trivy against the local image imageID and
+// returns the IDs of the vulnerabilities of severity min or higher it finds.
+// With server set, trivy runs as a client of that trivy server.
+func scanVulnerabilities(ctx context.Context, imageID, min, server string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, trivyTimeout)
+	defer cancel()
+
+	args := []string{"image", "--quiet", "--format", "json", "--image-src", "docker",
+		"--severity", strings.Join(severitiesFrom(min), ",")}
+	if server != "" {
+		args = append(args, "--server", server)
+	}
+	cmd := exec.CommandContext(ctx, trivyCommand, append(args, imageID)...)
+	cmd.WaitDelay = time.Second
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("trivy timed out after %s", trivyTimeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("trivy failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("trivy failed: %w", err)
+	}
+
+	var report trivyReport
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("error parsing trivy report: %w", err)
+	}
+	seen := make(map[string]bool)
+	var ids []string
+	for _, result := range report.Results {
+		for _, v := range result.Vulnerabilities {
+			// trivy already filters, but a server may be configured to
+			// report more
+			if !containsName(severitiesFrom(min), v.Severity) || seen[v.VulnerabilityID] {
+				continue
+			}
+			seen[v.VulnerabilityID] = true
+			ids = append(ids, v.VulnerabilityID)
+		}
+	}
+	return ids, nil
+}
+
+// vulnerabilitySummary lists the first few ids for a log line.
+func vulnerabilitySummary(ids []string) string {
+	const max = 5
+	if len(ids) <= max {
+		return strings.Join(ids, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(ids[:max], ", "), len(ids)-max)
+}
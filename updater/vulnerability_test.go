@@ -0,0 +1,64 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestVulnerabilityFilter(t *testing.T) {
+	// A fake trivy reporting a critical vulnerability in sha256:vulnerable,
+	// a low one in sha256:clean, and failing for any other image
+	trivy := filepath.Join(t.TempDir(), "trivy")
+	script := `#!/bin/sh
+for arg; do image=$arg; done
+case "$image" in
+sha256:vulnerable) echo '{"Results":[{"Vulnerabilities":[{"VulnerabilityID":"CVE-2024-1","Severity":"CRITICAL"},{"VulnerabilityID":"CVE-2024-1","Severity":"CRITICAL"}]}]}';;
+sha256:clean) echo '{"Results":[{"Vulnerabilities":[{"VulnerabilityID":"CVE-2024-2","Severity":"LOW"}]}]}';;
+*) echo 'image not found' >&2; exit 1;;
+esac
+`
+	if err := os.WriteFile(trivy, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	trivyCommand = trivy
+	defer func() { trivyCommand = "trivy" }()
+
+	inspect := func(name, image string) types.ContainerJSON {
+		inspect := namedInspect(name, &container.HostConfig{})
+		inspect.Image = image
+		return inspect
+	}
+	vulnerable, clean, unknown := testContainer("vulnerable"), testContainer("clean"), testContainer("unknown")
+	cli := &fakeClient{inspect: map[string]types.ContainerJSON{
+		vulnerable.ID: inspect("vulnerable", "sha256:vulnerable"),
+		clean.ID:      inspect("clean", "sha256:clean"),
+		unknown.ID:    inspect("unknown", "sha256:unknown"),
+	}}
+	cfg := Config{VulnerabilitySeverity: "high"}
+
+	if r := testUpdate(cli, cfg, vulnerable); r.Err != nil || !r.Updated {
+		t.Errorf("vulnerable container not updated: %v", r.Err)
+	}
+	if r := testUpdate(cli, cfg, clean); r.Err != nil || r.Updated {
+		t.Errorf("container without high vulnerabilities updated (err=%v)", r.Err)
+	}
+	if containsName(cli.calls, "pull clean:latest") {
+		t.Error("image of a container without high vulnerabilities pulled")
+	}
+	if r := testUpdate(cli, cfg, unknown); r.Stage != StageVerify {
+		t.Errorf("got stage %q err=%v when trivy fails, want %q", r.Stage, r.Err, StageVerify)
+	}
+}
+
+func TestValidateVulnerabilitySeverity(t *testing.T) {
+	if err := (Config{VulnerabilitySeverity: "Critical"}).Validate(); err != nil {
+		t.Errorf("got error %v for severity Critical", err)
+	}
+	if err := (Config{VulnerabilitySeverity: "severe"}).Validate(); err == nil {
+		t.Error("no error for unknown severity")
+	}
+}
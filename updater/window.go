@@ -0,0 +1,70 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// labelWindow restricts the updates of a container to a daily time window,
+// e.g. "02:00-04:00".
+const labelWindow = "hikup.window"
+
+// timeWindow is a daily window in minutes since midnight, local time. A
+// window with end < start spans midnight.
+type timeWindow struct {
+	start, end int
+}
+
+// parseWindow parses a window like "02:00-04:00" or "23:30-01:00".
+func parseWindow(s string) (timeWindow, error) {
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return timeWindow{}, fmt.Errorf("window %q: expected HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(strings.TrimSpace(startStr))
+	if err != nil {
+		return timeWindow{}, fmt.Errorf("window %q: %w", s, err)
+	}
+	end, err := parseClock(strings.TrimSpace(endStr))
+	if err != nil {
+		return timeWindow{}, fmt.Errorf("window %q: %w", s, err)
+	}
+	return timeWindow{start, end}, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether t's local time of day falls into the window.
+func (w timeWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// inUpdateWindow reports whether cont may be updated at now according to its
+// hikup.window label. The reason explains a deferral.
+func inUpdateWindow(cont types.Container, now time.Time) (bool, string) {
+	label, ok := cont.Labels[labelWindow]
+	if !ok {
+		return true, ""
+	}
+	w, err := parseWindow(label)
+	if err != nil {
+		return false, fmt.Sprintf("invalid %s label: %v", labelWindow, err)
+	}
+	if !w.contains(now) {
+		return false, fmt.Sprintf("outside its update window %s", label)
+	}
+	return true, ""
+}
@@ -0,0 +1,38 @@
+package updater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestInUpdateWindow(t *testing.T) {
+	at := func(clock string) time.Time {
+		t, _ := time.ParseInLocation("15:04", clock, time.Local)
+		return t
+	}
+	tests := []struct {
+		window, now string
+		want        bool
+	}{
+		{"02:00-04:00", "03:15", true},
+		{"02:00-04:00", "02:00", true},
+		{"02:00-04:00", "04:00", false},
+		{"02:00-04:00", "12:00", false},
+		{"23:30-01:00", "23:45", true},
+		{"23:30-01:00", "00:30", true},
+		{"23:30-01:00", "01:30", false},
+		{"2am-4am", "03:00", false},
+	}
+	for _, tt := range tests {
+		cont := types.Container{Labels: map[string]string{labelWindow: tt.window}}
+		if got, _ := inUpdateWindow(cont, at(tt.now)); got != tt.want {
+			t.Errorf("window %s at %s: got %v, want %v", tt.window, tt.now, got, tt.want)
+		}
+	}
+
+	if ok, _ := inUpdateWindow(types.Container{}, at("12:00")); !ok {
+		t.Error("a container without a window should always be updatable")
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+func init() {
+	updater.SetBuildInfo(version, commit, buildDate)
+}
+
+// versionString describes the running build, e.g. for --version.
+func versionString() string {
+	return fmt.Sprintf("hikup %s (commit %s, built %s)", version, commit, buildDate)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+// maxWebhookBody limits the size of webhook requests.
+const maxWebhookBody = 1 << 20
+
+// webhookParsers extract the pushed image references from the body of a
+// registry's push webhook, by registry type.
+var webhookParsers = map[string]func(body []byte) ([]string, error){
+	"harbor":       parseHarborWebhook,
+	"dockerhub":    parseDockerHubWebhook,
+	"distribution": parseDistributionWebhook,
+}
+
+// parseHarborWebhook parses a Harbor PUSH_ARTIFACT event.
+func parseHarborWebhook(body []byte) ([]string, error) {
+	var event struct {
+		Type      string `json:"type"`
+		EventData struct {
+			Resources []struct {
+				ResourceURL string `json:"resource_url"`
+			} `json:"resources"`
+		} `json:"event_data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if event.Type != "PUSH_ARTIFACT" {
+		return nil, nil
+	}
+	var refs []string
+	for _, res := range event.EventData.Resources {
+		// Pushes by digest only have no tag to match containers with
+		if res.ResourceURL != "" && !strings.Contains(res.ResourceURL, "@") {
+			refs = append(refs, res.ResourceURL)
+		}
+	}
+	return refs, nil
+}
+
+// parseDockerHubWebhook parses a Docker Hub repository webhook.
+func parseDockerHubWebhook(body []byte) ([]string, error) {
+	var event struct {
+		PushData struct {
+			Tag string `json:"tag"`
+		} `json:"push_data"`
+		Repository struct {
+			RepoName string `json:"repo_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if event.Repository.RepoName == "" || event.PushData.Tag == "" {
+		return nil, nil
+	}
+	return []string{event.Repository.RepoName + ":" + event.PushData.Tag}, nil
+}
+
+// parseDistributionWebhook parses the notifications of the CNCF
+// distribution registry, which GitLab's container registry sends as well.
+func parseDistributionWebhook(body []byte) ([]string, error) {
+	var envelope struct {
+		Events []struct {
+			Action string `json:"action"`
+			Target struct {
+				Repository string `json:"repository"`
+				Tag        string `json:"tag"`
+			} `json:"target"`
+			Request struct {
+				Host string `json:"host"`
+			} `json:"request"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+	var refs []string
+	for _, event := range envelope.Events {
+		if event.Action != "push" || event.Target.Tag == "" {
+			continue
+		}
+		ref := event.Target.Repository + ":" + event.Target.Tag
+		if event.Request.Host != "" {
+			ref = event.Request.Host + "/" + ref
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// tokenAuthorized reports whether r carries secret, either in the
+// Authorization header, optionally as a bearer token, or in the token query
+// parameter for registries like Docker Hub that cannot set headers.
+func tokenAuthorized(r *http.Request, secret string) bool {
+	given := r.Header.Get("Authorization")
+	given = strings.TrimPrefix(given, "Bearer ")
+	if given == "" {
+		given = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+func webhookHandler(u *updater.Updater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret := u.Config().WebhookSecret
+		if secret == "" {
+			http.Error(w, "webhooks are not enabled, see webhook_secret", http.StatusNotFound)
+			return
+		}
+		if !tokenAuthorized(r, secret) {
+			http.Error(w, "invalid webhook secret", http.StatusUnauthorized)
+			return
+		}
+		parse, ok := webhookParsers[r.PathValue("type")]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown webhook type %q", r.PathValue("type")), http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		refs, err := parse(body)
+		if err != nil {
+			http.Error(w, "invalid webhook: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Updates can take minutes, longer than registries wait for an answer
+		for _, ref := range refs {
+			logger.Printf("Webhook reported a push of %s", ref)
+			go func(ref string) {
+				if _, err := u.UpdateImage(context.Background(), ref); err != nil {
+					logger.Printf("Error updating containers using %s: %v", ref, err)
+				}
+			}(ref)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(map[string][]string{"images": refs}); err != nil {
+			logger.Printf("Error writing HTTP response: %v", err)
+		}
+	}
+}
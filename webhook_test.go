@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lnksz/hikup/updater"
+)
+
+func TestWebhookParsers(t *testing.T) {
+	tests := []struct {
+		typ, body string
+		want      []string
+	}{
+		{"harbor", `{"type":"PUSH_ARTIFACT","event_data":{"resources":[{"resource_url":"harbor.example.com/library/app:1.0"}]}}`,
+			[]string{"harbor.example.com/library/app:1.0"}},
+		{"harbor", `{"type":"DELETE_ARTIFACT","event_data":{"resources":[{"resource_url":"harbor.example.com/library/app:1.0"}]}}`, nil},
+		{"dockerhub", `{"push_data":{"tag":"latest"},"repository":{"repo_name":"owner/app"}}`, []string{"owner/app:latest"}},
+		{"distribution", `{"events":[{"action":"pull","target":{"repository":"app","tag":"1"}},` +
+			`{"action":"push","target":{"repository":"group/app","tag":"2"},"request":{"host":"registry.example.com"}}]}`,
+			[]string{"registry.example.com/group/app:2"}},
+	}
+	for _, tt := range tests {
+		got, err := webhookParsers[tt.typ]([]byte(tt.body))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.typ, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s: got %v, want %v", tt.typ, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	u := updater.New(&listClient{}, updater.Config{WebhookSecret: "s3cret"}, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook/{type}", webhookHandler(u))
+	body := `{"push_data":{"tag":"latest"},"repository":{"repo_name":"owner/app"}}`
+
+	tests := []struct {
+		target, auth string
+		want         int
+	}{
+		{"/webhook/dockerhub", "", http.StatusUnauthorized},
+		{"/webhook/dockerhub", "Bearer wrong", http.StatusUnauthorized},
+		{"/webhook/dockerhub", "Bearer s3cret", http.StatusAccepted},
+		{"/webhook/dockerhub?token=s3cret", "", http.StatusAccepted},
+		{"/webhook/quay", "s3cret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.target, strings.NewReader(body))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s with %q: got status %d, want %d", tt.target, tt.auth, rec.Code, tt.want)
+		}
+	}
+}